@@ -6,12 +6,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/mammadmodi/websub/internal/api/inbound"
+	"github.com/mammadmodi/websub/internal/api/schema"
+	"github.com/mammadmodi/websub/internal/api/webhook"
 	"github.com/mammadmodi/websub/internal/api/websocket"
 	"github.com/mammadmodi/websub/internal/app"
-	"github.com/mammadmodi/websub/pkg/hub"
 	"github.com/mammadmodi/websub/pkg/logger"
-	"github.com/mammadmodi/websub/pkg/nats"
 	"github.com/mammadmodi/websub/pkg/redis"
+	"github.com/mammadmodi/websub/pkg/tenant"
 	"github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
@@ -45,35 +47,77 @@ func init() {
 		panic(fmt.Errorf("error while initializing logger, error: %v", err))
 	}
 
-	var h hub.Hub
-	switch c.HubDriver {
-	case app.RedisHub:
-		// initializing redis client
-		rc, err := redis.NewClient(c.RedisConfigs)
+	h, err := app.NewHub(context.Background(), c, l)
+	if err != nil {
+		l.Fatalf("error while initializing hub, error: %v", err)
+	}
+	sh := websocket.NewSockHub(c.SockHubConfig, h, l)
+	sh.Inbound, err = websocket.NewChain(c.SockHubConfig, c.SockHubConfig.InboundMiddlewares...)
+	if err != nil {
+		l.Fatalf("error while creating inbound middlewares, error: %v", err)
+	}
+	sh.Outbound, err = websocket.NewChain(c.SockHubConfig, c.SockHubConfig.OutboundMiddlewares...)
+	if err != nil {
+		l.Fatalf("error while creating outbound middlewares, error: %v", err)
+	}
+
+	// loading schemas of topics
+	var sr *schema.Registry
+	if c.SchemaConfigs.Enabled {
+		sr, err = schema.NewRegistry(c.SchemaConfigs, l)
 		if err != nil {
-			l.Fatalf("error while initializing redis client, error: %v", err)
+			l.Fatalf("error while loading topic schemas, error: %v", err)
 		}
-		_, err = rc.Ping().Result()
+		sh.Schemas = sr
+	}
+
+	// loading tenants, topics of tenants are namespaced in socket connections and inbound webhooks
+	var tr *tenant.Registry
+	if c.TenantConfigs.Enabled {
+		tr, err = tenant.Load(c.TenantConfigs.File)
 		if err != nil {
-			l.Fatalf("cannot get ping response with redis client, error: %v", err)
+			l.Fatalf("error while loading tenants, error: %v", err)
 		}
+		sh.Tenants = tr
+	}
 
-		h = hub.NewRedisHub(rc, l, &hub.RedisHubConfig{})
-	case app.NatsHub:
-		// initializing nats client
-		nc, err := nats.NewClient(c.NatsConfigs)
+	// initializing webhook dispatcher
+	var wd *webhook.Dispatcher
+	if c.WebhookConfigs.Enabled {
+		var store webhook.Store
+		switch c.WebhookConfigs.Store {
+		case webhook.FileStoreName:
+			store = webhook.NewFileStore(c.WebhookConfigs.FilePath)
+		case webhook.RedisStoreName:
+			rc, err := redis.NewClient(c.RedisConfigs)
+			if err != nil {
+				l.Fatalf("error while initializing redis client, error: %v", err)
+			}
+			store = webhook.NewRedisStore(rc, c.WebhookConfigs.RedisKey)
+		default:
+			l.Fatalf("'%s' is not a valid webhook store", c.WebhookConfigs.Store)
+		}
+		wd = webhook.NewDispatcher(c.WebhookConfigs, store, h, l)
+	}
+
+	// initializing inbound webhook receiver
+	var ir *inbound.Receiver
+	if c.InboundConfigs.Enabled {
+		ir, err = inbound.NewReceiver(c.InboundConfigs, h, l)
 		if err != nil {
-			l.Fatalf("error while initializing nats client, error: %v", err)
+			l.Fatalf("error while initializing inbound webhook receiver, error: %v", err)
 		}
-		h = hub.NewNatsHub(nc, l, &hub.NatsHubConfig{})
+		ir.Tenants = tr
 	}
-	sh := websocket.NewSockHub(c.SockHubConfig, h, l)
 
 	// initializing application instance
 	a = &app.App{
-		Config:  c,
-		Logger:  l,
-		SockHub: sh,
+		Config:   c,
+		Logger:   l,
+		SockHub:  sh,
+		Webhooks: wd,
+		Hooks:    ir,
+		Schemas:  sr,
 	}
 }
 
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mammadmodi/websub/internal/api/websocket"
+)
+
+// connections lists connections of the server.
+func connections(ctx context.Context, args []string) error {
+	var (
+		c  common
+		fs = flag.NewFlagSet("connections", flag.ExitOnError)
+	)
+	c.register(fs)
+	fs.StringVar(&c.username, "username", "", "list connections of this username")
+	_ = fs.Parse(args)
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	var cs []*websocket.ConnectionInfo
+	if err := c.get(ctx, "/socket/connections", url.Values{"username": {c.username}}, &cs); err != nil {
+		return err
+	}
+	if c.output == JSONOutput {
+		return c.print(os.Stdout, cs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tTOPICS\tGROUP\tREMOTE ADDRESS\tAGE")
+	for _, ci := range cs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", ci.ID, ci.Username, strings.Join(ci.Topics, ","), ci.Group,
+			ci.RemoteAddr, time.Since(ci.ConnectedAt).Truncate(time.Second))
+	}
+	return w.Flush()
+}
+
+// presence lists users of a topic on the server.
+func presence(ctx context.Context, args []string) error {
+	var (
+		c     common
+		topic string
+		fs    = flag.NewFlagSet("presence", flag.ExitOnError)
+	)
+	c.register(fs)
+	fs.StringVar(&topic, "topic", "", "topic that users receive messages of")
+	_ = fs.Parse(args)
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if topic == "" {
+		return fmt.Errorf("topic cannot be empty")
+	}
+
+	p := &websocket.Presence{}
+	if err := c.get(ctx, "/socket/presence", url.Values{"topic": {topic}}, p); err != nil {
+		return err
+	}
+	if c.output == JSONOutput {
+		return c.print(os.Stdout, p)
+	}
+	for _, u := range p.Users {
+		fmt.Println(u)
+	}
+	return nil
+}
+
+// get requests an admin api of the server and decodes the json response to v.
+func (c *common) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.server, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("error while creating request, error: %s", err.Error())
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error while requesting %s, error: %s", path, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s responded with status %d, %s", path, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error while decoding response of %s, error: %s", path, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mammadmodi/websub/internal/bench"
+	"github.com/mammadmodi/websub/pkg/client"
+)
+
+// benchmark connects subscribers to topics, publishes messages at a rate and reports fan-out latency, loss and
+// resource usage, it runs against a local server when -local is set.
+func benchmark(ctx context.Context, args []string) error {
+	var (
+		c             common
+		config        bench.Config
+		direct        bool
+		local, metric string
+		fs            = flag.NewFlagSet("bench", flag.ExitOnError)
+	)
+	c.register(fs)
+	fs.IntVar(&config.Connections, "connections", 10, "number of subscriber connections")
+	fs.IntVar(&config.Topics, "topics", 1, "number of topics")
+	fs.StringVar(&config.Distribution, "distribution", bench.Uniform, "distribution of connections between topics, uniform or zipf")
+	fs.IntVar(&config.Messages, "messages", 100, "number of messages that are published, messages are spread evenly between topics")
+	fs.Float64Var(&config.Rate, "rate", 100, "published messages per second")
+	fs.IntVar(&config.PayloadSize, "payload-size", 0, "bytes of padding that are added to messages")
+	fs.IntVar(&config.DialConcurrency, "dial-concurrency", 50, "number of connections that are opened concurrently")
+	fs.DurationVar(&config.Drain, "drain", 5*time.Second, "duration to wait for deliveries after the last publish")
+	fs.StringVar(&metric, "metrics", "", "prometheus endpoint of the server, it's <server>/metrics by default and - disables it")
+	fs.BoolVar(&direct, "hub", false, "publish directly to the hub instead of the websocket endpoint")
+	fs.StringVar(&local, "local", "", "run against a server in this process with a memory or miniredis hub")
+	_ = fs.Parse(args)
+	if err := c.validate(); err != nil {
+		return err
+	}
+	config.Token = c.token
+
+	var pub bench.Publisher
+	switch {
+	case local != "":
+		l, err := bench.StartLocal(local, c.logger())
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		config.URL, config.MetricsURL, pub = l.URL, l.MetricsURL, l.Hub
+	case direct:
+		h, err := c.newHub(ctx)
+		if err != nil {
+			return err
+		}
+		config.URL, config.MetricsURL, pub = c.socketURL(), strings.TrimRight(c.server, "/")+"/metrics", h
+	default:
+		config.URL, config.MetricsURL = c.socketURL(), strings.TrimRight(c.server, "/")+"/metrics"
+		cl, err := client.Connect(ctx, client.Config{URL: config.URL, Username: "bench-publisher", Topics: []string{"bench.publisher"}, Token: c.token}, c.logger())
+		if err != nil {
+			return err
+		}
+		defer cl.Close()
+		pub = cl
+	}
+	switch metric {
+	case "":
+	case "-":
+		config.MetricsURL = ""
+	default:
+		config.MetricsURL = metric
+	}
+
+	r, err := bench.Run(ctx, config, pub, c.logger())
+	if err != nil {
+		return err
+	}
+	return c.print(os.Stdout, r)
+}
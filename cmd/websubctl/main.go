@@ -0,0 +1,150 @@
+/*
+websubctl is the command line client of websub, it subscribes, publishes, inspects connections and presence
+of a websub instance and benchmarks it. Messages are exchanged through the websocket endpoint of the server,
+or directly with the hub that is configured by the same WEBSUB_* environment variables as the server when
+-hub is set.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/mammadmodi/websub/internal/app"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/sirupsen/logrus"
+)
+
+// Output formats.
+const (
+	JSONOutput   = "json"
+	PrettyOutput = "pretty"
+)
+
+const usage = `usage: websubctl <command> [flags]
+
+commands:
+  subscribe    print messages of topics
+  publish      publish a message to a topic
+  connections  list connections of a websub instance
+  presence     list users of a topic on a websub instance
+  bench        measure fan-out latency of a websub instance
+
+run websubctl <command> -h for flags of a command.
+`
+
+// commands are subcommands by name.
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"subscribe":   subscribe,
+	"publish":     publish,
+	"connections": connections,
+	"presence":    presence,
+	"bench":       benchmark,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := cmd(ctx, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// common holds flags that are shared between commands.
+type common struct {
+	server   string
+	token    string
+	output   string
+	verbose  bool
+	username string
+}
+
+func (c *common) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.server, "server", envOr("WEBSUBCTL_SERVER", "http://127.0.0.1:8379"), "base url of the websub server")
+	fs.StringVar(&c.token, "token", os.Getenv("WEBSUBCTL_TOKEN"), "bearer token of the server")
+	fs.StringVar(&c.output, "output", JSONOutput, "output format, json or pretty")
+	fs.BoolVar(&c.verbose, "v", false, "log client and hub events to stderr")
+}
+
+// socketURL returns the websocket endpoint of the server.
+func (c *common) socketURL() string {
+	s := strings.TrimSuffix(c.server, "/")
+	switch {
+	case strings.HasPrefix(s, "https://"):
+		s = "wss://" + strings.TrimPrefix(s, "https://")
+	case strings.HasPrefix(s, "http://"):
+		s = "ws://" + strings.TrimPrefix(s, "http://")
+	}
+	return s + "/socket/connect"
+}
+
+func (c *common) logger() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(os.Stderr)
+	if !c.verbose {
+		l.SetOutput(ioutil.Discard)
+	}
+	return l
+}
+
+// newHub creates the hub that is configured with environment variables of the server.
+func (c *common) newHub(ctx context.Context) (hub.Hub, error) {
+	configs, err := app.NewConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	return app.NewHub(ctx, configs, c.logger())
+}
+
+// print writes v to w as a json line, or indented json with pretty output.
+func (c *common) print(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	if c.output == PrettyOutput {
+		e.SetIndent("", "  ")
+	}
+	return e.Encode(v)
+}
+
+func (c *common) validate() error {
+	if c.output != JSONOutput && c.output != PrettyOutput {
+		return fmt.Errorf("'%s' is not a valid output, outputs are %s and %s", c.output, JSONOutput, PrettyOutput)
+	}
+	return nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// splitList splits a comma separated list and drops empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, i := range strings.Split(s, ",") {
+		if i = strings.TrimSpace(i); i != "" {
+			items = append(items, i)
+		}
+	}
+	return items
+}
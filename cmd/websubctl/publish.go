@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/mammadmodi/websub/pkg/client"
+	"github.com/mammadmodi/websub/pkg/hub"
+)
+
+// publish publishes the data argument(or stdin when it's missing or -) to a topic.
+func publish(ctx context.Context, args []string) error {
+	var (
+		c              common
+		topic          string
+		direct, asJSON bool
+		retain         bool
+		wait           time.Duration
+		fs             = flag.NewFlagSet("publish", flag.ExitOnError)
+	)
+	c.register(fs)
+	fs.StringVar(&c.username, "username", "websubctl", "username of the connection")
+	fs.StringVar(&topic, "topic", "", "topic of the message")
+	fs.BoolVar(&direct, "hub", false, "publish directly to the hub instead of the websocket endpoint")
+	fs.BoolVar(&asJSON, "json", false, "decode data as json before publishing to the hub, so it's encoded by the hub codec")
+	fs.BoolVar(&retain, "retain", false, "keep the message as the retained message of the topic, empty data removes it")
+	fs.DurationVar(&wait, "wait", 500*time.Millisecond, "duration to wait for rejection of the message by the server")
+	_ = fs.Parse(args)
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if topic == "" {
+		return fmt.Errorf("topic cannot be empty")
+	}
+	data, err := readData(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if direct {
+		h, err := c.newHub(ctx)
+		if err != nil {
+			return err
+		}
+		var d interface{} = data
+		if asJSON && data != "" {
+			if err := json.Unmarshal([]byte(data), &d); err != nil {
+				return fmt.Errorf("data is not valid json, error: %s", err.Error())
+			}
+		}
+		if !retain {
+			return h.Publish(ctx, topic, d)
+		}
+		rh, ok := h.(hub.RetainHub)
+		if !ok {
+			return hub.ErrNotRetained
+		}
+		if data == "" {
+			d = nil
+		}
+		return rh.PublishRetained(ctx, topic, d)
+	}
+
+	// The websocket endpoint needs a subscription, the connection subscribes to the topic it publishes to.
+	cl, err := client.Connect(ctx, client.Config{
+		URL:      c.socketURL(),
+		Username: c.username,
+		Topics:   []string{topic},
+		Token:    c.token,
+	}, c.logger())
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	if retain {
+		var d interface{}
+		if data != "" {
+			d = data
+		}
+		err = cl.PublishRetained(ctx, topic, d)
+	} else {
+		err = cl.Publish(ctx, topic, data)
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-cl.Errors():
+		_ = c.print(os.Stdout, err)
+		return err
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// readData returns arg, or stdin when arg is empty or -.
+func readData(arg string) (string, error) {
+	if arg != "" && arg != "-" {
+		return arg, nil
+	}
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("error while reading data from stdin, error: %s", err.Error())
+	}
+	return string(b), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mammadmodi/websub/pkg/client"
+	"github.com/mammadmodi/websub/pkg/hub"
+)
+
+// subscribe prints messages of topics until it's interrupted or receives count messages.
+func subscribe(ctx context.Context, args []string) error {
+	var (
+		c                                         common
+		topics, group, groupToken, filter, lastID string
+		ack, direct                               bool
+		count                                     int
+		fs                                        = flag.NewFlagSet("subscribe", flag.ExitOnError)
+	)
+	c.register(fs)
+	fs.StringVar(&c.username, "username", "websubctl", "username of the connection")
+	fs.StringVar(&topics, "topics", "", "comma separated topics or patterns")
+	fs.StringVar(&group, "group", "", "queue group of the subscription")
+	fs.StringVar(&groupToken, "group-token", "", "token of the queue group, only with the websocket endpoint")
+	fs.StringVar(&filter, "filter", "", "filter expression of the subscription, only with the websocket endpoint")
+	fs.StringVar(&lastID, "last-id", "", "resume a durable subscription from the message after this id")
+	fs.BoolVar(&ack, "ack", false, "subscribe durably and acknowledge printed messages")
+	fs.BoolVar(&direct, "hub", false, "subscribe directly to the hub instead of the websocket endpoint")
+	fs.IntVar(&count, "count", 0, "exit after count messages, 0 prints messages until interrupted")
+	_ = fs.Parse(args)
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if len(splitList(topics)) == 0 {
+		return fmt.Errorf("topics cannot be empty")
+	}
+
+	var (
+		messages <-chan *hub.Message
+		onPrint  func(msg *hub.Message)
+	)
+	if direct {
+		if filter != "" {
+			return fmt.Errorf("filters are evaluated by the websocket endpoint and can't be used with -hub")
+		}
+		h, err := c.newHub(ctx)
+		if err != nil {
+			return err
+		}
+		sub, err := subscribeHub(ctx, h, group, lastID, ack, splitList(topics))
+		if err != nil {
+			return err
+		}
+		messages = sub.MessageChannel
+	} else {
+		cl, err := client.Connect(ctx, client.Config{
+			URL:        c.socketURL(),
+			Username:   c.username,
+			Topics:     splitList(topics),
+			Token:      c.token,
+			Ack:        ack,
+			LastID:     lastID,
+			Group:      group,
+			GroupToken: groupToken,
+			Filter:     filter,
+		}, c.logger())
+		if err != nil {
+			return err
+		}
+		defer cl.Close()
+		messages = cl.Messages()
+		if ack {
+			onPrint = func(msg *hub.Message) {
+				if err := cl.Ack(ctx, msg.ID); err != nil {
+					fmt.Fprintf(os.Stderr, "could not acknowledge %s, error: %s\n", msg.ID, err.Error())
+				}
+			}
+		}
+	}
+
+	for i := 0; count == 0 || i < count; i++ {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return fmt.Errorf("subscription is closed")
+			}
+			if err := c.print(os.Stdout, msg); err != nil {
+				return err
+			}
+			if onPrint != nil {
+				onPrint(msg)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// subscribeHub creates a hub subscription of the requested kind.
+func subscribeHub(ctx context.Context, h hub.Hub, group, lastID string, durable bool, topics []string) (*hub.Subscription, error) {
+	switch {
+	case durable || lastID != "":
+		dh, ok := h.(hub.DurableHub)
+		if !ok {
+			return nil, fmt.Errorf("durable subscriptions are not supported by the hub")
+		}
+		return dh.SubscribeFrom(ctx, lastID, topics...)
+	case group != "":
+		qh, ok := h.(hub.QueueHub)
+		if !ok {
+			return nil, fmt.Errorf("queue groups are not supported by the hub")
+		}
+		return qh.QueueSubscribe(ctx, group, topics...)
+	default:
+		return h.Subscribe(ctx, topics...)
+	}
+}
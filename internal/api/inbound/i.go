@@ -0,0 +1,200 @@
+// Package inbound receives webhooks of third party services and publishes them to hub topics.
+package inbound
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/tenant"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// Configuration is used in Receiver method set.
+type Configuration struct {
+	// Enabled enables inbound webhook routes.
+	Enabled bool `default:"false"`
+	// RoutesFile is path of a json file that contains list of routes.
+	RoutesFile string `default:"./hooks.json" split_words:"true"`
+	// MaxBodySize is max size of webhook bodies in bytes.
+	MaxBodySize int64 `default:"1048576" split_words:"true"`
+	// Routes are loaded from RoutesFile.
+	Routes []Route `ignored:"true"`
+}
+
+// Route maps webhooks that are posted to /hooks/{Name} to a topic.
+type Route struct {
+	Name string `json:"name"`
+	// Signature is the signature scheme of the route, see Verifier.
+	Signature string `json:"signature"`
+	Secret    string `json:"secret"`
+	// SignatureHeader is the header that contains signature when Signature is hmac_sha256.
+	SignatureHeader string `json:"signature_header"`
+	// Topic is a text/template that builds the target topic, headers are available with header func and
+	// values of json bodies are available with jsonpath func, e.g. github.{{header "X-GitHub-Event"}}.
+	Topic string `json:"topic"`
+	// Data is an optional json path to the part of json bodies that is published, e.g. $.data.object.
+	Data string `json:"data"`
+}
+
+// LoadRoutes reads routes from a json file.
+func LoadRoutes(path string) ([]Route, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading routes file %s, error: %s", path, err.Error())
+	}
+	var routes []Route
+	if err := json.Unmarshal(b, &routes); err != nil {
+		return nil, fmt.Errorf("error while decoding routes file %s, error: %s", path, err.Error())
+	}
+	return routes, nil
+}
+
+// route is a Route that is ready to handle requests.
+type route struct {
+	Route
+	verifier Verifier
+	topic    *template.Template
+}
+
+// Receiver publishes webhooks of routes to the hub.
+type Receiver struct {
+	Hub    hub.Hub
+	Config Configuration
+	// Tenants resolves tenants of webhooks, topics are not namespaced and limited when it's nil.
+	Tenants *tenant.Registry
+
+	logger *logrus.Logger
+	routes map[string]*route
+}
+
+// NewReceiver creates a Receiver object, it returns an error when a route is not valid.
+func NewReceiver(config Configuration, hub hub.Hub, logger *logrus.Logger) (*Receiver, error) {
+	rc := &Receiver{
+		Hub:    hub,
+		Config: config,
+		logger: logger,
+		routes: make(map[string]*route),
+	}
+	for _, r := range config.Routes {
+		if r.Name == "" {
+			return nil, fmt.Errorf("name of routes cannot be empty")
+		}
+		if _, ok := rc.routes[r.Name]; ok {
+			return nil, fmt.Errorf("route %s is duplicated", r.Name)
+		}
+		v, err := NewVerifier(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %s, error: %s", r.Name, err.Error())
+		}
+		t, err := template.New(r.Name).Option("missingkey=error").Funcs(template.FuncMap{
+			"header":   func(string) string { return "" },
+			"jsonpath": func(string) (interface{}, error) { return nil, nil },
+		}).Parse(r.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("invalid topic template of route %s, error: %s", r.Name, err.Error())
+		}
+		rc.routes[r.Name] = &route{Route: r, verifier: v, topic: t}
+	}
+	return rc, nil
+}
+
+// Hook is a http handler that verifies a webhook which is posted to /hooks/{name} and publishes it to
+// the topic of the route, webhooks of tenants are published to the topic in their namespace.
+func (rc *Receiver) Hook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/hooks/")
+	rt, ok := rc.routes[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	t, err := rc.Tenants.Resolve(r)
+	if err != nil {
+		rc.logger.WithField("route", name).WithField("host", r.Host).Info("webhook rejected because its tenant is unknown")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, rc.Config.MaxBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := rt.verifier.Verify(r.Header, body); err != nil {
+		rc.logger.WithField("route", name).WithError(err).Info("webhook signature verification failed")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte("invalid signature"))
+		return
+	}
+	if err := t.Allow(len(body)); err != nil {
+		rc.logger.WithField("route", name).WithField("tenant", t.Name).WithError(err).Info("webhook rejected by limits of tenant")
+		status := http.StatusTooManyRequests
+		if err == tenant.ErrSize {
+			status = http.StatusRequestEntityTooLarge
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Bodies which are not json are published as strings.
+	var doc interface{}
+	var data interface{} = string(body)
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&doc); err == nil {
+		data = doc
+	}
+	topic, err := rt.execTopic(r.Header, doc)
+	if err != nil {
+		rc.logger.WithField("route", name).WithError(err).Info("could not build topic of webhook")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	if rt.Data != "" {
+		if data, err = JSONPath(doc, rt.Data); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	topic = t.Topic(topic)
+	if err := rc.Hub.Publish(r.Context(), topic, data); err != nil {
+		rc.logger.WithField("route", name).WithField("topic", topic).WithError(err).Error("could not publish webhook to hub")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rc.logger.WithField("route", name).WithField("topic", topic).Debug("webhook published to hub")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// execTopic builds topic of a webhook.
+func (rt *route) execTopic(header http.Header, doc interface{}) (string, error) {
+	t, err := rt.topic.Clone()
+	if err != nil {
+		return "", err
+	}
+	t.Funcs(template.FuncMap{
+		"header":   header.Get,
+		"jsonpath": func(path string) (interface{}, error) { return JSONPath(doc, path) },
+	})
+	var b strings.Builder
+	if err := t.Execute(&b, nil); err != nil {
+		return "", fmt.Errorf("error while building topic, error: %s", err.Error())
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("topic of webhook is empty")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,130 @@
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/tenant"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func githubSignature(secret, body string) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+func TestNewReceiver(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	valid := Route{Name: "github", Signature: GithubSignature, Secret: "secret", Topic: "github"}
+
+	t.Run("test duplicated routes are rejected", func(t *testing.T) {
+		_, err := NewReceiver(Configuration{Routes: []Route{valid, valid}}, nil, l)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid signature schemes are rejected", func(t *testing.T) {
+		r := valid
+		r.Signature = "invalid"
+		_, err := NewReceiver(Configuration{Routes: []Route{r}}, nil, l)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid topic templates are rejected", func(t *testing.T) {
+		r := valid
+		r.Topic = "github.{{header"
+		_, err := NewReceiver(Configuration{Routes: []Route{r}}, nil, l)
+		assert.Error(t, err)
+	})
+}
+
+func TestReceiver_Hook(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	h := hub.NewMockHub(ctrl)
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	c := Configuration{
+		MaxBodySize: 1024,
+		Routes: []Route{
+			{Name: "github", Signature: GithubSignature, Secret: "secret", Topic: `github.{{header "X-GitHub-Event"}}.{{jsonpath "$.repository.id"}}`},
+			{Name: "orders", Signature: NoSignature, Topic: `orders.{{jsonpath "$.type"}}`, Data: "$.order"},
+		},
+	}
+	rc, err := NewReceiver(c, h, l)
+	assert.NoError(t, err)
+
+	post := func(target, body string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		rc.Hook(w, r)
+		return w
+	}
+
+	t.Run("test webhooks of unknown routes are rejected", func(t *testing.T) {
+		w := post("/hooks/unknown", "{}", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("test webhooks with invalid signature are rejected", func(t *testing.T) {
+		w := post("/hooks/github", `{}`, map[string]string{"X-Hub-Signature-256": "sha256=invalid"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("test webhooks are published to their topic", func(t *testing.T) {
+		body := `{"repository": {"id": 1296269}}`
+		var data interface{}
+		d := json.NewDecoder(strings.NewReader(body))
+		d.UseNumber()
+		assert.NoError(t, d.Decode(&data))
+		h.EXPECT().Publish(gomock.Any(), "github.push.1296269", data).Return(nil)
+		w := post("/hooks/github", body, map[string]string{
+			"X-GitHub-Event":      "push",
+			"X-Hub-Signature-256": githubSignature("secret", body),
+		})
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	})
+
+	t.Run("test data path selects the published data", func(t *testing.T) {
+		h.EXPECT().Publish(gomock.Any(), "orders.created", map[string]interface{}{"id": "o1"}).Return(nil)
+		w := post("/hooks/orders", `{"type": "created", "order": {"id": "o1"}}`, nil)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	})
+
+	t.Run("test webhooks without topic values are rejected", func(t *testing.T) {
+		w := post("/hooks/orders", `{"order": {"id": "o1"}}`, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("test large webhooks are rejected", func(t *testing.T) {
+		w := post("/hooks/orders", strings.Repeat("a", 2048), nil)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("test webhooks of tenants are published to their namespace", func(t *testing.T) {
+		tr, err := tenant.NewRegistry(&tenant.Tenant{Name: "acme", Hosts: []string{"hooks.acme.io"}, MaxMessageSize: 64})
+		assert.NoError(t, err)
+		rc.Tenants = tr
+		defer func() { rc.Tenants = nil }()
+
+		w := post("/hooks/orders", `{"type": "created", "order": {"id": "o1"}}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		h.EXPECT().Publish(gomock.Any(), "acme.orders.created", map[string]interface{}{"id": "o1"}).Return(nil)
+		w = post("http://hooks.acme.io/hooks/orders", `{"type": "created", "order": {"id": "o1"}}`, nil)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		w = post("http://hooks.acme.io/hooks/orders", `{"type": "created", "order": {"id": "o1", "note": "larger than the limit"}}`, nil)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
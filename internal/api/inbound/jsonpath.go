@@ -0,0 +1,53 @@
+package inbound
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPath returns the value of a decoded json document at path. Paths support the root($), child
+// keys(.key) and array indexes([0]), e.g. $.data.items[0].id.
+func JSONPath(doc interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path %s must start with $", path)
+	}
+	v := doc
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			rest = rest[end+1:]
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("value of json path %s is not an object before .%s", path, key)
+			}
+			if v, ok = m[key]; !ok {
+				return nil, fmt.Errorf("key %s of json path %s doesn't exist", key, path)
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("json path %s has an unclosed [", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("'%s' is not a valid index in json path %s", rest[1:end], path)
+			}
+			rest = rest[end+1:]
+			a, ok := v.([]interface{})
+			if !ok || i < 0 || i >= len(a) {
+				return nil, fmt.Errorf("index %d of json path %s doesn't exist", i, path)
+			}
+			v = a[i]
+		default:
+			return nil, fmt.Errorf("json path %s is not valid", path)
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,30 @@
+package inbound
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{"data": {"items": [{"id": "i1"}, {"id": "i2"}]}, "type": "created"}`), &doc)
+	assert.NoError(t, err)
+
+	v, err := JSONPath(doc, "$.type")
+	assert.NoError(t, err)
+	assert.Equal(t, "created", v)
+
+	v, err = JSONPath(doc, "$.data.items[1].id")
+	assert.NoError(t, err)
+	assert.Equal(t, "i2", v)
+
+	v, err = JSONPath(doc, "$")
+	assert.NoError(t, err)
+	assert.Equal(t, doc, v)
+
+	for _, path := range []string{"type", "$.unknown", "$.data.items[2]", "$.type.id", "$.data.items[x]", "$.data.items[0"} {
+		_, err = JSONPath(doc, path)
+		assert.Error(t, err, path)
+	}
+}
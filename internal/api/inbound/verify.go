@@ -0,0 +1,137 @@
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mammadmodi/websub/internal/api/webhook"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature schemes of routes.
+const (
+	// NoSignature accepts all webhooks of the route.
+	NoSignature = "none"
+	// GithubSignature verifies X-Hub-Signature-256 header of github webhooks.
+	GithubSignature = "github"
+	// StripeSignature verifies Stripe-Signature header of stripe webhooks.
+	StripeSignature = "stripe"
+	// HMACSignature verifies hex of HMAC-SHA256 of body in the signature header of the route.
+	HMACSignature = "hmac_sha256"
+	// WebsubSignature verifies webhooks that are delivered by websub webhook dispatcher.
+	WebsubSignature = "websub"
+)
+
+// SignatureTolerance is the max age of timestamps of stripe and websub signatures.
+const SignatureTolerance = 5 * time.Minute
+
+var errInvalidSignature = errors.New("signature doesn't match")
+
+// Verifier verifies signature of a webhook.
+type Verifier interface {
+	Verify(header http.Header, body []byte) error
+}
+
+// VerifierFunc is an adapter to use functions as Verifier.
+type VerifierFunc func(header http.Header, body []byte) error
+
+// Verify calls f(header, body).
+func (f VerifierFunc) Verify(header http.Header, body []byte) error {
+	return f(header, body)
+}
+
+// NewVerifier returns verifier of signature scheme of a route.
+func NewVerifier(r Route) (Verifier, error) {
+	if r.Signature != NoSignature && r.Secret == "" {
+		return nil, errors.New("secret cannot be empty")
+	}
+	switch r.Signature {
+	case NoSignature:
+		return VerifierFunc(func(http.Header, []byte) error { return nil }), nil
+	case GithubSignature:
+		return VerifierFunc(func(header http.Header, body []byte) error {
+			return verifyHMAC(r.Secret, strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="), body)
+		}), nil
+	case HMACSignature:
+		if r.SignatureHeader == "" {
+			return nil, errors.New("signature header cannot be empty")
+		}
+		return VerifierFunc(func(header http.Header, body []byte) error {
+			return verifyHMAC(r.Secret, strings.TrimPrefix(header.Get(r.SignatureHeader), "sha256="), body)
+		}), nil
+	case StripeSignature:
+		return VerifierFunc(func(header http.Header, body []byte) error {
+			return verifyStripe(r.Secret, header.Get("Stripe-Signature"), body, time.Now())
+		}), nil
+	case WebsubSignature:
+		return VerifierFunc(func(header http.Header, body []byte) error {
+			ts := header.Get(webhook.TimestampHeader)
+			if err := checkTimestamp(ts, time.Now()); err != nil {
+				return err
+			}
+			sig := strings.TrimPrefix(header.Get(webhook.SignatureHeader), "sha256=")
+			if !hmac.Equal([]byte(sig), []byte(webhook.Sign(r.Secret, ts, body))) {
+				return errInvalidSignature
+			}
+			return nil
+		}), nil
+	default:
+		return nil, fmt.Errorf("'%s' is not a valid signature scheme", r.Signature)
+	}
+}
+
+func verifyHMAC(secret, signature string, body []byte) error {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(m.Sum(nil)))) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// verifyStripe verifies a header like t=1492774577,v1=5257a869... which contains hex of
+// HMAC-SHA256 of timestamp and body that are joined by a ".".
+func verifyStripe(secret, header string, body []byte, now time.Time) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sigs = append(sigs, kv[1])
+		}
+	}
+	if err := checkTimestamp(ts, now); err != nil {
+		return err
+	}
+	// Signing of stripe is the same as websub.
+	expected := webhook.Sign(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return errInvalidSignature
+}
+
+func checkTimestamp(ts string, now time.Time) error {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid timestamp", ts)
+	}
+	d := now.Sub(time.Unix(sec, 0))
+	if d > SignatureTolerance || d < -SignatureTolerance {
+		return errors.New("timestamp is out of tolerance")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package inbound
+
+import (
+	"fmt"
+	"github.com/mammadmodi/websub/internal/api/webhook"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewVerifier(t *testing.T) {
+	body := []byte(`{"key": "value"}`)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	old := strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name   string
+		route  Route
+		header http.Header
+		valid  bool
+	}{
+		{"none", Route{Signature: NoSignature}, http.Header{}, true},
+		{"github", Route{Signature: GithubSignature, Secret: "s"}, http.Header{"X-Hub-Signature-256": {githubSignature("s", string(body))}}, true},
+		{"github with wrong secret", Route{Signature: GithubSignature, Secret: "s"}, http.Header{"X-Hub-Signature-256": {githubSignature("x", string(body))}}, false},
+		{"hmac", Route{Signature: HMACSignature, Secret: "s", SignatureHeader: "X-Signature"}, http.Header{"X-Signature": {githubSignature("s", string(body))}}, true},
+		{"stripe", Route{Signature: StripeSignature, Secret: "s"}, http.Header{"Stripe-Signature": {fmt.Sprintf("t=%s,v1=invalid,v1=%s", ts, webhook.Sign("s", ts, body))}}, true},
+		{"stripe with old timestamp", Route{Signature: StripeSignature, Secret: "s"}, http.Header{"Stripe-Signature": {fmt.Sprintf("t=%s,v1=%s", old, webhook.Sign("s", old, body))}}, false},
+		{"websub", Route{Signature: WebsubSignature, Secret: "s"}, http.Header{webhook.TimestampHeader: {ts}, webhook.SignatureHeader: {"sha256=" + webhook.Sign("s", ts, body)}}, true},
+		{"websub without timestamp", Route{Signature: WebsubSignature, Secret: "s"}, http.Header{webhook.SignatureHeader: {"sha256=" + webhook.Sign("s", ts, body)}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewVerifier(tt.route)
+			if !assert.NoError(t, err) {
+				return
+			}
+			err = v.Verify(tt.header, body)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	_, err := NewVerifier(Route{Signature: GithubSignature})
+	assert.Error(t, err)
+	_, err = NewVerifier(Route{Signature: HMACSignature, Secret: "s"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package schema
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxSchemaSize is max size of schemas that are set by admin api.
+const maxSchemaSize = 1 << 20
+
+// Admin is a http handler that lists(GET), sets(PUT with topic query) and deletes(DELETE with topic query)
+// schemas of topic patterns, requests must have the admin token as a bearer token.
+func (r *Registry) Admin(w http.ResponseWriter, req *http.Request) {
+	if !r.authorize(req) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte("invalid admin token"))
+		return
+	}
+
+	pattern := req.URL.Query().Get("topic")
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.Schemas())
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxSchemaSize))
+		if err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := r.Set(pattern, b); err != nil {
+			r.logger.WithField("topic", pattern).WithError(err).Info("could not set schema")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := r.Delete(pattern); err != nil {
+			r.logger.WithField("topic", pattern).WithError(err).Info("could not delete schema")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) authorize(req *http.Request) bool {
+	if r.Config.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(r.Config.AdminToken)) == 1
+}
@@ -0,0 +1,48 @@
+package schema
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Admin(t *testing.T) {
+	r := mockRegistry(t)
+
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.Admin(w, req)
+		return w
+	}
+
+	t.Run("test requests with invalid token are rejected", func(t *testing.T) {
+		w := do(http.MethodGet, "/schemas", "invalid", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("test invalid schemas are rejected", func(t *testing.T) {
+		w := do(http.MethodPut, "/schemas?topic=payments", "token", `{"type": 1}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("test schemas are set, listed and deleted", func(t *testing.T) {
+		w := do(http.MethodPut, "/schemas?topic=payments.>", "token", `{"type": "object"}`)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = do(http.MethodGet, "/schemas", "token", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var schemas map[string]json.RawMessage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &schemas))
+		assert.JSONEq(t, `{"type": "object"}`, string(schemas["payments.>"]))
+		assert.Contains(t, schemas, "orders.*")
+
+		w = do(http.MethodDelete, "/schemas?topic=payments.>", "token", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.NotContains(t, r.Schemas(), "payments.>")
+	})
+}
@@ -0,0 +1,232 @@
+// Package schema validates bodies that users publish to topics with json schemas of topic patterns.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// rejected counts publishes that don't match schemas, topic label is the pattern of the schema.
+var rejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "websub",
+	Subsystem: "schema",
+	Name:      "rejected_publishes_total",
+	Help:      "Number of publishes that are rejected by schemas of topics.",
+}, []string{"topic"})
+
+// Configuration is used in Registry method set.
+type Configuration struct {
+	// Enabled enables validation of user publishes.
+	Enabled bool `default:"false"`
+	// Dir contains a schema file for each topic pattern, the file name is the pattern followed by .json,
+	// e.g. orders.*.json. Schemas that are set by admin api are saved in Dir too.
+	Dir string `default:"./schemas"`
+	// AdminToken is the bearer token of admin api, admin api is disabled when it's empty.
+	AdminToken string `split_words:"true"`
+}
+
+// Failure is a part of a body that doesn't match the schema.
+type Failure struct {
+	// Path is json pointer of the invalid value in the body, e.g. /items/0/price.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a body doesn't match the schema of a topic pattern.
+type ValidationError struct {
+	Topic    string
+	Pattern  string
+	Failures []Failure
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("body of topic %s doesn't match schema of %s", e.Topic, e.Pattern)
+}
+
+type entry struct {
+	raw    json.RawMessage
+	schema *jsonschema.Schema
+}
+
+// Registry keeps compiled schemas of topic patterns.
+type Registry struct {
+	Config Configuration
+
+	logger  *logrus.Logger
+	mu      sync.RWMutex
+	schemas map[string]*entry
+}
+
+// NewRegistry creates a Registry and loads schemas of the directory, a missing directory is empty.
+func NewRegistry(config Configuration, logger *logrus.Logger) (*Registry, error) {
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(ioutil.Discard)
+	}
+	r := &Registry{
+		Config:  config,
+		logger:  logger,
+		schemas: make(map[string]*entry),
+	}
+	files, err := ioutil.ReadDir(config.Dir)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while reading schemas directory %s, error: %s", config.Dir, err.Error())
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(config.Dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading schema file %s, error: %s", f.Name(), err.Error())
+		}
+		pattern := strings.TrimSuffix(f.Name(), ".json")
+		e, err := compile(pattern, b)
+		if err != nil {
+			return nil, err
+		}
+		r.schemas[pattern] = e
+	}
+	return r, nil
+}
+
+func compile(pattern string, b []byte) (*entry, error) {
+	if err := validatePattern(pattern); err != nil {
+		return nil, err
+	}
+	u := "mem:///" + url.PathEscape(pattern) + ".json"
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(u, bytes.NewReader(b)); err != nil {
+		return nil, fmt.Errorf("invalid schema of %s, error: %s", pattern, err.Error())
+	}
+	s, err := c.Compile(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema of %s, error: %s", pattern, err.Error())
+	}
+	return &entry{raw: b, schema: s}, nil
+}
+
+// validatePattern rejects patterns that cannot be used as file names of the directory.
+func validatePattern(pattern string) error {
+	if pattern == "" || strings.HasPrefix(pattern, ".") || strings.ContainsAny(pattern, `/\`) {
+		return fmt.Errorf("'%s' is not a valid topic pattern", pattern)
+	}
+	return nil
+}
+
+// Validate validates a json body that is published to topic with schemas of all patterns that match
+// the topic, topics without schemas accept all bodies.
+func (r *Registry) Validate(topic string, body []byte) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var doc interface{}
+	parsed := false
+	for _, pattern := range r.patterns() {
+		if !hub.MatchTopic(pattern, topic) {
+			continue
+		}
+		if !parsed {
+			d := json.NewDecoder(bytes.NewReader(body))
+			d.UseNumber()
+			if err := d.Decode(&doc); err != nil {
+				rejected.WithLabelValues(pattern).Inc()
+				return &ValidationError{Topic: topic, Pattern: pattern, Failures: []Failure{{Message: "body is not valid json"}}}
+			}
+			parsed = true
+		}
+		err := r.schemas[pattern].schema.Validate(doc)
+		if ve, ok := err.(*jsonschema.ValidationError); ok {
+			rejected.WithLabelValues(pattern).Inc()
+			return &ValidationError{Topic: topic, Pattern: pattern, Failures: failures(ve, nil)}
+		}
+		if err != nil {
+			return fmt.Errorf("error while validating body of topic %s, error: %s", topic, err.Error())
+		}
+	}
+	return nil
+}
+
+// failures returns leaf errors of a validation error.
+func failures(ve *jsonschema.ValidationError, fs []Failure) []Failure {
+	if len(ve.Causes) == 0 {
+		return append(fs, Failure{Path: ve.InstanceLocation, Message: ve.Message})
+	}
+	for _, c := range ve.Causes {
+		fs = failures(c, fs)
+	}
+	return fs
+}
+
+// Set compiles the schema of a pattern and saves it in the directory.
+func (r *Registry) Set(pattern string, schema []byte) error {
+	e, err := compile(pattern, schema)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.MkdirAll(r.Config.Dir, 0755); err != nil {
+		return fmt.Errorf("error while creating schemas directory, error: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(r.path(pattern), schema, 0644); err != nil {
+		return fmt.Errorf("error while writing schema of %s, error: %s", pattern, err.Error())
+	}
+	r.schemas[pattern] = e
+	return nil
+}
+
+// Delete removes the schema of a pattern.
+func (r *Registry) Delete(pattern string) error {
+	if err := validatePattern(pattern); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.Remove(r.path(pattern)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error while removing schema of %s, error: %s", pattern, err.Error())
+	}
+	delete(r.schemas, pattern)
+	return nil
+}
+
+// Schemas returns raw schemas by their patterns.
+func (r *Registry) Schemas() map[string]json.RawMessage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m := make(map[string]json.RawMessage, len(r.schemas))
+	for p, e := range r.schemas {
+		m[p] = e.raw
+	}
+	return m
+}
+
+// patterns returns sorted patterns, so failures of a body are deterministic.
+func (r *Registry) patterns() []string {
+	ps := make([]string, 0, len(r.schemas))
+	for p := range r.schemas {
+		ps = append(ps, p)
+	}
+	sort.Strings(ps)
+	return ps
+}
+
+func (r *Registry) path(pattern string) string {
+	return filepath.Join(r.Config.Dir, pattern+".json")
+}
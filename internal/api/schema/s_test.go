@@ -0,0 +1,84 @@
+package schema
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "items"],
+	"properties": {
+		"id": {"type": "string"},
+		"items": {"type": "array", "items": {"type": "object", "properties": {"price": {"type": "number", "minimum": 0}}}}
+	}
+}`
+
+func mockRegistry(t *testing.T) *Registry {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "orders.*.json"), []byte(orderSchema), 0644))
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	r, err := NewRegistry(Configuration{Dir: dir, AdminToken: "token"}, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestNewRegistry(t *testing.T) {
+	r := mockRegistry(t)
+	assert.Contains(t, r.Schemas(), "orders.*")
+
+	r, err := NewRegistry(Configuration{Dir: filepath.Join(t.TempDir(), "missing")}, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, r.Schemas())
+
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "orders.json"), []byte(`{"type": 1}`), 0644))
+	_, err = NewRegistry(Configuration{Dir: dir}, nil)
+	assert.Error(t, err)
+}
+
+func TestRegistry_Validate(t *testing.T) {
+	r := mockRegistry(t)
+
+	assert.NoError(t, r.Validate("orders.created", []byte(`{"id": "1", "items": [{"price": 10}]}`)))
+	// Topics without schemas accept all bodies.
+	assert.NoError(t, r.Validate("payments.paid", []byte(`not json`)))
+
+	before := testutil.ToFloat64(rejected.WithLabelValues("orders.*"))
+	err := r.Validate("orders.created", []byte(`{"id": 1, "items": [{"price": -1}]}`))
+	if assert.IsType(t, &ValidationError{}, err) {
+		ve := err.(*ValidationError)
+		assert.Equal(t, "orders.*", ve.Pattern)
+		assert.ElementsMatch(t, []string{"/id", "/items/0/price"}, []string{ve.Failures[0].Path, ve.Failures[1].Path})
+	}
+	err = r.Validate("orders.created", []byte(`{`))
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, before+2, testutil.ToFloat64(rejected.WithLabelValues("orders.*")))
+}
+
+func TestRegistry_SetDelete(t *testing.T) {
+	r := mockRegistry(t)
+
+	assert.Error(t, r.Set("../orders", []byte(`{}`)))
+	assert.Error(t, r.Set("payments", []byte(`{"type": 1}`)))
+	assert.NoError(t, r.Set("payments", []byte(`{"type": "object"}`)))
+	assert.Error(t, r.Validate("payments", []byte(`[]`)))
+
+	// Schemas are loaded from the directory again.
+	loaded, err := NewRegistry(r.Config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, r.Schemas(), loaded.Schemas())
+
+	assert.NoError(t, r.Delete("payments"))
+	assert.NoError(t, r.Validate("payments", []byte(`[]`)))
+	loaded, err = NewRegistry(r.Config, nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, loaded.Schemas(), "payments")
+}
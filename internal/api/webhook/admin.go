@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Admin is a http handler that lists(GET), creates(POST) and deletes(DELETE with id query) registrations,
+// requests must have the admin token as a bearer token. Secrets are not included in responses.
+func (d *Dispatcher) Admin(w http.ResponseWriter, r *http.Request) {
+	if !d.authorize(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte("invalid admin token"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		regs, err := d.Store.List(r.Context())
+		if err != nil {
+			d.logger.WithError(err).Error("could not list webhook registrations")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, reg := range regs {
+			reg.Secret = ""
+		}
+		writeJSON(w, http.StatusOK, regs)
+	case http.MethodPost:
+		reg := &Registration{}
+		if err := json.NewDecoder(r.Body).Decode(reg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("invalid registration"))
+			return
+		}
+		if err := reg.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if err := d.Register(r.Context(), reg); err != nil {
+			d.logger.WithError(err).Error("could not register webhook")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// reg is used by dispatcher, so secret is removed from a copy.
+		resp := *reg
+		resp.Secret = ""
+		writeJSON(w, http.StatusCreated, &resp)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("id cannot be empty"))
+			return
+		}
+		if err := d.Unregister(r.Context(), id); err != nil {
+			d.logger.WithError(err).Error("could not unregister webhook")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *Dispatcher) authorize(r *http.Request) bool {
+	if d.Config.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(d.Config.AdminToken)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
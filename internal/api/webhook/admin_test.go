@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDispatcher_Admin(t *testing.T) {
+	d, stop := mockDispatcher(t)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, d.Start(ctx))
+
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		d.Admin(w, r)
+		return w
+	}
+
+	t.Run("test requests with invalid token are rejected", func(t *testing.T) {
+		w := do(http.MethodGet, "/webhooks", "invalid", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("test invalid registrations are rejected", func(t *testing.T) {
+		w := do(http.MethodPost, "/webhooks", "token", `{"topic": "orders"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("test registrations are created, listed and deleted", func(t *testing.T) {
+		w := do(http.MethodPost, "/webhooks", "token", `{"topic": "orders", "url": "http://example.com", "secret": "s"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		reg := &Registration{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), reg))
+		assert.NotEmpty(t, reg.ID)
+		assert.Empty(t, reg.Secret)
+
+		w = do(http.MethodGet, "/webhooks", "token", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var regs []*Registration
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &regs))
+		assert.Equal(t, []*Registration{reg}, regs)
+
+		w = do(http.MethodDelete, "/webhooks?id="+reg.ID, "token", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		regs, err := d.Store.List(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, regs)
+	})
+}
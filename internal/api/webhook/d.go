@@ -0,0 +1,195 @@
+// Package webhook delivers messages of hub topics to http endpoints which are registered by admins.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Configuration is used in Dispatcher method set.
+type Configuration struct {
+	// Enabled enables webhook dispatcher and its admin api.
+	Enabled bool `default:"false"`
+	// Store is the storage of registrations, it can be file or redis.
+	Store string `default:"file"`
+	// FilePath is path of the json file that registrations are kept in when store is file.
+	FilePath string `default:"./webhooks.json" split_words:"true"`
+	// RedisKey is the redis hash that registrations are kept in when store is redis.
+	RedisKey string `default:"websub:webhooks" split_words:"true"`
+	// AdminToken is the bearer token of admin api, the api rejects all requests when it's empty.
+	AdminToken string `split_words:"true"`
+	// Timeout is timeout of each delivery request.
+	Timeout time.Duration `default:"5s"`
+	// MaxAttempts is number of attempts to deliver a message before it's dead lettered.
+	MaxAttempts int `default:"5" split_words:"true"`
+	// InitialBackoff is the wait before the first retry, it's doubled on each retry.
+	InitialBackoff time.Duration `default:"1s" split_words:"true"`
+	// MaxBackoff is the max wait between retries.
+	MaxBackoff time.Duration `default:"1m" split_words:"true"`
+	// DeadLetterTopic is the topic that undelivered messages are published to, they are dropped if it's empty.
+	DeadLetterTopic string `default:"websub.webhooks.dead_letter" split_words:"true"`
+}
+
+// Registration is a webhook subscriber that receives messages of topics which match Topic.
+type Registration struct {
+	ID string `json:"id"`
+	// Topic is a topic or a topic pattern(e.g. orders.*).
+	Topic string `json:"topic"`
+	// URL is the endpoint that messages are posted to.
+	URL string `json:"url"`
+	// Secret is the key of HMAC signatures of deliveries.
+	Secret string `json:"secret,omitempty"`
+}
+
+// Validate checks whether the registration can be dispatched.
+func (r *Registration) Validate() error {
+	if r.Topic == "" {
+		return errors.New("topic cannot be empty")
+	}
+	if r.Secret == "" {
+		return errors.New("secret cannot be empty")
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("'%s' is not a valid http url", r.URL)
+	}
+	return nil
+}
+
+// Dispatcher subscribes to topics of registrations and posts their messages to registered urls.
+// Messages of a registration are delivered in order, so a failing endpoint delays next messages
+// until they are delivered or dead lettered.
+type Dispatcher struct {
+	Hub    hub.Hub
+	Store  Store
+	Config Configuration
+
+	logger *logrus.Logger
+	client *http.Client
+
+	mu sync.Mutex
+	// ctx is parent of subscription contexts, it's set by Start.
+	ctx     context.Context
+	cancels map[string]context.CancelFunc
+}
+
+// NewDispatcher creates a Dispatcher object.
+func NewDispatcher(config Configuration, store Store, hub hub.Hub, logger *logrus.Logger) *Dispatcher {
+	d := &Dispatcher{
+		Hub:     hub,
+		Store:   store,
+		Config:  config,
+		logger:  logger,
+		client:  &http.Client{Timeout: config.Timeout},
+		cancels: make(map[string]context.CancelFunc),
+	}
+	return d
+}
+
+// Start loads registrations from store and starts dispatching their messages until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	regs, err := d.Store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("error while loading webhook registrations, error: %s", err.Error())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ctx = ctx
+	for _, reg := range regs {
+		if err := d.start(reg); err != nil {
+			return err
+		}
+	}
+	d.logger.WithField("registrations", len(regs)).Info("webhook dispatcher started")
+	return nil
+}
+
+// Stop stops dispatching of all registrations.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, cancel := range d.cancels {
+		cancel()
+		delete(d.cancels, id)
+	}
+}
+
+// Register saves a registration and starts dispatching its messages, an id is generated for
+// registrations without id and registrations with an existing id are replaced.
+func (d *Dispatcher) Register(ctx context.Context, reg *Registration) error {
+	if err := reg.Validate(); err != nil {
+		return err
+	}
+	if reg.ID == "" {
+		reg.ID = newID()
+	}
+	if err := d.Store.Save(ctx, reg); err != nil {
+		return fmt.Errorf("error while saving webhook registration, error: %s", err.Error())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ctx == nil {
+		return nil
+	}
+	return d.start(reg)
+}
+
+// Unregister deletes a registration and stops dispatching its messages.
+func (d *Dispatcher) Unregister(ctx context.Context, id string) error {
+	if err := d.Store.Delete(ctx, id); err != nil {
+		return fmt.Errorf("error while deleting webhook registration, error: %s", err.Error())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cancel, ok := d.cancels[id]; ok {
+		cancel()
+		delete(d.cancels, id)
+	}
+	return nil
+}
+
+// start subscribes to topic of a registration, d.mu must be held by caller.
+func (d *Dispatcher) start(reg *Registration) error {
+	if cancel, ok := d.cancels[reg.ID]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(d.ctx)
+	sub, err := d.Hub.Subscribe(ctx, reg.Topic)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("error while subscribing webhook %s to %s, error: %s", reg.ID, reg.Topic, err.Error())
+	}
+	d.cancels[reg.ID] = cancel
+
+	go func() {
+		for {
+			select {
+			case msg := <-sub.MessageChannel:
+				d.deliver(ctx, reg, msg)
+			case <-ctx.Done():
+				d.logger.WithField("id", reg.ID).Debug("webhook dispatching stopped")
+				return
+			}
+		}
+	}()
+	d.logger.WithField("id", reg.ID).WithField("topic", reg.Topic).Info("webhook dispatching started")
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
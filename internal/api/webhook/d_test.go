@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func mockDispatcher(t *testing.T) (d *Dispatcher, stop func()) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	h := hub.NewRedisHub(redis.NewClient(&redis.Options{Addr: s.Addr()}), l, nil)
+	c := Configuration{
+		Timeout:         time.Second,
+		MaxAttempts:     3,
+		InitialBackoff:  10 * time.Millisecond,
+		MaxBackoff:      20 * time.Millisecond,
+		DeadLetterTopic: "dead_letter",
+		AdminToken:      "token",
+	}
+	store := NewFileStore(filepath.Join(t.TempDir(), "webhooks.json"))
+	return NewDispatcher(c, store, h, l), s.Close
+}
+
+func TestRegistration_Validate(t *testing.T) {
+	valid := Registration{Topic: "orders.*", URL: "https://example.com/hook", Secret: "secret"}
+	assert.NoError(t, valid.Validate())
+
+	invalid := valid
+	invalid.Topic = ""
+	assert.Error(t, invalid.Validate())
+	invalid = valid
+	invalid.Secret = ""
+	assert.Error(t, invalid.Validate())
+	invalid = valid
+	invalid.URL = "ftp://example.com"
+	assert.Error(t, invalid.Validate())
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	d, stop := mockDispatcher(t)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- b
+	}))
+	defer srv.Close()
+
+	assert.NoError(t, d.Start(ctx))
+	reg := &Registration{Topic: "orders.*", URL: srv.URL, Secret: "secret"}
+	assert.NoError(t, d.Register(ctx, reg))
+	assert.NotEmpty(t, reg.ID)
+	assert.NoError(t, d.Hub.Publish(ctx, "orders.created", map[string]interface{}{"id": 1}))
+
+	r := <-requests
+	body := <-bodies
+	assert.JSONEq(t, `{"topic": "orders.created", "data": {"id": 1}}`, string(body))
+	assert.Equal(t, "orders.created", r.Header.Get(TopicHeader))
+	expected := "sha256=" + Sign("secret", r.Header.Get(TimestampHeader), body)
+	assert.Equal(t, expected, r.Header.Get(SignatureHeader))
+
+	// Messages are not delivered after unregister.
+	assert.NoError(t, d.Unregister(ctx, reg.ID))
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, d.Hub.Publish(ctx, "orders.created", "unregistered"))
+	select {
+	case <-requests:
+		t.Error("message delivered to unregistered webhook")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	d, stop := mockDispatcher(t)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	dl, err := d.Hub.Subscribe(ctx, "dead_letter")
+	assert.NoError(t, err)
+	assert.NoError(t, d.Register(ctx, &Registration{ID: "failing", Topic: "orders", URL: srv.URL, Secret: "secret"}))
+	assert.NoError(t, d.Start(ctx))
+	assert.NoError(t, d.Hub.Publish(ctx, "orders", "order"))
+
+	msg := <-dl.MessageChannel
+	data := msg.Data.(map[string]interface{})
+	assert.Equal(t, "failing", data["registration_id"])
+	assert.EqualValues(t, 3, data["attempts"])
+	assert.Equal(t, "unexpected status code 500", data["error"])
+	assert.EqualValues(t, 3, atomic.LoadInt32(&attempts))
+	d.Stop()
+}
+
+func TestDispatcher_DeadLetterCodec(t *testing.T) {
+	d, stop := mockDispatcher(t)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.Hub.(*hub.RedisHub).Config.Codec = hub.RawCodec{}
+
+	// Dead letters are published as json with codecs that cannot encode structs.
+	dl, err := d.Hub.Subscribe(ctx, "dead_letter")
+	assert.NoError(t, err)
+	d.deadLetter(ctx, &Registration{ID: "failing", URL: "http://example.com"}, &Payload{Topic: "orders", Data: "order"}, 3, fmt.Errorf("unexpected status code 500"))
+
+	select {
+	case msg := <-dl.MessageChannel:
+		assert.JSONEq(t, `{"registration_id": "failing", "url": "http://example.com", "attempts": 3, "error": "unexpected status code 500", "message": {"topic": "orders", "data": "order"}}`, string(msg.Data.([]byte)))
+	case <-time.After(time.Second):
+		t.Fatal("dead letter is not published")
+	}
+}
@@ -0,0 +1,145 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of delivery requests.
+const (
+	TopicHeader     = "X-Websub-Topic"
+	MessageIDHeader = "X-Websub-Message-Id"
+	TimestampHeader = "X-Websub-Timestamp"
+	// SignatureHeader is "sha256=" followed by hex of the Sign result.
+	SignatureHeader = "X-Websub-Signature"
+)
+
+// Payload is body of delivery requests.
+type Payload struct {
+	ID    string      `json:"id,omitempty"`
+	Topic string      `json:"topic"`
+	Data  interface{} `json:"data"`
+}
+
+// DeadLetter is published to the dead letter topic when a message cannot be delivered to a registration.
+type DeadLetter struct {
+	RegistrationID string   `json:"registration_id"`
+	URL            string   `json:"url"`
+	Attempts       int      `json:"attempts"`
+	Error          string   `json:"error"`
+	Message        *Payload `json:"message"`
+}
+
+// Sign returns HMAC-SHA256 of timestamp and body that are joined by a ".", receivers should compute
+// it with the registration secret and compare it with the signature header.
+func Sign(secret, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp))
+	m.Write([]byte("."))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// deliver posts a message to url of the registration, failed requests are retried with exponential
+// backoff and the message is dead lettered after max attempts.
+func (d *Dispatcher) deliver(ctx context.Context, reg *Registration, msg *hub.Message) {
+	p := &Payload{ID: msg.ID, Topic: msg.Topic, Data: msg.Data}
+	if b, ok := p.Data.([]byte); ok {
+		p.Data = string(b)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		d.logger.WithField("id", reg.ID).WithError(err).Error("could not encode webhook payload")
+		return
+	}
+
+	backoff := d.Config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err = d.post(ctx, reg, p, body)
+		if err == nil {
+			d.logger.WithField("id", reg.ID).WithField("topic", msg.Topic).Debug("message delivered to webhook")
+			return
+		}
+		d.logger.
+			WithField("id", reg.ID).
+			WithField("topic", msg.Topic).
+			WithField("attempt", attempt).
+			WithError(err).
+			Warn("webhook delivery failed")
+		if attempt >= d.Config.MaxAttempts {
+			d.deadLetter(ctx, reg, p, attempt, err)
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > d.Config.MaxBackoff {
+			backoff = d.Config.MaxBackoff
+		}
+	}
+}
+
+// post sends a signed delivery request.
+func (d *Dispatcher) post(ctx context.Context, reg *Registration, p *Payload, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error while creating request, error: %s", err.Error())
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TopicHeader, p.Topic)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(reg.Secret, ts, body))
+	if p.ID != "" {
+		req.Header.Set(MessageIDHeader, p.ID)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deadLetter publishes an undelivered message to the dead letter topic.
+func (d *Dispatcher) deadLetter(ctx context.Context, reg *Registration, p *Payload, attempts int, err error) {
+	d.logger.WithField("id", reg.ID).WithField("topic", p.Topic).Error("message dead lettered by webhook dispatcher")
+	if d.Config.DeadLetterTopic == "" {
+		return
+	}
+	// dead letters are encoded here so they can be published with any hub codec.
+	b, err := json.Marshal(&DeadLetter{
+		RegistrationID: reg.ID,
+		URL:            reg.URL,
+		Attempts:       attempts,
+		Error:          err.Error(),
+		Message:        p,
+	})
+	if err != nil {
+		d.logger.WithField("id", reg.ID).WithError(err).Error("could not encode dead letter")
+		return
+	}
+	if err := d.Hub.Publish(ctx, d.Config.DeadLetterTopic, json.RawMessage(b)); err != nil {
+		d.logger.WithField("id", reg.ID).WithError(err).Error("could not publish dead letter")
+	}
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Names of registration stores.
+const (
+	FileStoreName  = "file"
+	RedisStoreName = "redis"
+)
+
+// Store keeps webhook registrations.
+type Store interface {
+	List(ctx context.Context) ([]*Registration, error)
+	Save(ctx context.Context, reg *Registration) error
+	Delete(ctx context.Context, id string) error
+}
+
+// FileStore keeps registrations in a json file.
+type FileStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFileStore creates a FileStore object.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+// List returns registrations of the file, it's empty when the file doesn't exist.
+func (s *FileStore) List(_ context.Context) ([]*Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// Save adds or replaces a registration in the file.
+func (s *FileStore) Save(_ context.Context, reg *Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	regs, err := s.read()
+	if err != nil {
+		return err
+	}
+	saved := false
+	for i := range regs {
+		if regs[i].ID == reg.ID {
+			regs[i] = reg
+			saved = true
+		}
+	}
+	if !saved {
+		regs = append(regs, reg)
+	}
+	return s.write(regs)
+}
+
+// Delete removes a registration from the file.
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	regs, err := s.read()
+	if err != nil {
+		return err
+	}
+	kept := regs[:0]
+	for _, r := range regs {
+		if r.ID != id {
+			kept = append(kept, r)
+		}
+	}
+	return s.write(kept)
+}
+
+func (s *FileStore) read() ([]*Registration, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while reading file %s, error: %s", s.Path, err.Error())
+	}
+	var regs []*Registration
+	if err := json.Unmarshal(b, &regs); err != nil {
+		return nil, fmt.Errorf("error while decoding file %s, error: %s", s.Path, err.Error())
+	}
+	return regs, nil
+}
+
+// write replaces the file with a temp file, so the file is not corrupted by a failed write.
+func (s *FileStore) write(regs []*Registration) error {
+	b, err := json.MarshalIndent(regs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error while encoding registrations, error: %s", err.Error())
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path))
+	if err != nil {
+		return fmt.Errorf("error while creating temp file, error: %s", err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error while writing temp file, error: %s", err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error while closing temp file, error: %s", err.Error())
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return fmt.Errorf("error while replacing file %s, error: %s", s.Path, err.Error())
+	}
+	return nil
+}
+
+// RedisStore keeps registrations as json values of a redis hash.
+type RedisStore struct {
+	Client redis.UniversalClient
+	Key    string
+}
+
+// NewRedisStore creates a RedisStore object.
+func NewRedisStore(client redis.UniversalClient, key string) *RedisStore {
+	return &RedisStore{Client: client, Key: key}
+}
+
+// List returns registrations of the hash sorted by id.
+func (s *RedisStore) List(ctx context.Context) ([]*Registration, error) {
+	m, err := s.Client.HGetAll(ctx, s.Key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading redis hash %s, error: %s", s.Key, err.Error())
+	}
+	regs := make([]*Registration, 0, len(m))
+	for id, v := range m {
+		reg := &Registration{}
+		if err := json.Unmarshal([]byte(v), reg); err != nil {
+			return nil, fmt.Errorf("error while decoding registration %s, error: %s", id, err.Error())
+		}
+		regs = append(regs, reg)
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].ID < regs[j].ID })
+	return regs, nil
+}
+
+// Save adds or replaces a registration in the hash.
+func (s *RedisStore) Save(ctx context.Context, reg *Registration) error {
+	b, err := json.Marshal(reg)
+	if err != nil {
+		return fmt.Errorf("error while encoding registration, error: %s", err.Error())
+	}
+	return s.Client.HSet(ctx, s.Key, reg.ID, b).Err()
+}
+
+// Delete removes a registration from the hash.
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return s.Client.HDel(ctx, s.Key, id).Err()
+}
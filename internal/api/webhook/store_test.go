@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	regs, err := s.List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, regs)
+
+	first := &Registration{ID: "1", Topic: "orders", URL: "http://example.com", Secret: "secret"}
+	second := &Registration{ID: "2", Topic: "payments", URL: "http://example.com", Secret: "secret"}
+	assert.NoError(t, s.Save(ctx, first))
+	assert.NoError(t, s.Save(ctx, second))
+	first.Topic = "orders.*"
+	assert.NoError(t, s.Save(ctx, first))
+	regs, err = s.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Registration{first, second}, regs)
+
+	assert.NoError(t, s.Delete(ctx, "1"))
+	regs, err = s.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Registration{second}, regs)
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, NewFileStore(filepath.Join(t.TempDir(), "webhooks.json")))
+}
+
+func TestRedisStore(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, NewRedisStore(redis.NewClient(&redis.Options{Addr: s.Addr()}), "websub:webhooks"))
+}
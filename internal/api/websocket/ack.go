@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"github.com/mammadmodi/websub/pkg/hub"
+	"sync"
+	"time"
+)
+
+// Statuses of acknowledgement reports.
+const (
+	AckStatus  = "ack"
+	NackStatus = "nack"
+)
+
+// AckReport is published to the ack topic when a user acknowledges a message or when
+// the message is not acknowledged after max deliveries.
+type AckReport struct {
+	ID       string `json:"id"`
+	Topic    string `json:"topic"`
+	Username string `json:"username"`
+	Status   string `json:"status"`
+}
+
+// MessageFrame is structure of messages that are sent to users who acknowledge messages or ask for frames.
+type MessageFrame struct {
+	ID    string      `json:"id"`
+	Topic string      `json:"topic"`
+	Data  interface{} `json:"data"`
+	// Retained is true for retained messages of topics that are sent after the subscription is created.
+	Retained bool `json:"retained,omitempty"`
+}
+
+// newMessageFrame creates a MessageFrame from a hub message.
+func newMessageFrame(msg *hub.Message) *MessageFrame {
+	d := msg.Data
+	if b, ok := d.([]byte); ok {
+		d = string(b)
+	}
+	return &MessageFrame{
+		ID:       msg.ID,
+		Topic:    msg.Topic,
+		Data:     d,
+		Retained: msg.Retained,
+	}
+}
+
+type pendingMessage struct {
+	msg        *hub.Message
+	sentAt     time.Time
+	deliveries int
+}
+
+// ackTracker keeps messages that are delivered to a user and are not acknowledged yet.
+type ackTracker struct {
+	timeout       time.Duration
+	maxDeliveries int
+
+	mu      sync.Mutex
+	pending map[string]*pendingMessage
+}
+
+func newAckTracker(timeout time.Duration, maxDeliveries int) *ackTracker {
+	return &ackTracker{
+		timeout:       timeout,
+		maxDeliveries: maxDeliveries,
+		pending:       make(map[string]*pendingMessage),
+	}
+}
+
+// delivered records a delivery of the message.
+func (t *ackTracker) delivered(msg *hub.Message, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.pending[msg.ID]
+	if !ok {
+		p = &pendingMessage{msg: msg}
+		t.pending[msg.ID] = p
+	}
+	p.sentAt = now
+	p.deliveries++
+}
+
+// remove stops tracking of a message and returns it, ok is false if the message was not pending.
+func (t *ackTracker) remove(id string) (msg *hub.Message, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.pending[id]
+	if !ok {
+		return nil, false
+	}
+	delete(t.pending, id)
+	return p.msg, true
+}
+
+// expired returns messages that are not acknowledged in time, messages which are delivered max
+// deliveries times are returned as exhausted and are not tracked anymore.
+func (t *ackTracker) expired(now time.Time) (redeliver, exhausted []*hub.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, p := range t.pending {
+		if now.Sub(p.sentAt) < t.timeout {
+			continue
+		}
+		if p.deliveries >= t.maxDeliveries {
+			exhausted = append(exhausted, p.msg)
+			delete(t.pending, id)
+			continue
+		}
+		redeliver = append(redeliver, p.msg)
+	}
+	return redeliver, exhausted
+}
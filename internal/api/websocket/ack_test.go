@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAckTracker(t *testing.T) {
+	at := newAckTracker(10*time.Second, 2)
+	now := time.Now()
+	first := &hub.Message{ID: "1", Topic: "topic", Data: "first"}
+	second := &hub.Message{ID: "2", Topic: "topic", Data: "second"}
+	at.delivered(first, now)
+	at.delivered(second, now.Add(5*time.Second))
+
+	t.Run("test messages are not expired before ack timeout", func(t *testing.T) {
+		redeliver, exhausted := at.expired(now.Add(time.Second))
+		assert.Empty(t, redeliver)
+		assert.Empty(t, exhausted)
+	})
+
+	t.Run("test expired messages are redelivered", func(t *testing.T) {
+		redeliver, exhausted := at.expired(now.Add(10 * time.Second))
+		assert.Equal(t, []*hub.Message{first}, redeliver)
+		assert.Empty(t, exhausted)
+		at.delivered(first, now.Add(10*time.Second))
+	})
+
+	t.Run("test messages are exhausted after max deliveries", func(t *testing.T) {
+		redeliver, exhausted := at.expired(now.Add(20 * time.Second))
+		assert.Equal(t, []*hub.Message{second}, redeliver)
+		assert.Equal(t, []*hub.Message{first}, exhausted)
+		_, ok := at.remove(first.ID)
+		assert.False(t, ok)
+	})
+
+	t.Run("test acknowledged messages are removed", func(t *testing.T) {
+		msg, ok := at.remove(second.ID)
+		assert.True(t, ok)
+		assert.Equal(t, second, msg)
+		redeliver, exhausted := at.expired(now.Add(time.Minute))
+		assert.Empty(t, redeliver)
+		assert.Empty(t, exhausted)
+	})
+}
+
+func TestNewMessageFrame(t *testing.T) {
+	f := newMessageFrame(&hub.Message{ID: "1", Topic: "topic", Data: []byte("data")})
+	assert.Equal(t, &MessageFrame{ID: "1", Topic: "topic", Data: "data"}, f)
+}
@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/tenant"
+)
+
+// ConnectionInfo describes a websocket connection of a user.
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Topics      []string  `json:"topics"`
+	Group       string    `json:"group,omitempty"`
+	Filter      string    `json:"filter,omitempty"`
+	Protocol    string    `json:"protocol,omitempty"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Presence is the list of users that are connected to a topic.
+type Presence struct {
+	Topic string   `json:"topic"`
+	Users []string `json:"users"`
+}
+
+// track adds a connection to the connections of the instance and returns a function that removes it.
+func (h *SockHub) track(ci *ConnectionInfo) func() {
+	h.connsMu.Lock()
+	h.conns[ci.ID] = ci
+	h.connsMu.Unlock()
+	activeConnections.WithLabelValues(ci.Tenant).Inc()
+	return func() {
+		h.connsMu.Lock()
+		delete(h.conns, ci.ID)
+		h.connsMu.Unlock()
+		activeConnections.WithLabelValues(ci.Tenant).Dec()
+	}
+}
+
+// setTopics replaces topics of a connection whose subscriptions change after it's created, the ConnectionInfo
+// is copied because returned connections are read without the lock.
+func (h *SockHub) setTopics(id string, topics []string) {
+	sort.Strings(topics)
+	h.connsMu.Lock()
+	defer h.connsMu.Unlock()
+	if ci, ok := h.conns[id]; ok {
+		c := *ci
+		c.Topics = topics
+		h.conns[id] = &c
+	}
+}
+
+// Connections returns connections of the instance in the order they are created, connections of
+// username are returned when it's not empty.
+func (h *SockHub) Connections(username string) []*ConnectionInfo {
+	h.connsMu.RLock()
+	cs := make([]*ConnectionInfo, 0, len(h.conns))
+	for _, ci := range h.conns {
+		if username == "" || ci.Username == username {
+			cs = append(cs, ci)
+		}
+	}
+	h.connsMu.RUnlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].ConnectedAt.Before(cs[j].ConnectedAt) })
+	return cs
+}
+
+// Presence returns users that are connected to the instance and receive messages of topic, subscriptions
+// to patterns that match topic are included. Topics of tenants are prefixed with their name.
+func (h *SockHub) Presence(topic string) *Presence {
+	users := make(map[string]bool)
+	h.connsMu.RLock()
+	for _, ci := range h.conns {
+		for _, t := range ci.Topics {
+			if ci.Tenant != "" {
+				t = tenant.Topic(ci.Tenant, t)
+			}
+			if t == topic || hub.MatchTopic(t, topic) {
+				users[ci.Username] = true
+				break
+			}
+		}
+	}
+	h.connsMu.RUnlock()
+	p := &Presence{Topic: topic, Users: make([]string, 0, len(users))}
+	for u := range users {
+		p.Users = append(p.Users, u)
+	}
+	sort.Strings(p.Users)
+	return p
+}
+
+// ConnectionsAdmin is a http handler that lists connections of the instance(GET with optional username
+// query), requests must have the admin token as a bearer token.
+func (h *SockHub) ConnectionsAdmin(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Connections(r.URL.Query().Get("username")))
+}
+
+// PresenceAdmin is a http handler that returns users of a topic(GET with topic query) who are connected
+// to the instance, requests must have the admin token as a bearer token.
+func (h *SockHub) PresenceAdmin(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("topic cannot be empty"))
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Presence(topic))
+}
+
+// authorize checks the admin token and method of admin requests and writes the error response.
+func (h *SockHub) authorize(w http.ResponseWriter, r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.Config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.Config.AdminToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte("invalid admin token"))
+		return false
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSockHub_Admin(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	ts.sh.Config.AdminToken = "secret"
+	ts.dial(t, websocket.DefaultDialer, "username=john&topics=orders.*,news")
+	for i := 0; i < 100 && len(ts.sh.Connections("")) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ts.dial(t, websocket.DefaultDialer, "username=jane&topics=news", "news")
+	ts.dial(t, websocket.DefaultDialer, "username=jack&topics=sports", "sports")
+
+	request := func(h http.HandlerFunc, token, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request(ts.sh.ConnectionsAdmin, "invalid", "").Code)
+	w := request(ts.sh.ConnectionsAdmin, "secret", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var cs []*ConnectionInfo
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&cs))
+	assert.Len(t, cs, 3)
+	assert.Equal(t, "john", cs[0].Username)
+	assert.Equal(t, []string{"orders.*", "news"}, cs[0].Topics)
+
+	w = request(ts.sh.ConnectionsAdmin, "secret", "username=jane")
+	cs = nil
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&cs))
+	assert.Len(t, cs, 1)
+
+	assert.Equal(t, http.StatusBadRequest, request(ts.sh.PresenceAdmin, "secret", "").Code)
+	w = request(ts.sh.PresenceAdmin, "secret", "topic=news")
+	p := &Presence{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(p))
+	assert.Equal(t, &Presence{Topic: "news", Users: []string{"jane", "john"}}, p)
+	assert.Equal(t, []string{"john"}, ts.sh.Presence("orders.created").Users)
+
+	// Disabled when admin token is empty.
+	ts.sh.Config.AdminToken = ""
+	assert.Equal(t, http.StatusUnauthorized, request(ts.sh.PresenceAdmin, "", "topic=news").Code)
+}
@@ -0,0 +1,193 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/mammadmodi/websub/pkg/hub"
+)
+
+// batch collects messages of a connection that are written in one frame, it's flushed when it has size
+// messages or interval after its first message.
+type batch struct {
+	size     int
+	interval time.Duration
+	msgs     []*hub.Message
+	timer    *time.Timer
+}
+
+func newBatch(size int, interval time.Duration) *batch {
+	return &batch{size: size, interval: interval}
+}
+
+// add adds a message to the batch and reports whether the batch is full.
+func (b *batch) add(msg *hub.Message) bool {
+	if len(b.msgs) == 0 {
+		b.timer = time.NewTimer(b.interval)
+	}
+	b.msgs = append(b.msgs, msg)
+	return len(b.msgs) >= b.size
+}
+
+// due is the channel of the flush timer, it's nil when the batch is empty.
+func (b *batch) due() <-chan time.Time {
+	if b == nil || b.timer == nil {
+		return nil
+	}
+	return b.timer.C
+}
+
+// take empties the batch and returns its messages.
+func (b *batch) take() []*hub.Message {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	msgs := b.msgs
+	b.msgs = nil
+	return msgs
+}
+
+// encodeBatch encodes messages of a batch to a json array, elements are message frames when user asks
+// for frames or data of messages, data that is not json is encoded as a json string.
+func encodeBatch(msgs []*hub.Message, frames bool) ([]byte, error) {
+	elements := make([]interface{}, 0, len(msgs))
+	for _, msg := range msgs {
+		if frames {
+			elements = append(elements, newMessageFrame(msg))
+			continue
+		}
+		b, err := encodeData(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		if json.Valid(b) {
+			elements = append(elements, json.RawMessage(b))
+		} else {
+			elements = append(elements, string(b))
+		}
+	}
+	return json.Marshal(elements)
+}
+
+// coalesceWindow is the coalescing window of topics that match a pattern.
+type coalesceWindow struct {
+	pattern string
+	window  time.Duration
+}
+
+// coalescer keeps the latest message of each coalesced topic of a connection until the end of its window,
+// so only the newest message of a window is delivered.
+type coalescer struct {
+	windows []coalesceWindow
+	pending map[string]*hub.Message
+	// deadlines are ends of windows of pending topics.
+	deadlines map[string]time.Time
+	timer     *time.Timer
+}
+
+// sortWindows returns coalescing windows of topic patterns, longer patterns are more specific so they're
+// matched first.
+func sortWindows(windows map[string]time.Duration) []coalesceWindow {
+	var ws []coalesceWindow
+	for p, w := range windows {
+		if w > 0 {
+			ws = append(ws, coalesceWindow{pattern: p, window: w})
+		}
+	}
+	sort.Slice(ws, func(i, j int) bool {
+		if len(ws[i].pattern) != len(ws[j].pattern) {
+			return len(ws[i].pattern) > len(ws[j].pattern)
+		}
+		return ws[i].pattern < ws[j].pattern
+	})
+	return ws
+}
+
+// newCoalescer creates a coalescer of windows, it returns nil when there's no window.
+func newCoalescer(windows []coalesceWindow) *coalescer {
+	if len(windows) == 0 {
+		return nil
+	}
+	return &coalescer{windows: windows, pending: make(map[string]*hub.Message), deadlines: make(map[string]time.Time)}
+}
+
+// window returns the coalescing window of topic.
+func (c *coalescer) window(topic string) (time.Duration, bool) {
+	for _, w := range c.windows {
+		if w.pattern == topic || hub.MatchTopic(w.pattern, topic) {
+			return w.window, true
+		}
+	}
+	return 0, false
+}
+
+// add keeps msg as the latest message of its topic and reports whether it's coalesced, messages of topics
+// without a window are not coalesced.
+func (c *coalescer) add(msg *hub.Message, now time.Time) bool {
+	w, ok := c.window(msg.Topic)
+	if !ok {
+		return false
+	}
+	if _, ok := c.pending[msg.Topic]; ok {
+		coalescedMessages.Inc()
+	} else {
+		c.deadlines[msg.Topic] = now.Add(w)
+		c.reset(now)
+	}
+	c.pending[msg.Topic] = msg
+	return true
+}
+
+// expired returns latest messages of topics whose window is ended, in the order of their windows.
+func (c *coalescer) expired(now time.Time) []*hub.Message {
+	var topics []string
+	for t, d := range c.deadlines {
+		if !d.After(now) {
+			topics = append(topics, t)
+		}
+	}
+	sort.Slice(topics, func(i, j int) bool { return c.deadlines[topics[i]].Before(c.deadlines[topics[j]]) })
+	msgs := make([]*hub.Message, 0, len(topics))
+	for _, t := range topics {
+		msgs = append(msgs, c.pending[t])
+		delete(c.pending, t)
+		delete(c.deadlines, t)
+	}
+	c.timer = nil
+	c.reset(now)
+	return msgs
+}
+
+// reset sets the timer to the earliest end of pending windows.
+func (c *coalescer) reset(now time.Time) {
+	var next time.Time
+	for _, d := range c.deadlines {
+		if next.IsZero() || d.Before(next) {
+			next = d
+		}
+	}
+	if next.IsZero() {
+		return
+	}
+	if c.timer == nil {
+		c.timer = time.NewTimer(next.Sub(now))
+		return
+	}
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+	c.timer.Reset(next.Sub(now))
+}
+
+// due is the channel of the timer of the earliest window, it's nil when no message is pending.
+func (c *coalescer) due() <-chan time.Time {
+	if c == nil || c.timer == nil {
+		return nil
+	}
+	return c.timer.C
+}
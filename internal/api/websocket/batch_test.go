@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	b := newBatch(2, time.Minute)
+	assert.Nil(t, b.due())
+	assert.False(t, b.add(&hub.Message{Topic: "a"}))
+	assert.NotNil(t, b.due())
+	assert.True(t, b.add(&hub.Message{Topic: "b"}))
+	assert.Len(t, b.take(), 2)
+	assert.Nil(t, b.due())
+	assert.Empty(t, b.take())
+
+	b = newBatch(10, 10*time.Millisecond)
+	b.add(&hub.Message{Topic: "a"})
+	select {
+	case <-b.due():
+	case <-time.After(time.Second):
+		t.Fatal("batch is not due after its interval")
+	}
+}
+
+func TestEncodeBatch(t *testing.T) {
+	msgs := []*hub.Message{
+		{ID: "1", Topic: "prices.btc", Data: map[string]interface{}{"price": 1.5}},
+		{ID: "2", Topic: "news", Data: "breaking"},
+		{ID: "3", Topic: "news", Data: []byte(`{"title":"hi"}`)},
+	}
+	b, err := encodeBatch(msgs, false)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"price":1.5},"breaking",{"title":"hi"}]`, string(b))
+
+	b, err = encodeBatch(msgs[:2], true)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"id":"1","topic":"prices.btc","data":{"price":1.5}},{"id":"2","topic":"news","data":"breaking"}]`, string(b))
+}
+
+func TestCoalescer(t *testing.T) {
+	assert.Nil(t, newCoalescer(sortWindows(map[string]time.Duration{"prices.*": 0})))
+	c := newCoalescer(sortWindows(map[string]time.Duration{"prices.*": time.Second, "prices.btc": 2 * time.Second}))
+	assert.Nil(t, c.due())
+
+	now := time.Now()
+	assert.False(t, c.add(&hub.Message{Topic: "news", Data: 1}, now))
+	assert.True(t, c.add(&hub.Message{Topic: "prices.eth", Data: 1}, now))
+	assert.True(t, c.add(&hub.Message{Topic: "prices.btc", Data: 1}, now))
+	assert.True(t, c.add(&hub.Message{Topic: "prices.eth", Data: 2}, now.Add(500*time.Millisecond)))
+	assert.NotNil(t, c.due())
+
+	assert.Empty(t, c.expired(now.Add(900*time.Millisecond)))
+	// The more specific pattern of prices.btc has a longer window.
+	msgs := c.expired(now.Add(time.Second))
+	assert.Equal(t, []*hub.Message{{Topic: "prices.eth", Data: 2}}, msgs)
+	assert.NotNil(t, c.due())
+	msgs = c.expired(now.Add(2 * time.Second))
+	assert.Equal(t, []*hub.Message{{Topic: "prices.btc", Data: 1}}, msgs)
+	assert.Nil(t, c.due())
+}
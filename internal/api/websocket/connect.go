@@ -2,25 +2,75 @@ package websocket
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/internal/api/schema"
+	"github.com/mammadmodi/websub/pkg/filter"
 	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/tenant"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// Types of messages that are received from user.
+const (
+	PublishMessage = "publish"
+	AckMessage     = "ack"
+	NackMessage    = "nack"
+	RequestMessage = "request"
+)
+
 // ClientMessage is structure of messages that will be received from user.
 type ClientMessage struct {
+	// Type is type of the message, messages without type are published to the topic.
+	Type string `json:"type,omitempty"`
+	// ID is id of the message that is acknowledged by an ack or nack message, or id of a request
+	// which is returned in its reply.
+	ID    string `json:"id,omitempty"`
 	Body  string `json:"body"`
 	Topic string `json:"topic"`
+	// Retain keeps a published message as the retained message of its topic, an empty body removes the
+	// retained message of the topic.
+	Retain bool `json:"retain,omitempty"`
+}
+
+// ErrorMessage is type of frames that report rejected user messages.
+const ErrorMessage = "error"
+
+// ErrorFrame is structure of messages that are sent to users when their messages are rejected, ID is the
+// id of the rejected message.
+type ErrorFrame struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	Topic   string      `json:"topic,omitempty"`
+	Error   string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 // Connect is a http handler that in first upgrades protocol to Websocket Protocol and
-// then creates subscriptions to topics which user is requested.
+// then creates subscriptions to topics which user is requested, connections that negotiate
+// StompSubprotocol or GraphQLSubprotocol are served by their protocol.
 func (h *SockHub) Connect(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.admit(w, r)
+	if !ok {
+		return
+	}
+	defer t.Release()
+	// Clients of subprotocols subscribe with their messages after the connection is created.
+	switch h.subprotocol(r) {
+	case StompSubprotocol:
+		h.stomp(w, r, t)
+		return
+	case GraphQLSubprotocol:
+		h.graphql(w, r, t)
+		return
+	}
 	// Validate request and resolve parameters
 	if err := validateRequest(r); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -30,13 +80,75 @@ func (h *SockHub) Connect(w http.ResponseWriter, r *http.Request) {
 	// TODO Authenticate and Authorize topic accesses for user.
 	un := r.URL.Query().Get("username")
 	topics := strings.Split(r.URL.Query().Get("topics"), ",")
+	ack := r.URL.Query().Get("ack") == "true"
+	dh, durable := h.Hub.(hub.DurableHub)
+	if ack && !durable {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("acknowledgements are not supported by the hub"))
+		return
+	}
+	// Connections of a queue group share messages of topics, e.g. backend workers that process commands.
+	group := r.URL.Query().Get("group")
+	qh, queue := h.Hub.(hub.QueueHub)
+	if group != "" && (!queue || ack) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("queue groups are not supported by the hub or with acknowledgements"))
+		return
+	}
+	if group != "" && !h.joinable(group, r.URL.Query().Get("group_token")) {
+		h.logger.WithField("username", un).WithField("group", group).Warn("connection rejected because it can't join the queue group")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("queue group is not allowed"))
+		return
+	}
+
+	// Filters select messages of busy topics on the server, they can't be used with groups because filtered
+	// messages would be consumed without being delivered to any member.
+	var f *filter.Filter
+	if expr := r.URL.Query().Get("filter"); expr != "" {
+		if group != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("filters cannot be used with queue groups"))
+			return
+		}
+		var err error
+		f, err = filter.Compile(expr, filter.Limits{
+			MaxLength: h.Config.FilterMaxLength,
+			MaxNodes:  h.Config.FilterMaxNodes,
+			MaxDepth:  h.Config.FilterMaxDepth,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(fmt.Sprintf("invalid filter, error: %s", err.Error())))
+			return
+		}
+	}
+
+	// Batches of high frequency topics are written in one frame.
+	var b *batch
+	if ms := r.URL.Query().Get("batch_ms"); ms != "" {
+		var err error
+		if b, err = h.parseBatch(ms, r.URL.Query().Get("batch_size")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+	}
 
 	// Upgrade http connection to websocket and configure connection.
-	wsConn, err := h.upgrader.Upgrade(w, r, nil)
+	cw := &countingWriter{ResponseWriter: w}
+	wsConn, err := h.upgrader.Upgrade(cw, r, nil)
 	if err != nil {
 		h.logger.WithField("error", err).WithField("username", un).Error("upgrade error")
 		return
 	}
+	compress := h.Config.EnableCompression && offersDeflate(r)
+	connections.WithLabelValues(strconv.FormatBool(compress), t.Label()).Inc()
+	if compress {
+		if err := wsConn.SetCompressionLevel(h.Config.CompressionLevel); err != nil {
+			h.logger.WithField("level", h.Config.CompressionLevel).WithError(err).Error("invalid compression level")
+		}
+	}
 	wsConn.SetReadLimit(h.Config.ReadLimit)
 	if err := wsConn.SetReadDeadline(time.Now().Add(h.Config.PongWait)); err != nil {
 		h.logger.WithField("error", err.Error()).Error("error while setting read deadline")
@@ -50,6 +162,37 @@ func (h *SockHub) Connect(w http.ResponseWriter, r *http.Request) {
 		return nil
 	})
 	h.logger.WithField("username", un).WithField("topics", topics).Info("connection created for user")
+	sess := &session{
+		username:             un,
+		conn:                 wsConn,
+		tenant:               t,
+		filter:               f,
+		batch:                b,
+		frames:               ack || r.URL.Query().Get("frames") == "true",
+		compress:             compress,
+		compressionThreshold: h.Config.CompressionThreshold,
+		wire:                 cw.conn,
+		writeWait:            h.Config.WriteWait,
+		requests:             make(chan struct{}, h.maxPendingRequests),
+	}
+	if ack {
+		sess.acks = newAckTracker(h.ackTimeout, h.maxDeliveries)
+	} else {
+		// Messages of users who acknowledge messages are not coalesced because each of them must be acknowledged.
+		sess.coalescer = newCoalescer(h.windows)
+	}
+
+	untrack := h.track(&ConnectionInfo{
+		ID:          strconv.FormatUint(atomic.AddUint64(&h.connSeq, 1), 10),
+		Username:    un,
+		Tenant:      t.Label(),
+		Topics:      topics,
+		Group:       group,
+		Filter:      r.URL.Query().Get("filter"),
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: time.Now(),
+	})
+	defer untrack()
 
 	// Schedule ws connection close at the end.
 	defer func() {
@@ -60,25 +203,96 @@ func (h *SockHub) Connect(w http.ResponseWriter, r *http.Request) {
 		h.logger.WithField("username", un).Info("socket connection closed")
 	}()
 
-	// Create hub subscription for user topics.
+	// Create hub subscription for user topics, topics of tenants are in their namespace.
 	ctxWithCancel, cancel := context.WithCancel(r.Context())
 	// Schedule hub unsubscribe at the end.
 	defer cancel()
-	sub, err := h.Hub.Subscribe(ctxWithCancel, topics...)
+	hubTopics := t.Topics(topics)
+	var sub *hub.Subscription
+	switch {
+	case ack:
+		// Messages after last_id are redelivered when a user reconnects.
+		sub, err = dh.SubscribeFrom(ctxWithCancel, r.URL.Query().Get("last_id"), hubTopics...)
+	case group != "":
+		sub, err = qh.QueueSubscribe(ctxWithCancel, group, hubTopics...)
+	case h.shared != nil:
+		var unsubscribe func()
+		if sub, unsubscribe, err = h.shared.subscribe(hubTopics...); err == nil {
+			defer unsubscribe()
+		}
+	default:
+		sub, err = h.Hub.Subscribe(ctxWithCancel, hubTopics...)
+	}
 	if err != nil {
-		h.logger.WithField("username", un).Info("subscriptions failed for user")
-		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.WithField("username", un).WithError(err).Info("subscriptions failed for user")
 		return
 	}
 	h.logger.WithField("username", un).Info("hub subscriptions created for user")
 
-	// Launch a ws pinger in background.
+	// Retained messages are read after the subscription is created, so updates between them are not missed.
+	var retained []*hub.Message
+	if !ack && group == "" {
+		retained = h.retained(ctxWithCancel, sess, topics)
+	}
+
+	// Pings are sent by the writer, so each connection has one goroutine besides the handler.
 	pingTicker := time.NewTicker(h.Config.PingInterval)
 	defer pingTicker.Stop()
-	go h.pingOnTick(un, pingTicker, wsConn)
 
-	h.writer(un, wsConn, sub)
-	h.reader(ctxWithCancel, un, wsConn)
+	h.writer(ctxWithCancel, sess, sub, pingTicker.C, retained)
+	h.reader(ctxWithCancel, sess)
+}
+
+// admit resolves the tenant of a connection request and reserves a connection of it, it writes the error
+// response and returns false when the connection is not admitted.
+func (h *SockHub) admit(w http.ResponseWriter, r *http.Request) (*tenant.Tenant, bool) {
+	t, err := h.Tenants.Resolve(r)
+	if err != nil {
+		h.logger.WithField("remote_addr", r.RemoteAddr).WithField("host", r.Host).Info("connection rejected because its tenant is unknown")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	if err := t.Acquire(); err != nil {
+		h.logger.WithField("tenant", t.Name).Warn("connection rejected by connection limit of tenant")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	return t, true
+}
+
+// joinable reports whether a connection with token can join the queue group, groups are joined only with
+// their token in QueueGroups because members consume messages of the group.
+func (h *SockHub) joinable(group, token string) bool {
+	t, ok := h.Config.QueueGroups[group]
+	return ok && t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1
+}
+
+// subprotocol returns the subprotocol that the upgrader negotiates, it's the first protocol that client
+// offers and the upgrader supports.
+func (h *SockHub) subprotocol(r *http.Request) string {
+	for _, p := range websocket.Subprotocols(r) {
+		for _, s := range h.upgrader.Subprotocols {
+			if p == s {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// retained returns retained messages of topics of user when the hub retains messages.
+func (h *SockHub) retained(ctx context.Context, sess *session, topics []string) []*hub.Message {
+	rh, ok := h.Hub.(hub.RetainHub)
+	if !ok {
+		return nil
+	}
+	msgs, err := rh.Retained(ctx, sess.tenant.Topics(topics)...)
+	if err != nil && err != hub.ErrNotRetained {
+		h.logger.WithField("username", sess.username).WithField("topics", topics).WithError(err).Error("could not get retained messages")
+	}
+	return msgs
 }
 
 func validateRequest(req *http.Request) error {
@@ -95,81 +309,405 @@ func validateRequest(req *http.Request) error {
 	return nil
 }
 
-// pingOnTick sends a ping message to user when receives a signal from ping ticker.
-func (h *SockHub) pingOnTick(un string, pingTicker *time.Ticker, conn *websocket.Conn) {
-	for {
-		<-pingTicker.C
-		h.logger.WithField("username", un).Debug("writing ping message")
-		if err := conn.SetWriteDeadline(time.Now().Add(h.Config.WriteWait)); err != nil {
-			h.logger.WithField("error", err.Error()).Error("error while setting write deadline")
-			return
-		}
-
-		if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
-			h.logger.WithField("error", err.Error()).Error("error while sending ping message")
-			return
+// offersDeflate reports whether client offers permessage-deflate extension, the upgrader negotiates
+// it with these clients when compression is enabled.
+func offersDeflate(r *http.Request) bool {
+	for _, ext := range r.Header.Values("Sec-Websocket-Extensions") {
+		for _, e := range strings.Split(ext, ",") {
+			if strings.HasPrefix(strings.TrimSpace(e), "permessage-deflate") {
+				return true
+			}
 		}
-		h.logger.WithField("username", un).Debug("ping sent")
 	}
+	return false
 }
 
-// writer launches channel listeners in background which will receive messages from topics user is subscribed to.
-func (h *SockHub) writer(un string, conn *websocket.Conn, sub *hub.Subscription) {
+// writer launches channel listeners in background which will receive messages from topics user is subscribed to,
+// it sends retained messages of topics first and a ping message to user on each tick of ping.
+func (h *SockHub) writer(ctx context.Context, sess *session, sub *hub.Subscription, ping <-chan time.Time, retained []*hub.Message) {
 	// pass hub messages to user
 	go func(s *hub.Subscription) {
 		h.logger.WithField("topics", s.Topics).Debug("listening to message channel")
-		for msg := range s.MessageChannel {
-			h.logger.
-				WithField("channel", msg.Topic).
-				WithField("payload", msg.Data).
-				Info("message received from hub")
-
-			err := conn.WriteMessage(1, []byte(fmt.Sprintf("%v", msg.Data))) // TODO review Stringify
-			if err != nil {
-				h.logger.WithField("error", err).Error("error while sending message to user")
-				break
+		defer h.logger.WithField("topics", s.Topics).Debug("message channel closed")
+
+		// Retained messages are sent before messages of the subscription.
+		for _, msg := range retained {
+			if err := h.receive(ctx, sess, msg); err != nil {
+				h.logger.WithField("error", err).Error("error while sending retained message to user")
+				return
+			}
+		}
+
+		// Unacknowledged messages are checked for redelivery twice in each ack timeout.
+		var redeliver <-chan time.Time
+		if sess.acks != nil {
+			t := time.NewTicker(h.ackTimeout / 2)
+			defer t.Stop()
+			redeliver = t.C
+		}
+		for {
+			select {
+			case msg := <-s.MessageChannel:
+				h.logger.
+					WithField("channel", msg.Topic).
+					WithField("payload", msg.Data).
+					Info("message received from hub")
+				if err := h.receive(ctx, sess, msg); err != nil {
+					h.logger.WithField("error", err).Error("error while sending message to user")
+					return
+				}
+			case now := <-sess.coalescer.due():
+				for _, msg := range sess.coalescer.expired(now) {
+					if err := h.send(sess, msg); err != nil {
+						h.logger.WithField("error", err).Error("error while sending message to user")
+						return
+					}
+				}
+			case <-sess.batch.due():
+				if err := h.flush(sess); err != nil {
+					h.logger.WithField("error", err).Error("error while sending batch to user")
+					return
+				}
+			case <-ping:
+				h.logger.WithField("username", sess.username).Debug("writing ping message")
+				if err := sess.write(websocket.PingMessage, []byte{}); err != nil {
+					h.logger.WithField("error", err.Error()).Error("error while sending ping message")
+					return
+				}
+				h.logger.WithField("username", sess.username).Debug("ping sent")
+			case now := <-redeliver:
+				msgs, exhausted := sess.acks.expired(now)
+				for _, msg := range exhausted {
+					h.report(ctx, sess, msg, NackStatus)
+				}
+				for _, msg := range msgs {
+					h.logger.WithField("username", sess.username).WithField("id", msg.ID).Debug("redelivering message")
+					if err := h.send(sess, msg); err != nil {
+						h.logger.WithField("error", err).Error("error while redelivering message to user")
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
 			}
 		}
-		h.logger.WithField("topics", s.Topics).Debug("message channel closed")
 	}(sub)
 	h.logger.
-		WithField("username", un).
+		WithField("username", sess.username).
 		WithField("topics", sub.Topics).
 		Info("message channel listeners created")
 }
 
+// deliver writes a hub message to user, messages are wrapped in a MessageFrame when user asks for frames
+// and are tracked until acknowledgement when user acknowledges messages.
+func (h *SockHub) deliver(sess *session, msg *hub.Message) error {
+	if sess.protocol == MQTTSubprotocol {
+		return h.deliverMQTT(sess, msg)
+	}
+	var b []byte
+	var err error
+	if sess.frames {
+		b, err = json.Marshal(newMessageFrame(msg))
+	} else {
+		b, err = encodeData(msg.Data)
+	}
+	if err != nil {
+		h.logger.WithField("channel", msg.Topic).WithError(err).Error("could not encode message data")
+		return nil
+	}
+	if sess.acks != nil {
+		sess.acks.delivered(msg, time.Now())
+	}
+	return sess.write(websocket.TextMessage, b)
+}
+
+// receive passes a hub message through outbound middlewares, the filter and the coalescer of user and sends it.
+func (h *SockHub) receive(ctx context.Context, sess *session, msg *hub.Message) error {
+	// Filters see data after middlewares, so redacted fields can't be used to select messages.
+	msg, ok := h.outbound(ctx, sess, msg)
+	if !ok {
+		return nil
+	}
+	if sess.filter != nil && !sess.filter.Match(msg.Topic, msg.Data) {
+		filteredMessages.Inc()
+		return nil
+	}
+	if sess.coalescer != nil && sess.coalescer.add(msg, time.Now()) {
+		return nil
+	}
+	return h.send(sess, msg)
+}
+
+// send delivers a message to user, or adds it to the batch of user and writes the batch when it's full.
+func (h *SockHub) send(sess *session, msg *hub.Message) error {
+	if sess.batch == nil {
+		return h.deliver(sess, msg)
+	}
+	if sess.batch.add(msg) {
+		return h.flush(sess)
+	}
+	return nil
+}
+
+// flush writes messages of the batch of user in a json array frame.
+func (h *SockHub) flush(sess *session) error {
+	msgs := sess.batch.take()
+	if len(msgs) == 0 {
+		return nil
+	}
+	b, err := encodeBatch(msgs, sess.frames)
+	if err != nil {
+		h.logger.WithField("username", sess.username).WithError(err).Error("could not encode batch")
+		return nil
+	}
+	if sess.acks != nil {
+		now := time.Now()
+		for _, msg := range msgs {
+			sess.acks.delivered(msg, now)
+		}
+	}
+	return sess.write(websocket.TextMessage, b)
+}
+
+// parseBatch creates the batch of a connection from batch_ms and batch_size parameters.
+func (h *SockHub) parseBatch(ms, size string) (*batch, error) {
+	n, err := strconv.Atoi(ms)
+	if err != nil || n <= 0 || time.Duration(n)*time.Millisecond > h.Config.MaxBatchInterval {
+		return nil, fmt.Errorf("batch_ms must be between 1 and %d", h.Config.MaxBatchInterval.Milliseconds())
+	}
+	s := h.Config.MaxBatchSize
+	if size != "" {
+		if s, err = strconv.Atoi(size); err != nil || s <= 0 || s > h.Config.MaxBatchSize {
+			return nil, fmt.Errorf("batch_size must be between 1 and %d", h.Config.MaxBatchSize)
+		}
+	}
+	return newBatch(s, time.Duration(n)*time.Millisecond), nil
+}
+
+// report publishes an AckReport to the ack topic, reports of tenants are published to the ack topic of
+// their namespace.
+func (h *SockHub) report(ctx context.Context, sess *session, msg *hub.Message, status string) {
+	if h.Config.AckTopic == "" {
+		return
+	}
+	// reports are encoded here so they can be published with any hub codec.
+	b, _ := json.Marshal(&AckReport{
+		ID:       msg.ID,
+		Topic:    msg.Topic,
+		Username: sess.username,
+		Status:   status,
+	})
+	if err := h.Hub.Publish(ctx, sess.tenant.Topic(h.Config.AckTopic), json.RawMessage(b)); err != nil {
+		h.logger.
+			WithField("username", sess.username).
+			WithField("id", msg.ID).
+			WithError(err).
+			Error("could not publish ack report")
+	}
+}
+
+// encodeData converts data of a hub message to the payload of a websocket frame, bytes and
+// strings are written as they are and other values are encoded to json.
+func encodeData(data interface{}) ([]byte, error) {
+	switch d := data.(type) {
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	default:
+		return json.Marshal(d)
+	}
+}
+
 // reader reads user messages and then publishes them to specified topic.
-func (h *SockHub) reader(ctx context.Context, username string, conn *websocket.Conn) {
+func (h *SockHub) reader(ctx context.Context, sess *session) {
 	// read user sent messages
 	for {
-		mt, message, err := conn.ReadMessage()
+		mt, message, err := sess.conn.ReadMessage()
 		if err != nil {
 			break
 		}
 		cm := &ClientMessage{}
 		if err = json.Unmarshal(message, cm); err != nil {
 			h.logger.
-				WithField("username", username).
+				WithField("username", sess.username).
 				WithField("type", mt).
 				WithField("payload", cm).
 				Info("invalid error from usre")
 			continue
 		}
 		h.logger.
-			WithField("username", username).
+			WithField("username", sess.username).
 			WithField("type", mt).
 			WithField("payload", cm).
 			Info("message received from user")
-		// TODO authorize user access to the topic.
 
-		err = h.Hub.Publish(ctx, cm.Topic, cm.Topic)
-		if err != nil {
-			h.logger.WithField("username", username).
-				WithField("type", mt).
-				WithField("payload", cm).
-				WithField("topic", cm.Topic).
-				WithError(err).
-				Error("could not publish message to hub")
+		switch cm.Type {
+		case AckMessage, NackMessage:
+			h.acknowledge(ctx, sess, cm)
+		case "", PublishMessage:
+			h.publish(ctx, sess, cm)
+		case RequestMessage:
+			h.request(ctx, sess, cm)
+		default:
+			h.logger.WithField("username", sess.username).WithField("type", cm.Type).Info("invalid message type from user")
+		}
+	}
+}
+
+// publish publishes body of a user message to its topic after the inbound chain, messages that are rejected
+// by middlewares, don't match the schema of the topic or fail in the hub are reported with an ErrorFrame.
+// It returns true when the message is accepted by the hub.
+func (h *SockHub) publish(ctx context.Context, sess *session, cm *ClientMessage) bool {
+	// TODO authorize user access to the topic.
+	e, err := h.inbound(ctx, sess, cm)
+	if err != nil {
+		h.reject(sess, &ErrorFrame{Type: ErrorMessage, ID: cm.ID, Topic: cm.Topic, Error: err.Error()})
+		return false
+	}
+	// An empty body of a retained message removes the retained message of the topic, so it's not validated.
+	remove := cm.Retain && cm.Body == ""
+	if !remove && !h.validate(sess, cm, e) {
+		return false
+	}
+	if cm.Retain {
+		return h.retain(ctx, sess, cm, e, remove)
+	}
+	err = h.Hub.Publish(ctx, sess.tenant.Topic(e.Topic), e.Data)
+	if err != nil {
+		h.logger.WithField("username", sess.username).
+			WithField("payload", cm).
+			WithField("topic", e.Topic).
+			WithError(err).
+			Error("could not publish message to hub")
+		h.reject(sess, &ErrorFrame{Type: ErrorMessage, ID: cm.ID, Topic: cm.Topic, Error: "message could not be published"})
+		return false
+	}
+	return true
+}
+
+// validate checks data of a user message against the schema of its topic, messages that don't match the
+// schema are reported with an ErrorFrame. It returns false when the message must not be sent to the hub.
+func (h *SockHub) validate(sess *session, cm *ClientMessage, e *Envelope) bool {
+	if h.Schemas == nil {
+		return true
+	}
+	body, err := encodeData(e.Data)
+	if err == nil {
+		err = h.Schemas.Validate(e.Topic, body)
+	}
+	if ve, ok := err.(*schema.ValidationError); ok {
+		h.logger.WithField("username", sess.username).WithField("topic", e.Topic).Info("message rejected by schema")
+		h.reject(sess, &ErrorFrame{Type: ErrorMessage, ID: cm.ID, Topic: cm.Topic, Error: ve.Error(), Details: ve.Failures})
+		return false
+	}
+	if err != nil {
+		h.logger.WithField("topic", e.Topic).WithError(err).Error("could not validate message")
+		h.reject(sess, &ErrorFrame{Type: ErrorMessage, ID: cm.ID, Topic: cm.Topic, Error: "message could not be validated"})
+		return false
+	}
+	return true
+}
+
+// retain publishes a retained message or removes the retained message of the topic, it's rejected with an
+// ErrorFrame when the hub doesn't retain messages or fails.
+func (h *SockHub) retain(ctx context.Context, sess *session, cm *ClientMessage, e *Envelope, remove bool) bool {
+	err := hub.ErrNotRetained
+	if rh, ok := h.Hub.(hub.RetainHub); ok {
+		data := e.Data
+		if remove {
+			data = nil
 		}
+		err = rh.PublishRetained(ctx, sess.tenant.Topic(e.Topic), data)
+	}
+	if err == hub.ErrNotRetained {
+		h.reject(sess, &ErrorFrame{Type: ErrorMessage, ID: cm.ID, Topic: cm.Topic, Error: err.Error()})
+		return false
+	}
+	if err != nil {
+		h.logger.WithField("username", sess.username).
+			WithField("topic", e.Topic).
+			WithError(err).
+			Error("could not publish retained message to hub")
+		h.reject(sess, &ErrorFrame{Type: ErrorMessage, ID: cm.ID, Topic: cm.Topic, Error: "message could not be published"})
+		return false
+	}
+	return true
+}
+
+// inbound checks a user message against limits of the tenant of user and passes it through the inbound chain.
+func (h *SockHub) inbound(ctx context.Context, sess *session, cm *ClientMessage) (*Envelope, error) {
+	if err := sess.tenant.Allow(len(cm.Body)); err != nil {
+		h.logger.WithField("username", sess.username).WithField("tenant", sess.tenant.Name).WithError(err).Info("message rejected by limits of tenant")
+		return nil, err
+	}
+	e := &Envelope{Direction: Inbound, Username: sess.username, Topic: cm.Topic, Data: cm.Body}
+	if err := h.Inbound.Process(ctx, e); err != nil {
+		rejectedMessages.WithLabelValues(Inbound, sess.tenant.Label()).Inc()
+		h.logger.WithField("username", sess.username).WithField("topic", cm.Topic).WithError(err).Info("message rejected by middlewares")
+		return nil, err
+	}
+	return e, nil
+}
+
+// outbound passes a hub message through the outbound chain and returns a copy of the message with the
+// processed topic and data, ok is false when the message is rejected. Topics of tenants are delivered
+// without their namespace.
+func (h *SockHub) outbound(ctx context.Context, sess *session, msg *hub.Message) (*hub.Message, bool) {
+	if sess.tenant != nil {
+		m := *msg
+		m.Topic = sess.tenant.Strip(msg.Topic)
+		msg = &m
+	}
+	if len(h.Outbound) == 0 {
+		return msg, true
+	}
+	e := &Envelope{Direction: Outbound, Username: sess.username, ID: msg.ID, Topic: msg.Topic, Data: msg.Data}
+	if err := h.Outbound.Process(ctx, e); err != nil {
+		rejectedMessages.WithLabelValues(Outbound, sess.tenant.Label()).Inc()
+		h.logger.WithField("username", sess.username).WithField("topic", msg.Topic).WithError(err).Info("message rejected by middlewares")
+		return nil, false
+	}
+	m := *msg
+	m.Topic, m.Data = e.Topic, e.Data
+	return &m, true
+}
+
+// reject reports a rejected user message with an ErrorFrame, STOMP users receive an ERROR frame and it's
+// logged for MQTT users.
+func (h *SockHub) reject(sess *session, ef *ErrorFrame) {
+	switch sess.protocol {
+	case MQTTSubprotocol:
+		h.logger.WithField("username", sess.username).WithField("topic", ef.Topic).WithField("error", ef.Error).Info("mqtt publish rejected")
+	case StompSubprotocol:
+		h.stompReject(sess, ef)
+	default:
+		h.writeFrame(sess, ef)
+	}
+}
+
+// writeFrame writes a json frame to the user.
+func (h *SockHub) writeFrame(sess *session, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		h.logger.WithField("username", sess.username).WithError(err).Error("could not encode frame")
+		return
+	}
+	if err := sess.write(websocket.TextMessage, b); err != nil {
+		h.logger.WithField("username", sess.username).WithError(err).Error("error while sending frame to user")
+	}
+}
+
+// acknowledge stops tracking of an acknowledged message and reports it.
+func (h *SockHub) acknowledge(ctx context.Context, sess *session, cm *ClientMessage) {
+	if sess.acks == nil {
+		h.logger.WithField("username", sess.username).Info("acknowledgement from user that doesn't acknowledge messages")
+		return
+	}
+	msg, ok := sess.acks.remove(cm.ID)
+	if !ok {
+		h.logger.WithField("username", sess.username).WithField("id", cm.ID).Debug("acknowledgement of unknown message")
+		return
 	}
+	h.report(ctx, sess, msg, cm.Type)
 }
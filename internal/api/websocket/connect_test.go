@@ -0,0 +1,524 @@
+package websocket
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/internal/api/schema"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/tenant"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer serves a SockHub over a redis hub that is backed by miniredis.
+type testServer struct {
+	*httptest.Server
+	sh    *SockHub
+	hub   *hub.RedisHub
+	redis *miniredis.Miniredis
+}
+
+func newTestServer(t testing.TB, c Configuration) *testServer {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	rh := hub.NewRedisHub(redis.NewClient(&redis.Options{Addr: s.Addr()}), l, nil)
+	if c.PingInterval == 0 {
+		c.PingInterval, c.PongWait, c.WriteWait, c.ReadLimit = time.Minute, time.Minute, time.Second, 4096
+	}
+	sh := NewSockHub(c, rh, l)
+	ts := &testServer{Server: httptest.NewServer(http.HandlerFunc(sh.Connect)), sh: sh, hub: rh, redis: s}
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return ts
+}
+
+// retainingHub publishes live to topics while their retained messages are read, so tests can check that
+// retained messages are sent before live ones.
+type retainingHub struct {
+	*hub.RedisHub
+	live interface{}
+}
+
+func (r *retainingHub) Retained(ctx context.Context, topics ...string) ([]*hub.Message, error) {
+	msgs, err := r.RedisHub.Retained(ctx, topics...)
+	for _, t := range topics {
+		_ = r.RedisHub.Publish(ctx, t, r.live)
+	}
+	time.Sleep(50 * time.Millisecond)
+	return msgs, err
+}
+
+// dial connects to the server and waits until pub/sub subscriptions of the connection to topics are created.
+func (ts *testServer) dial(t testing.TB, d *websocket.Dialer, query string, topics ...string) *websocket.Conn {
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?" + query
+	// Shared subscriptions of topics may exist before the connection, so its connection count is waited for too.
+	shared := make(map[string]int)
+	if ts.sh.shared != nil {
+		for _, topic := range topics {
+			shared[topic] = ts.sh.shared.subscribers(topic)
+		}
+	}
+	conn, _, err := d.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	for i := 0; i < 100; i++ {
+		subscribed := true
+		for topic, n := range ts.redis.PubSubNumSub(topics...) {
+			subscribed = subscribed && n > 0
+			if ts.sh.shared != nil {
+				subscribed = subscribed && ts.sh.shared.subscribers(topic) > shared[topic]
+			}
+		}
+		if subscribed {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("subscriptions are not created")
+	return nil
+}
+
+func TestSockHub_Compression(t *testing.T) {
+	ts := newTestServer(t, Configuration{EnableCompression: true, CompressionLevel: 9, CompressionThreshold: 64})
+	conn := ts.dial(t, &websocket.Dialer{EnableCompression: true}, "username=john&topics=news", "news")
+	ctx := context.Background()
+
+	payload := testutil.ToFloat64(payloadBytes.WithLabelValues("true"))
+	wire := testutil.ToFloat64(wireBytes.WithLabelValues("true"))
+	big := strings.Repeat(`{"status":"delivered","order":"12345"}`, 50)
+	assert.NoError(t, ts.hub.Publish(ctx, "news", big))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, big, string(b))
+	assert.Equal(t, float64(len(big)), testutil.ToFloat64(payloadBytes.WithLabelValues("true"))-payload)
+	assert.Less(t, testutil.ToFloat64(wireBytes.WithLabelValues("true"))-wire, float64(len(big))/4)
+
+	// Messages smaller than the threshold are not compressed.
+	payload = testutil.ToFloat64(payloadBytes.WithLabelValues("false"))
+	assert.NoError(t, ts.hub.Publish(ctx, "news", "small"))
+	_, b, err = conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "small", string(b))
+	assert.Equal(t, float64(len("small")), testutil.ToFloat64(payloadBytes.WithLabelValues("false"))-payload)
+}
+
+func TestOffersDeflate(t *testing.T) {
+	r := httptest.NewRequest("GET", "/socket/connect", nil)
+	assert.False(t, offersDeflate(r))
+	r.Header.Set("Sec-WebSocket-Extensions", "x-webkit-deflate-frame, permessage-deflate; client_max_window_bits")
+	assert.True(t, offersDeflate(r))
+}
+
+func TestSockHub_QueueGroup(t *testing.T) {
+	ts := newTestServer(t, Configuration{QueueGroups: map[string]string{"workers": "secret"}})
+	ts.hub.Config.StreamMaxLen = 100
+
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?username=worker&topics=commands&group=workers&group_token=secret&ack=true"
+	_, resp, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Groups are joined only with their token.
+	for _, q := range []string{"group=workers", "group=workers&group_token=invalid", "group=admins&group_token=secret"} {
+		_, resp, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/?username=worker&topics=commands&"+q, nil)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, q)
+	}
+
+	conn := ts.dial(t, websocket.DefaultDialer, "username=worker&topics=commands&group=workers&group_token=secret")
+	for i := 0; i < 100 && !ts.redis.Exists("commands"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, ts.hub.Publish(context.Background(), "commands", "refresh"))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh", string(b))
+}
+
+func TestSockHub_Request(t *testing.T) {
+	ts := newTestServer(t, Configuration{RequestTimeout: 200 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := ts.hub.Subscribe(ctx, "rpc.unread")
+	assert.NoError(t, err)
+	go func() {
+		msg := <-sub.MessageChannel
+		_ = ts.hub.Publish(ctx, msg.Reply, 3)
+	}()
+
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=news", "news")
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: RequestMessage, ID: "1", Topic: "rpc.unread", Body: "john"}))
+	rf := &ReplyFrame{}
+	assert.NoError(t, conn.ReadJSON(rf))
+	assert.Equal(t, &ReplyFrame{Type: ReplyMessage, ID: "1", Topic: "rpc.unread", Data: float64(3)}, rf)
+
+	// Requests without responders are answered with an error after request timeout.
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: RequestMessage, ID: "2", Topic: "rpc.missing"}))
+	rf = &ReplyFrame{}
+	assert.NoError(t, conn.ReadJSON(rf))
+	assert.Equal(t, "2", rf.ID)
+	assert.NotEmpty(t, rf.Error)
+}
+
+func TestSockHub_MaxPendingRequests(t *testing.T) {
+	ts := newTestServer(t, Configuration{RequestTimeout: 200 * time.Millisecond, MaxPendingRequests: 1})
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=news", "news")
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	// The second request is rejected while the first one waits for its reply.
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: RequestMessage, ID: "1", Topic: "rpc.missing"}))
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: RequestMessage, ID: "2", Topic: "rpc.missing"}))
+	rf := &ReplyFrame{}
+	assert.NoError(t, conn.ReadJSON(rf))
+	assert.Equal(t, &ReplyFrame{Type: ReplyMessage, ID: "2", Topic: "rpc.missing", Error: "too many pending requests"}, rf)
+	rf = &ReplyFrame{}
+	assert.NoError(t, conn.ReadJSON(rf))
+	assert.Equal(t, "1", rf.ID)
+
+	// Slots are released after replies.
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: RequestMessage, ID: "3", Topic: "rpc.missing"}))
+	rf = &ReplyFrame{}
+	assert.NoError(t, conn.ReadJSON(rf))
+	assert.Equal(t, &ReplyFrame{Type: ReplyMessage, ID: "3", Topic: "rpc.missing", Error: "no reply is received"}, rf)
+}
+
+func TestSockHub_RequestOutbound(t *testing.T) {
+	ts := newTestServer(t, Configuration{RequestTimeout: 200 * time.Millisecond})
+	ts.sh.Outbound = Chain{Redact("token")}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := ts.hub.Subscribe(ctx, "rpc.login")
+	assert.NoError(t, err)
+	go func() {
+		msg := <-sub.MessageChannel
+		_ = ts.hub.Publish(ctx, msg.Reply, `{"token": "secret"}`)
+	}()
+
+	// Replies pass through the outbound chain like messages of subscriptions.
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=news", "news")
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: RequestMessage, ID: "1", Topic: "rpc.login", Body: "john"}))
+	rf := &ReplyFrame{}
+	assert.NoError(t, conn.ReadJSON(rf))
+	assert.Equal(t, "1", rf.ID)
+	assert.JSONEq(t, `{"token": "[REDACTED]"}`, rf.Data.(string))
+}
+
+func TestSockHub_Schemas(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	sr, err := schema.NewRegistry(schema.Configuration{Enabled: true, Dir: t.TempDir()}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, sr.Set("orders.*", []byte(`{"type": "object", "required": ["amount"], "properties": {"amount": {"type": "number"}}}`)))
+	ts.sh.Schemas = sr
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := ts.hub.Subscribe(ctx, "orders.created")
+	assert.NoError(t, err)
+
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=news", "news")
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: PublishMessage, ID: "1", Topic: "orders.created", Body: `{"amount": "ten"}`}))
+	ef := &ErrorFrame{}
+	assert.NoError(t, conn.ReadJSON(ef))
+	assert.Equal(t, ErrorMessage, ef.Type)
+	assert.Equal(t, "1", ef.ID)
+	assert.NotEmpty(t, ef.Details)
+
+	// Requests are validated too.
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: RequestMessage, ID: "2", Topic: "orders.refund", Body: `{}`}))
+	ef = &ErrorFrame{}
+	assert.NoError(t, conn.ReadJSON(ef))
+	assert.Equal(t, ErrorMessage, ef.Type)
+	assert.Equal(t, "2", ef.ID)
+	assert.NotEmpty(t, ef.Details)
+
+	// Requests that cannot be validated are answered with an error too, so the user doesn't wait for a reply.
+	ts.sh.Inbound = Chain{MiddlewareFunc(func(ctx context.Context, e *Envelope) error {
+		if e.Topic == "orders.broken" {
+			e.Data = make(chan int)
+		}
+		return nil
+	})}
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: RequestMessage, ID: "3", Topic: "orders.broken", Body: `{}`}))
+	ef = &ErrorFrame{}
+	assert.NoError(t, conn.ReadJSON(ef))
+	assert.Equal(t, &ErrorFrame{Type: ErrorMessage, ID: "3", Topic: "orders.broken", Error: "message could not be validated"}, ef)
+
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: PublishMessage, ID: "3", Topic: "orders.created", Body: `{"amount": 10}`}))
+	select {
+	case msg := <-sub.MessageChannel:
+		assert.Equal(t, `{"amount": 10}`, msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("valid publish is not received")
+	}
+}
+
+func TestSockHub_Filter(t *testing.T) {
+	ts := newTestServer(t, Configuration{FilterMaxNodes: 8})
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?username=john&topics=orders&filter="
+	for _, expr := range []string{`region == "eu"`, `data.a && data.b && data.c && data.d && data.e`} {
+		_, resp, err := websocket.DefaultDialer.Dial(u+url.QueryEscape(expr), nil)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=orders&filter="+url.QueryEscape(`data.region == "eu"`), "orders")
+	ctx := context.Background()
+	filtered := testutil.ToFloat64(filteredMessages)
+	assert.NoError(t, ts.hub.Publish(ctx, "orders", map[string]string{"region": "us"}))
+	assert.NoError(t, ts.hub.Publish(ctx, "orders", map[string]string{"region": "eu"}))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"region": "eu"}`, string(b))
+	assert.Equal(t, float64(1), testutil.ToFloat64(filteredMessages)-filtered)
+}
+
+func TestSockHub_Middlewares(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	ts.sh.Inbound = Chain{MaxSize(64), Redact("password")}
+	ts.sh.Outbound = Chain{Redact("token")}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := ts.hub.Subscribe(ctx, "logins")
+	assert.NoError(t, err)
+
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=sessions", "sessions")
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: PublishMessage, Topic: "logins", Body: `{"user":"john","password":"123"}`}))
+	select {
+	case msg := <-sub.MessageChannel:
+		assert.JSONEq(t, `{"user":"john","password":"[REDACTED]"}`, msg.Data.(string))
+	case <-time.After(time.Second):
+		t.Fatal("message is not published")
+	}
+
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Type: PublishMessage, ID: "2", Topic: "logins", Body: strings.Repeat("a", 65)}))
+	ef := &ErrorFrame{}
+	assert.NoError(t, conn.ReadJSON(ef))
+	assert.Equal(t, &ErrorFrame{Type: ErrorMessage, ID: "2", Topic: "logins", Error: "message is larger than 64 bytes"}, ef)
+
+	assert.NoError(t, ts.hub.Publish(ctx, "sessions", map[string]string{"user": "john", "token": "secret"}))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"user":"john","token":"[REDACTED]"}`, string(b))
+}
+
+func TestSockHub_SharedSubscriptions(t *testing.T) {
+	for _, shared := range []bool{true, false} {
+		ts := newTestServer(t, Configuration{SharedSubscriptions: shared})
+		var conns []*websocket.Conn
+		for _, un := range []string{"john", "jane", "jack"} {
+			conns = append(conns, ts.dial(t, websocket.DefaultDialer, "username="+un+"&topics=news", "news"))
+		}
+		subs := 3
+		if shared {
+			subs = 1
+		}
+		for i := 0; i < 100 && ts.redis.PubSubNumSub("news")["news"] < subs; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, subs, ts.redis.PubSubNumSub("news")["news"])
+
+		assert.NoError(t, ts.hub.Publish(context.Background(), "news", "hello"))
+		for _, conn := range conns {
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, b, err := conn.ReadMessage()
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(b))
+		}
+
+		// The shared subscription is closed after its last connection.
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		for i := 0; i < 100 && ts.redis.PubSubNumSub("news")["news"] > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, 0, ts.redis.PubSubNumSub("news")["news"])
+	}
+}
+
+// BenchmarkSockHub_Connections opens b.N connections to 10 topics and reports goroutines and heap of each
+// connection and number of hub connections.
+func BenchmarkSockHub_Connections(b *testing.B) {
+	for _, shared := range []bool{true, false} {
+		name := "dedicated"
+		if shared {
+			name = "shared"
+		}
+		b.Run(name, func(b *testing.B) {
+			ts := newTestServer(b, Configuration{SharedSubscriptions: shared})
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			goroutines := runtime.NumGoroutine()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				topic := "bench." + strconv.Itoa(i%10)
+				ts.dial(b, websocket.DefaultDialer, "username=bench&topics="+topic, topic)
+			}
+			b.StopTimer()
+
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/float64(b.N), "goroutines/conn")
+			b.ReportMetric(float64(int64(after.HeapInuse)-int64(before.HeapInuse))/float64(b.N), "heap-bytes/conn")
+			b.ReportMetric(float64(ts.redis.CurrentConnectionCount()), "hub-conns")
+		})
+	}
+}
+
+func TestSockHub_Batch(t *testing.T) {
+	ts := newTestServer(t, Configuration{MaxBatchSize: 3, MaxBatchInterval: time.Second})
+	for _, q := range []string{"batch_ms=0", "batch_ms=2000", "batch_ms=10&batch_size=4"} {
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/?username=john&topics=ticks&"+q, nil)
+		assert.Error(t, err, q)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, q)
+	}
+
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=ticks&batch_ms=200", "ticks")
+	ctx := context.Background()
+	// A full batch is written before its interval.
+	for i := 1; i <= 4; i++ {
+		assert.NoError(t, ts.hub.Publish(ctx, "ticks", map[string]int{"seq": i}))
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"seq":1},{"seq":2},{"seq":3}]`, string(b))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err = conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"seq":4}]`, string(b))
+}
+
+func TestSockHub_Coalesce(t *testing.T) {
+	ts := newTestServer(t, Configuration{CoalesceTopics: map[string]time.Duration{"prices.*": 100 * time.Millisecond}})
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=prices.btc,news", "prices.btc", "news")
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, ts.hub.Publish(ctx, "prices.btc", i))
+	}
+	assert.NoError(t, ts.hub.Publish(ctx, "news", "not coalesced"))
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "not coalesced", string(b))
+	_, b, err = conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "5", string(b))
+}
+
+func TestSockHub_Retained(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	ctx := context.Background()
+	assert.NoError(t, ts.hub.PublishRetained(ctx, "status.device1", map[string]string{"state": "online"}))
+
+	// Retained messages are sent after the pattern subscription is created.
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=status.*&frames=true")
+	mf := &MessageFrame{}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, conn.ReadJSON(mf))
+	assert.Equal(t, &MessageFrame{Topic: "status.device1", Data: map[string]interface{}{"state": "online"}, Retained: true}, mf)
+
+	// Users retain messages with the retain flag and remove them with an empty body.
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Topic: "status.device2", Body: "offline", Retain: true}))
+	mf = &MessageFrame{}
+	assert.NoError(t, conn.ReadJSON(mf))
+	assert.Equal(t, &MessageFrame{Topic: "status.device2", Data: "offline"}, mf)
+	msgs, err := ts.hub.Retained(ctx, "status.device2")
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	assert.NoError(t, conn.WriteJSON(&ClientMessage{Topic: "status.device2", Retain: true}))
+	for i := 0; i < 100 && len(msgs) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		msgs, _ = ts.hub.Retained(ctx, "status.device2")
+	}
+	assert.Empty(t, msgs)
+}
+
+func TestSockHub_ReportCodec(t *testing.T) {
+	ts := newTestServer(t, Configuration{AckTopic: "acks"})
+	ts.hub.Config.Codec = hub.RawCodec{}
+	ctx := context.Background()
+	reports, err := ts.hub.Subscribe(ctx, "acks")
+	assert.NoError(t, err)
+
+	// Reports are published as json with codecs that cannot encode structs.
+	ts.sh.report(ctx, &session{username: "alice"}, &hub.Message{ID: "1-0", Topic: "jobs"}, AckStatus)
+	select {
+	case msg := <-reports.MessageChannel:
+		assert.Equal(t, []byte(`{"id":"1-0","topic":"jobs","username":"alice","status":"ack"}`), msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("ack report is not published")
+	}
+}
+
+func TestSockHub_Tenants(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	acme := &tenant.Tenant{Name: "acme", Tokens: []string{"acme-token"}, MaxConnections: 1, MaxMessageSize: 16}
+	globex := &tenant.Tenant{Name: "globex", Tokens: []string{"globex-token"}}
+	tr, err := tenant.NewRegistry(acme, globex)
+	assert.NoError(t, err)
+	ts.sh.Tenants = tr
+
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?username=ann&topics=orders"
+	_, resp, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	ann := ts.dial(t, websocket.DefaultDialer, "username=ann&topics=orders&frames=true&token=acme-token", "acme.orders")
+	bob := ts.dial(t, websocket.DefaultDialer, "username=bob&topics=orders&frames=true&token=globex-token", "globex.orders")
+	_, resp, err = websocket.DefaultDialer.Dial(u+"&token=acme-token", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, float64(1), testutil.ToFloat64(activeConnections.WithLabelValues("acme")))
+	assert.Equal(t, "acme", ts.sh.Connections("ann")[0].Tenant)
+	assert.Equal(t, []string{"ann"}, ts.sh.Presence("acme.orders").Users)
+
+	// Topics are namespaced, so messages of a tenant are not delivered to other tenants.
+	assert.NoError(t, ann.WriteJSON(&ClientMessage{Topic: "orders", Body: "hi"}))
+	mf := &MessageFrame{}
+	_ = ann.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, ann.ReadJSON(mf))
+	assert.Equal(t, &MessageFrame{Topic: "orders", Data: "hi"}, mf)
+	assert.NoError(t, ts.hub.Publish(context.Background(), "globex.orders", "hello"))
+	mf = &MessageFrame{}
+	_ = bob.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, bob.ReadJSON(mf))
+	assert.Equal(t, &MessageFrame{Topic: "orders", Data: "hello"}, mf)
+
+	assert.NoError(t, ann.WriteJSON(&ClientMessage{ID: "1", Topic: "orders", Body: "larger than the limit"}))
+	ef := &ErrorFrame{}
+	assert.NoError(t, ann.ReadJSON(ef))
+	assert.Equal(t, &ErrorFrame{Type: ErrorMessage, ID: "1", Topic: "orders", Error: tenant.ErrSize.Error()}, ef)
+}
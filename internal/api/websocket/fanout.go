@@ -0,0 +1,181 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/sirupsen/logrus"
+)
+
+// resubscribeInterval is the wait between attempts to subscribe a shared subscription again.
+const resubscribeInterval = time.Second
+
+// fanout shares one hub subscription of each topic between connections of the instance, messages of a
+// topic are received once from the hub and are passed to channels of connections that subscribe to it.
+// Messages are shared between connections, so they must not be modified after they're received.
+type fanout struct {
+	hub        hub.Hub
+	logger     *logrus.Logger
+	bufferSize int
+
+	mu sync.RWMutex
+	// topics is index of topics to their shared subscription.
+	topics map[string]*topicSubscription
+}
+
+// topicSubscription is a hub subscription of a topic and channels of connections that subscribe to it,
+// the hub subscription is cancelled when the last connection unsubscribes.
+type topicSubscription struct {
+	topic  string
+	cancel context.CancelFunc
+	subs   map[chan *hub.Message]struct{}
+	// ready is closed when the hub subscription is created, err is the error of the hub subscription.
+	ready chan struct{}
+	err   error
+}
+
+func newFanout(h hub.Hub, bufferSize int, logger *logrus.Logger) *fanout {
+	return &fanout{
+		hub:        h,
+		logger:     logger,
+		bufferSize: bufferSize,
+		topics:     make(map[string]*topicSubscription),
+	}
+}
+
+// subscribe adds a connection to shared subscriptions of topics, subscriptions of topics that have no
+// connections are created in the hub. unsubscribe must be called when the connection is closed.
+func (f *fanout) subscribe(topics ...string) (sub *hub.Subscription, unsubscribe func(), err error) {
+	ch := make(chan *hub.Message, f.bufferSize)
+	joined := make([]*topicSubscription, 0, len(topics))
+	unsubscribe = func() {
+		for _, ts := range joined {
+			f.leave(ts, ch)
+		}
+	}
+	for _, t := range topics {
+		ts := f.join(t, ch)
+		joined = append(joined, ts)
+		<-ts.ready
+		if ts.err != nil {
+			unsubscribe()
+			return nil, nil, ts.err
+		}
+	}
+	return &hub.Subscription{Topics: strings.Join(topics, ","), MessageChannel: ch}, unsubscribe, nil
+}
+
+// join adds ch to the shared subscription of topic, the hub subscription is created by the first
+// connection and others wait for it.
+func (f *fanout) join(topic string, ch chan *hub.Message) *topicSubscription {
+	f.mu.Lock()
+	ts, ok := f.topics[topic]
+	if ok {
+		ts.subs[ch] = struct{}{}
+		f.mu.Unlock()
+		return ts
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ts = &topicSubscription{
+		topic:  topic,
+		cancel: cancel,
+		subs:   map[chan *hub.Message]struct{}{ch: {}},
+		ready:  make(chan struct{}),
+	}
+	f.topics[topic] = ts
+	f.mu.Unlock()
+
+	sub, err := f.hub.Subscribe(ctx, topic)
+	if err != nil {
+		f.logger.WithField("topic", topic).WithError(err).Error("could not create shared subscription")
+		f.mu.Lock()
+		if f.topics[topic] == ts {
+			delete(f.topics, topic)
+		}
+		f.mu.Unlock()
+		cancel()
+		ts.err = err
+		close(ts.ready)
+		return ts
+	}
+	sharedSubscriptions.Inc()
+	f.logger.WithField("topic", topic).Debug("shared subscription created")
+	close(ts.ready)
+	go f.dispatch(ctx, ts, sub)
+	return ts
+}
+
+// leave removes ch from the shared subscription, the hub subscription is cancelled when it has no channels.
+func (f *fanout) leave(ts *topicSubscription, ch chan *hub.Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(ts.subs, ch)
+	if len(ts.subs) > 0 {
+		return
+	}
+	if f.topics[ts.topic] == ts {
+		delete(f.topics, ts.topic)
+	}
+	ts.cancel()
+}
+
+// dispatch passes messages of a hub subscription to channels of its connections, messages of connections
+// whose channel is full are dropped so a slow connection doesn't block others. The topic is subscribed
+// again when the hub closes its channel, so connections keep receiving messages of the topic.
+func (f *fanout) dispatch(ctx context.Context, ts *topicSubscription, sub *hub.Subscription) {
+	defer sharedSubscriptions.Dec()
+	defer f.logger.WithField("topic", ts.topic).Debug("shared subscription closed")
+	for {
+		select {
+		case msg, ok := <-sub.MessageChannel:
+			if !ok {
+				f.logger.WithField("topic", ts.topic).Warn("hub closed channel of shared subscription, resubscribing")
+				if sub = f.resubscribe(ctx, ts.topic); sub == nil {
+					return
+				}
+				continue
+			}
+			f.mu.RLock()
+			for ch := range ts.subs {
+				select {
+				case ch <- msg:
+				default:
+					droppedMessages.Inc()
+					f.logger.WithField("topic", ts.topic).Warn("connection buffer is full, message is dropped")
+				}
+			}
+			f.mu.RUnlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resubscribe subscribes to topic in the hub until it succeeds, it returns nil when ctx is done.
+func (f *fanout) resubscribe(ctx context.Context, topic string) *hub.Subscription {
+	for {
+		sub, err := f.hub.Subscribe(ctx, topic)
+		if err == nil {
+			return sub
+		}
+		f.logger.WithField("topic", topic).WithError(err).Error("could not resubscribe shared subscription")
+		select {
+		case <-time.After(resubscribeInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// subscribers returns number of connections of topic.
+func (f *fanout) subscribers(topic string) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if ts, ok := f.topics[topic]; ok {
+		return len(ts.subs)
+	}
+	return 0
+}
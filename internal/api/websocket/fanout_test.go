@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingHub counts active subscriptions of a hub.
+type countingHub struct {
+	hub.Hub
+	active int64
+	err    error
+}
+
+func (c *countingHub) Subscribe(ctx context.Context, topics ...string) (*hub.Subscription, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	sub, err := c.Hub.Subscribe(ctx, topics...)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.active, 1)
+	go func() {
+		<-ctx.Done()
+		atomic.AddInt64(&c.active, -1)
+	}()
+	return sub, nil
+}
+
+func (c *countingHub) activeSubscriptions() int64 {
+	// Subscriptions are counted down in background after cancellation.
+	time.Sleep(20 * time.Millisecond)
+	return atomic.LoadInt64(&c.active)
+}
+
+func newTestFanout(bufferSize int) (*fanout, *countingHub) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	h := &countingHub{Hub: hub.NewMemoryHub(l, nil)}
+	return newFanout(h, bufferSize, l), h
+}
+
+func TestFanout(t *testing.T) {
+	f, h := newTestFanout(16)
+	ctx := context.Background()
+
+	s1, unsubscribe1, err := f.subscribe("news", "sports")
+	assert.NoError(t, err)
+	s2, unsubscribe2, err := f.subscribe("news")
+	assert.NoError(t, err)
+	assert.Equal(t, "news,sports", s1.Topics)
+	assert.Equal(t, int64(2), h.activeSubscriptions())
+	assert.Equal(t, 2, f.subscribers("news"))
+
+	assert.NoError(t, h.Publish(ctx, "news", "hello"))
+	for _, s := range []*hub.Subscription{s1, s2} {
+		select {
+		case msg := <-s.MessageChannel:
+			assert.Equal(t, "hello", msg.Data)
+			assert.Equal(t, "news", msg.Topic)
+		case <-time.After(time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+
+	unsubscribe1()
+	assert.Equal(t, int64(1), h.activeSubscriptions())
+	assert.Equal(t, 1, f.subscribers("news"))
+	assert.Equal(t, 0, f.subscribers("sports"))
+	unsubscribe2()
+	assert.Equal(t, int64(0), h.activeSubscriptions())
+	assert.Empty(t, f.topics)
+
+	// A topic is subscribed again after its last connection unsubscribes.
+	s3, unsubscribe3, err := f.subscribe("news")
+	assert.NoError(t, err)
+	defer unsubscribe3()
+	assert.Equal(t, int64(1), h.activeSubscriptions())
+	assert.NoError(t, h.Publish(ctx, "news", "again"))
+	select {
+	case msg := <-s3.MessageChannel:
+		assert.Equal(t, "again", msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("message is not received")
+	}
+}
+
+func TestFanout_Error(t *testing.T) {
+	f, h := newTestFanout(16)
+	h.err = errors.New("hub is down")
+	_, _, err := f.subscribe("news")
+	assert.EqualError(t, err, "hub is down")
+	assert.Empty(t, f.topics)
+
+	h.err = nil
+	_, unsubscribe, err := f.subscribe("news")
+	assert.NoError(t, err)
+	unsubscribe()
+}
+
+func TestFanout_SlowConnection(t *testing.T) {
+	f, h := newTestFanout(2)
+	slow, unsubscribeSlow, err := f.subscribe("news")
+	assert.NoError(t, err)
+	defer unsubscribeSlow()
+	fast, unsubscribeFast, err := f.subscribe("news")
+	assert.NoError(t, err)
+	defer unsubscribeFast()
+
+	dropped := testutil.ToFloat64(droppedMessages)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, h.Publish(context.Background(), "news", i))
+		select {
+		case msg := <-fast.MessageChannel:
+			assert.EqualValues(t, i, msg.Data)
+		case <-time.After(time.Second):
+			t.Fatal("slow connection blocks others")
+		}
+	}
+	assert.Len(t, slow.MessageChannel, 2)
+	assert.Equal(t, dropped+3, testutil.ToFloat64(droppedMessages))
+}
+
+// closingHub returns subscriptions whose channel is closed by tests, channels of subscriptions are sent to subs.
+type closingHub struct {
+	hub.Hub
+	subs chan chan *hub.Message
+}
+
+func (c *closingHub) Subscribe(ctx context.Context, topics ...string) (*hub.Subscription, error) {
+	ch := make(chan *hub.Message)
+	c.subs <- ch
+	return &hub.Subscription{MessageChannel: ch}, nil
+}
+
+func TestFanout_ClosedChannel(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	h := &closingHub{subs: make(chan chan *hub.Message, 2)}
+	f := newFanout(h, 2, l)
+	sub, unsubscribe, err := f.subscribe("news")
+	assert.NoError(t, err)
+	defer unsubscribe()
+
+	// Shared subscriptions whose channel is closed subscribe again and connections keep receiving messages.
+	close(<-h.subs)
+	var ch chan *hub.Message
+	select {
+	case ch = <-h.subs:
+	case <-time.After(time.Second):
+		t.Fatal("shared subscription is not subscribed again")
+	}
+	assert.Equal(t, 1, f.subscribers("news"))
+	ch <- &hub.Message{Topic: "news", Data: "hello"}
+	select {
+	case msg := <-sub.MessageChannel:
+		assert.Equal(t, "hello", msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("message is not received after resubscribing")
+	}
+}
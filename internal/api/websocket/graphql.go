@@ -0,0 +1,499 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/pkg/filter"
+	"github.com/mammadmodi/websub/pkg/graphql"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/tenant"
+)
+
+// GraphQLSubprotocol is the websocket subprotocol of graphql-ws(graphql-transport-ws) connections, Connect
+// serves connections that negotiate it.
+const GraphQLSubprotocol = "graphql-transport-ws"
+
+// Types of graphql-transport-ws messages.
+const (
+	graphqlConnectionInit = "connection_init"
+	graphqlConnectionAck  = "connection_ack"
+	graphqlPing           = "ping"
+	graphqlPong           = "pong"
+	graphqlSubscribe      = "subscribe"
+	graphqlNext           = "next"
+	graphqlError          = "error"
+	graphqlComplete       = "complete"
+)
+
+// Close codes of graphql-transport-ws connections.
+const (
+	graphqlBadRequest       = 4400
+	graphqlUnauthorized     = 4401
+	graphqlForbidden        = 4403
+	graphqlInitTimeout      = 4408
+	graphqlSubscriberExists = 4409
+	graphqlTooManyInits     = 4429
+)
+
+// graphqlMessage is structure of graphql-transport-ws messages that are received from user.
+type graphqlMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// graphqlServerMessage is structure of graphql-transport-ws messages that are sent to user.
+type graphqlServerMessage struct {
+	ID      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// graphqlSubscribePayload is the payload of subscribe messages.
+type graphqlSubscribePayload struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphqlErrorPayload is an error of an operation in error messages.
+type graphqlErrorPayload struct {
+	Message string `json:"message"`
+}
+
+// graphqlConn is state of a graphql-transport-ws connection that is not shared with websocket connections.
+type graphqlConn struct {
+	sess *session
+	id   string
+	// out receives messages of all operations of the connection, the writer writes them in next messages.
+	out chan *graphqlDelivery
+
+	mu  sync.Mutex
+	ops map[string]*graphqlOperation
+}
+
+// graphqlOperation is the hub subscription of a subscribe message, its field is selected from data of messages
+// of the topic that match the filter of its arguments.
+type graphqlOperation struct {
+	id     string
+	topic  string
+	field  *graphql.Field
+	filter *filter.Filter
+	ctx    context.Context
+	cancel func()
+}
+
+// graphqlDelivery is a message of an operation.
+type graphqlDelivery struct {
+	op  *graphqlOperation
+	msg *hub.Message
+}
+
+// graphql serves a graphql-transport-ws connection, subscribe messages subscribe to the topic of their root
+// field and hub messages are delivered as next messages.
+func (h *SockHub) graphql(w http.ResponseWriter, r *http.Request, t *tenant.Tenant) {
+	wsConn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.WithField("error", err).Error("graphql upgrade error")
+		return
+	}
+	defer func() {
+		if err := wsConn.Close(); err != nil {
+			h.logger.WithField("error", err).Error("error while closing graphql connection")
+		}
+	}()
+	compress := h.Config.EnableCompression && offersDeflate(r)
+	connections.WithLabelValues(strconv.FormatBool(compress), t.Label()).Inc()
+	wsConn.SetReadLimit(h.Config.ReadLimit)
+	sess := &session{
+		conn:                 wsConn,
+		tenant:               t,
+		protocol:             GraphQLSubprotocol,
+		compress:             compress,
+		compressionThreshold: h.Config.CompressionThreshold,
+		writeWait:            h.Config.WriteWait,
+	}
+
+	// Clients must initialise the connection in the init timeout, pings are answered before it.
+	timeout := h.Config.GraphQLInitTimeout
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	if err := wsConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		h.logger.WithField("error", err.Error()).Error("error while setting read deadline")
+		return
+	}
+	var init *graphqlMessage
+	for init == nil {
+		gm, err := h.readGraphQL(sess)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			h.closeGraphQL(sess, graphqlInitTimeout, "Connection initialisation timeout")
+			return
+		}
+		if err != nil {
+			return
+		}
+		switch gm.Type {
+		case graphqlConnectionInit:
+			init = gm
+		case graphqlPing:
+			h.writeFrame(sess, &graphqlServerMessage{Type: graphqlPong})
+		case graphqlSubscribe:
+			h.closeGraphQL(sess, graphqlUnauthorized, "Unauthorized")
+			return
+		default:
+			h.closeGraphQL(sess, graphqlBadRequest, "Invalid message received")
+			return
+		}
+	}
+	// TODO Authenticate user with the payload of connection_init.
+	var payload struct {
+		Username string `json:"username"`
+	}
+	if len(init.Payload) > 0 {
+		_ = json.Unmarshal(init.Payload, &payload)
+	}
+	sess.username = payload.Username
+	if sess.username == "" {
+		sess.username = r.URL.Query().Get("username")
+	}
+	if sess.username == "" {
+		h.closeGraphQL(sess, graphqlForbidden, "Forbidden")
+		return
+	}
+	h.writeFrame(sess, &graphqlServerMessage{Type: graphqlConnectionAck})
+	h.logger.WithField("username", sess.username).Info("graphql connection created for user")
+	defer h.logger.WithField("username", sess.username).Info("graphql connection closed")
+
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(h.Config.PongWait))
+	})
+	gc := &graphqlConn{
+		sess: sess,
+		id:   strconv.FormatUint(atomic.AddUint64(&h.connSeq, 1), 10),
+		out:  make(chan *graphqlDelivery, h.subscriptionBufferSize),
+		ops:  make(map[string]*graphqlOperation),
+	}
+	untrack := h.track(&ConnectionInfo{
+		ID:          gc.id,
+		Username:    sess.username,
+		Tenant:      t.Label(),
+		Topics:      []string{},
+		Protocol:    GraphQLSubprotocol,
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: time.Now(),
+	})
+	defer untrack()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer func() {
+		gc.mu.Lock()
+		defer gc.mu.Unlock()
+		for _, op := range gc.ops {
+			op.cancel()
+		}
+	}()
+	pingTicker := time.NewTicker(h.Config.PingInterval)
+	defer pingTicker.Stop()
+	go h.graphqlWriter(ctx, gc, pingTicker.C)
+	h.graphqlReader(ctx, gc)
+}
+
+// readGraphQL reads a graphql-transport-ws message, messages that are not valid close the connection.
+func (h *SockHub) readGraphQL(sess *session) (*graphqlMessage, error) {
+	_, b, err := sess.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	gm := &graphqlMessage{}
+	if err := json.Unmarshal(b, gm); err != nil || gm.Type == "" {
+		h.closeGraphQL(sess, graphqlBadRequest, "Invalid message received")
+		return nil, fmt.Errorf("invalid graphql message")
+	}
+	return gm, nil
+}
+
+// graphqlReader reads messages of a connection until it's closed.
+func (h *SockHub) graphqlReader(ctx context.Context, gc *graphqlConn) {
+	sess := gc.sess
+	for {
+		if err := sess.conn.SetReadDeadline(time.Now().Add(h.Config.PongWait)); err != nil {
+			h.logger.WithField("error", err.Error()).Error("error while setting read deadline")
+			return
+		}
+		gm, err := h.readGraphQL(sess)
+		if err != nil {
+			h.logger.WithField("username", sess.username).WithError(err).Debug("graphql connection is closed")
+			return
+		}
+		switch gm.Type {
+		case graphqlConnectionInit:
+			h.closeGraphQL(sess, graphqlTooManyInits, "Too many initialisation requests")
+			return
+		case graphqlPing:
+			h.writeFrame(sess, &graphqlServerMessage{Type: graphqlPong})
+		case graphqlPong:
+		case graphqlSubscribe:
+			if gm.ID == "" {
+				h.closeGraphQL(sess, graphqlBadRequest, "Invalid message received")
+				return
+			}
+			gc.mu.Lock()
+			_, exists := gc.ops[gm.ID]
+			gc.mu.Unlock()
+			if exists {
+				h.closeGraphQL(sess, graphqlSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", gm.ID))
+				return
+			}
+			if err := h.graphqlSubscribe(ctx, gc, gm); err != nil {
+				h.logger.WithField("username", sess.username).WithField("id", gm.ID).WithError(err).Info("graphql subscribe rejected")
+				h.writeFrame(sess, &graphqlServerMessage{ID: gm.ID, Type: graphqlError, Payload: []graphqlErrorPayload{{Message: err.Error()}}})
+			}
+		case graphqlComplete:
+			h.graphqlComplete(gc, gm.ID)
+		default:
+			h.closeGraphQL(sess, graphqlBadRequest, "Invalid message received")
+			return
+		}
+	}
+}
+
+// graphqlSubscribe subscribes to the topic of the root field of a subscription operation, arguments of the
+// field are equality filters on fields of message data(list arguments are in filters) and arguments whose
+// variables are not given are ignored. Retained messages of the topic are delivered first.
+func (h *SockHub) graphqlSubscribe(ctx context.Context, gc *graphqlConn, gm *graphqlMessage) error {
+	p := &graphqlSubscribePayload{}
+	if err := json.Unmarshal(gm.Payload, p); err != nil {
+		return fmt.Errorf("invalid subscribe payload, error: %s", err.Error())
+	}
+	op, err := graphql.Parse(p.Query, p.OperationName)
+	if err != nil {
+		return err
+	}
+	if op.Type != graphql.Subscription {
+		return fmt.Errorf("only subscription operations are supported")
+	}
+	if len(op.Fields) != 1 {
+		return fmt.Errorf("subscription operations must select one field")
+	}
+	field := op.Fields[0]
+	// TODO authorize user access to the topic.
+	topic := field.Name
+	if t, ok := h.Config.GraphQLTopics[field.Name]; ok {
+		topic = t
+	}
+	f, err := h.graphqlFilter(op, field, p.Variables)
+	if err != nil {
+		return err
+	}
+
+	gop := &graphqlOperation{id: gm.ID, topic: topic, field: field, filter: f}
+	gop.ctx, gop.cancel = context.WithCancel(ctx)
+	var sub *hub.Subscription
+	if h.shared != nil {
+		var unsubscribe func()
+		if sub, unsubscribe, err = h.shared.subscribe(gc.sess.tenant.Topic(topic)); err == nil {
+			cancel := gop.cancel
+			gop.cancel = func() {
+				unsubscribe()
+				cancel()
+			}
+		}
+	} else {
+		sub, err = h.Hub.Subscribe(gop.ctx, gc.sess.tenant.Topic(topic))
+	}
+	if err != nil {
+		gop.cancel()
+		return fmt.Errorf("error while subscribing to %s, error: %s", topic, err.Error())
+	}
+
+	gc.mu.Lock()
+	gc.ops[gop.id] = gop
+	gc.mu.Unlock()
+	h.graphqlTopics(gc)
+	go func() {
+		// retained messages are sent before live ones, so an older retained message doesn't follow a newer one.
+		for _, msg := range h.retained(gop.ctx, gc.sess, []string{topic}) {
+			select {
+			case gc.out <- &graphqlDelivery{op: gop, msg: msg}:
+			case <-gop.ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case msg := <-sub.MessageChannel:
+				select {
+				case gc.out <- &graphqlDelivery{op: gop, msg: msg}:
+				case <-gop.ctx.Done():
+					return
+				}
+			case <-gop.ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// graphqlFilter compiles arguments of a field to a filter, it returns nil when no argument has a value.
+func (h *SockHub) graphqlFilter(op *graphql.Operation, field *graphql.Field, variables map[string]interface{}) (*filter.Filter, error) {
+	names := make([]string, 0, len(field.Arguments))
+	for n := range field.Arguments {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var conds []string
+	for _, n := range names {
+		v, ok := op.Resolve(field.Arguments[n], variables)
+		if !ok {
+			continue
+		}
+		if _, ok := v.(map[string]interface{}); ok {
+			return nil, fmt.Errorf("object value of argument '%s' is not supported", n)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of argument '%s', error: %s", n, err.Error())
+		}
+		if _, ok := v.([]interface{}); ok {
+			conds = append(conds, fmt.Sprintf("%s.%s in %s", filter.DataField, n, b))
+		} else {
+			conds = append(conds, fmt.Sprintf("%s.%s == %s", filter.DataField, n, b))
+		}
+	}
+	if len(conds) == 0 {
+		return nil, nil
+	}
+	return filter.Compile(strings.Join(conds, " && "), filter.Limits{
+		MaxLength: h.Config.FilterMaxLength,
+		MaxNodes:  h.Config.FilterMaxNodes,
+		MaxDepth:  h.Config.FilterMaxDepth,
+	})
+}
+
+// graphqlComplete stops an operation that is completed by the client.
+func (h *SockHub) graphqlComplete(gc *graphqlConn, id string) {
+	gc.mu.Lock()
+	op, ok := gc.ops[id]
+	delete(gc.ops, id)
+	gc.mu.Unlock()
+	if !ok {
+		return
+	}
+	op.cancel()
+	h.graphqlTopics(gc)
+}
+
+// graphqlTopics updates topics of the connection in connections of the instance.
+func (h *SockHub) graphqlTopics(gc *graphqlConn) {
+	gc.mu.Lock()
+	topics := make([]string, 0, len(gc.ops))
+	for _, op := range gc.ops {
+		topics = append(topics, op.topic)
+	}
+	gc.mu.Unlock()
+	h.setTopics(gc.id, topics)
+}
+
+// graphqlWriter writes messages of operations in next messages and a ping message on each tick of ping.
+func (h *SockHub) graphqlWriter(ctx context.Context, gc *graphqlConn, ping <-chan time.Time) {
+	sess := gc.sess
+	for {
+		select {
+		case d := <-gc.out:
+			// Messages of completed operations may be buffered.
+			if d.op.ctx.Err() != nil {
+				continue
+			}
+			msg, ok := h.outbound(ctx, sess, d.msg)
+			if !ok {
+				continue
+			}
+			if d.op.filter != nil && !d.op.filter.Match(msg.Topic, msg.Data) {
+				filteredMessages.Inc()
+				continue
+			}
+			data, err := graphqlData(msg.Data)
+			if err != nil {
+				h.logger.WithField("channel", msg.Topic).WithError(err).Error("could not encode message data")
+				continue
+			}
+			b, err := json.Marshal(&graphqlServerMessage{ID: d.op.id, Type: graphqlNext, Payload: map[string]interface{}{
+				"data": map[string]interface{}{d.op.field.Key(): selectFields(data, d.op.field.Selections)},
+			}})
+			if err != nil {
+				h.logger.WithField("channel", msg.Topic).WithError(err).Error("could not encode next message")
+				continue
+			}
+			if err := sess.write(websocket.TextMessage, b); err != nil {
+				h.logger.WithField("error", err).Error("error while sending message to user")
+				return
+			}
+		case <-ping:
+			if err := sess.write(websocket.PingMessage, []byte{}); err != nil {
+				h.logger.WithField("error", err.Error()).Error("error while sending ping message")
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// graphqlData converts data of a hub message to a json value, data that is not json is a string.
+func graphqlData(data interface{}) (interface{}, error) {
+	b, err := encodeData(data)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return string(b), nil
+	}
+	return v, nil
+}
+
+// selectFields returns selected fields of a json value, lists are selected element by element and fields
+// that are missing are null.
+func selectFields(v interface{}, selections []*graphql.Field) interface{} {
+	if len(selections) == 0 {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(selections))
+		for _, s := range selections {
+			m[s.Key()] = selectFields(v[s.Name], s.Selections)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = selectFields(e, selections)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// closeGraphQL closes a connection with a close code of graphql-transport-ws.
+func (h *SockHub) closeGraphQL(sess *session, code int, reason string) {
+	h.logger.WithField("username", sess.username).WithField("code", code).WithField("reason", reason).Info("closing graphql connection")
+	if err := sess.write(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason)); err != nil {
+		h.logger.WithField("username", sess.username).WithError(err).Debug("could not write close message")
+	}
+}
@@ -0,0 +1,149 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/pkg/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialGraphQL(t *testing.T, ts *testServer) *websocket.Conn {
+	d := &websocket.Dialer{Subprotocols: []string{GraphQLSubprotocol}}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	assert.Equal(t, GraphQLSubprotocol, conn.Subprotocol())
+	return conn
+}
+
+// readGraphQL reads a message of a graphql connection as a generic json value.
+func readGraphQL(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	m := map[string]interface{}{}
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSockHub_GraphQL(t *testing.T) {
+	ts := newTestServer(t, Configuration{GraphQLTopics: map[string]string{"orderCreated": "orders.created"}, SharedSubscriptions: true})
+	ctx := context.Background()
+	conn := dialGraphQL(t, ts)
+
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "ping"}))
+	assert.Equal(t, map[string]interface{}{"type": "pong"}, readGraphQL(t, conn))
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "connection_init", "payload": map[string]string{"username": "ann"}}))
+	assert.Equal(t, map[string]interface{}{"type": "connection_ack"}, readGraphQL(t, conn))
+
+	// Arguments filter messages and selections pick fields of message data.
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{
+		"id":   "1",
+		"type": "subscribe",
+		"payload": map[string]interface{}{
+			"query":     `subscription OnOrder($region: String, $vip: Boolean) { order: orderCreated(region: $region, vip: $vip) { id region } }`,
+			"variables": map[string]interface{}{"region": "eu"},
+		},
+	}))
+	for i := 0; i < 100 && ts.sh.shared.subscribers("orders.created") == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"orders.created"}, ts.sh.Connections("ann")[0].Topics)
+	assert.NoError(t, ts.hub.Publish(ctx, "orders.created", map[string]interface{}{"id": 1, "region": "us"}))
+	assert.NoError(t, ts.hub.Publish(ctx, "orders.created", map[string]interface{}{"id": 2, "region": "eu", "amount": 7}))
+	assert.Equal(t, map[string]interface{}{
+		"id":      "1",
+		"type":    "next",
+		"payload": map[string]interface{}{"data": map[string]interface{}{"order": map[string]interface{}{"id": 2.0, "region": "eu"}}},
+	}, readGraphQL(t, conn))
+
+	// Operations that are not subscriptions are rejected with an error message.
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"id": "2", "type": "subscribe", "payload": map[string]interface{}{"query": "{ news }"}}))
+	assert.Equal(t, map[string]interface{}{
+		"id":      "2",
+		"type":    "error",
+		"payload": []interface{}{map[string]interface{}{"message": "only subscription operations are supported"}},
+	}, readGraphQL(t, conn))
+
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"id": "1", "type": "complete"}))
+	for i := 0; i < 100 && len(ts.sh.Connections("ann")[0].Topics) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Empty(t, ts.sh.Connections("ann")[0].Topics)
+
+	// Subscribers with the id of an active operation close the connection.
+	sub := map[string]interface{}{"id": "3", "type": "subscribe", "payload": map[string]interface{}{"query": "subscription { news }"}}
+	assert.NoError(t, conn.WriteJSON(sub))
+	assert.NoError(t, conn.WriteJSON(sub))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, graphqlSubscriberExists), err)
+}
+
+func TestSockHub_GraphQLInit(t *testing.T) {
+	ts := newTestServer(t, Configuration{GraphQLInitTimeout: 50 * time.Millisecond})
+	conn := dialGraphQL(t, ts)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, graphqlInitTimeout), err)
+
+	conn = dialGraphQL(t, ts)
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"id": "1", "type": "subscribe", "payload": map[string]interface{}{"query": "subscription { news }"}}))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, graphqlUnauthorized), err)
+
+	conn = dialGraphQL(t, ts)
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "connection_init"}))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, graphqlForbidden), err)
+}
+
+func TestSelectFields(t *testing.T) {
+	op, err := graphql.Parse(`subscription { orders { id customer { name } items { sku } } }`, "")
+	assert.NoError(t, err)
+	var data interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"id": 1, "customer": {"name": "ann", "email": "a@b.c"}, "items": [{"sku": "x", "qty": 1}]}`), &data))
+	assert.Equal(t, map[string]interface{}{
+		"id":       1.0,
+		"customer": map[string]interface{}{"name": "ann"},
+		"items":    []interface{}{map[string]interface{}{"sku": "x"}},
+	}, selectFields(data, op.Fields[0].Selections))
+	assert.Equal(t, "plain", selectFields("plain", op.Fields[0].Selections))
+}
+
+func TestSockHub_GraphQLFilter(t *testing.T) {
+	sh := NewSockHub(Configuration{}, nil, nil)
+	op, err := graphql.Parse(`subscription ($r: [String]) { orders(region: $r, status: PAID, level: 2) }`, "")
+	assert.NoError(t, err)
+	f, err := sh.graphqlFilter(op, op.Fields[0], map[string]interface{}{"r": []interface{}{"eu", "us"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `data.level == 2 && data.region in ["eu","us"] && data.status == "PAID"`, f.String())
+	f, err = sh.graphqlFilter(op, &graphql.Field{Name: "orders"}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+	_, err = sh.graphqlFilter(op, &graphql.Field{Name: "orders", Arguments: map[string]interface{}{"a": map[string]interface{}{}}}, nil)
+	assert.Error(t, err)
+}
+
+func TestSockHub_GraphQLRetained(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	ts.sh.Hub = &retainingHub{RedisHub: ts.hub, live: "new"}
+	assert.NoError(t, ts.hub.PublishRetained(context.Background(), "news", "old"))
+	conn := dialGraphQL(t, ts)
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "connection_init", "payload": map[string]string{"username": "ann"}}))
+	assert.Equal(t, map[string]interface{}{"type": "connection_ack"}, readGraphQL(t, conn))
+
+	// The retained message is sent before live messages that are published while it's read.
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"id": "1", "type": "subscribe", "payload": map[string]interface{}{"query": "subscription { news }"}}))
+	for _, data := range []string{"old", "new"} {
+		m := readGraphQL(t, conn)
+		assert.Equal(t, map[string]interface{}{"data": map[string]interface{}{"news": data}}, m["payload"])
+	}
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+var (
+	// connections counts websocket connections by whether compression is negotiated and their tenant.
+	connections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "connections_total",
+		Help:      "Number of websocket connections by whether permessage-deflate is negotiated.",
+	}, []string{"compression", "tenant"})
+	// activeConnections is number of open websocket connections of tenants.
+	activeConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "active_connections",
+		Help:      "Number of open websocket connections.",
+	}, []string{"tenant"})
+	// payloadBytes and wireBytes count size of written messages before and after compression,
+	// wire bytes include websocket frame headers.
+	payloadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "payload_bytes_total",
+		Help:      "Size of messages that are written to users before compression.",
+	}, []string{"compressed"})
+	wireBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "wire_bytes_total",
+		Help:      "Size of messages that are written to connections of users.",
+	}, []string{"compressed"})
+	// compressionRatio observes wire size to payload size of compressed messages.
+	compressionRatio = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "compression_ratio",
+		Help:      "Ratio of wire size to payload size of compressed messages.",
+		Buckets:   []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1, 1.2},
+	})
+	// rejectedMessages counts messages of tenants that are rejected by middlewares.
+	rejectedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "rejected_messages_total",
+		Help:      "Number of messages that are rejected by middlewares.",
+	}, []string{"direction", "tenant"})
+	// filteredMessages counts messages that are not written to users because of their subscription filters.
+	filteredMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "filtered_messages_total",
+		Help:      "Number of messages that are dropped by subscription filters.",
+	})
+	// coalescedMessages counts messages that are replaced by a newer message of their topic in a coalescing window.
+	coalescedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "coalesced_messages_total",
+		Help:      "Number of messages that are replaced by a newer message of their topic in a coalescing window.",
+	})
+	// sharedSubscriptions is number of hub subscriptions that are shared between connections.
+	sharedSubscriptions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "shared_subscriptions",
+		Help:      "Number of hub subscriptions that are shared between connections.",
+	})
+	// droppedMessages counts messages of shared subscriptions that are dropped because buffer of the connection is full.
+	droppedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "socket",
+		Name:      "dropped_messages_total",
+		Help:      "Number of messages that are dropped because buffer of the connection is full.",
+	})
+)
+
+// observeWrite records metrics of a message that is written to a connection.
+func observeWrite(compressed bool, payload, wire int64) {
+	l := strconv.FormatBool(compressed)
+	payloadBytes.WithLabelValues(l).Add(float64(payload))
+	wireBytes.WithLabelValues(l).Add(float64(wire))
+	if compressed && payload > 0 {
+		compressionRatio.Observe(float64(wire) / float64(payload))
+	}
+}
+
+// countingConn counts bytes that are written to a connection.
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Written() int64 {
+	return atomic.LoadInt64(&c.written)
+}
+
+// countingWriter hijacks connections as countingConn, it's passed to the upgrader to measure
+// wire size of messages.
+type countingWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (w *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	c, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &countingConn{Conn: c}
+	return w.conn, rw, nil
+}
@@ -0,0 +1,242 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Directions of messages that pass through middlewares.
+const (
+	// Inbound messages are published by users to the hub.
+	Inbound = "inbound"
+	// Outbound messages are received from the hub and are sent to users.
+	Outbound = "outbound"
+)
+
+// Names of built-in middlewares.
+const (
+	RedactMiddleware = "redact"
+	SizeMiddleware   = "size"
+)
+
+// RedactedValue replaces values of redacted fields.
+const RedactedValue = "[REDACTED]"
+
+// Envelope is a message that passes through a middleware chain, middlewares can change its topic and data.
+// Data of inbound messages is the body that user sent and data of outbound messages is the decoded data
+// of hub messages.
+type Envelope struct {
+	Direction string
+	Username  string
+	// ID is id of the hub message, it's empty for inbound messages.
+	ID    string
+	Topic string
+	Data  interface{}
+}
+
+// Middleware processes messages between users and the hub, e.g. for redaction, enrichment or auditing.
+// Returning an error rejects the message, rejected inbound messages are reported to the user with an
+// ErrorFrame and rejected outbound messages are dropped.
+type Middleware interface {
+	Process(ctx context.Context, e *Envelope) error
+}
+
+// MiddlewareFunc adapts a function to a Middleware.
+type MiddlewareFunc func(ctx context.Context, e *Envelope) error
+
+// Process calls f.
+func (f MiddlewareFunc) Process(ctx context.Context, e *Envelope) error {
+	return f(ctx, e)
+}
+
+// Chain runs middlewares in order until one of them rejects the message.
+type Chain []Middleware
+
+// Process passes the envelope through middlewares of the chain.
+func (c Chain) Process(ctx context.Context, e *Envelope) error {
+	for _, m := range c {
+		if err := m.Process(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MiddlewareFactory creates a middleware with SockHub configuration.
+type MiddlewareFactory func(config Configuration) (Middleware, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]MiddlewareFactory{
+		RedactMiddleware: func(c Configuration) (Middleware, error) {
+			if len(c.RedactFields) == 0 {
+				return nil, fmt.Errorf("redact fields cannot be empty")
+			}
+			return Redact(c.RedactFields...), nil
+		},
+		SizeMiddleware: func(c Configuration) (Middleware, error) {
+			if c.MaxMessageSize <= 0 {
+				return nil, fmt.Errorf("max message size must be positive")
+			}
+			return MaxSize(c.MaxMessageSize), nil
+		},
+	}
+)
+
+// RegisterMiddleware makes a custom middleware available to chains by name, so its order can be
+// configured like built-in middlewares.
+func RegisterMiddleware(name string, f MiddlewareFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = f
+}
+
+// NewChain creates a chain of registered middlewares in the order of names.
+func NewChain(config Configuration, names ...string) (Chain, error) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	var c Chain
+	for _, n := range names {
+		f, ok := factories[n]
+		if !ok {
+			return nil, fmt.Errorf("'%s' is not a valid middleware, available middlewares are %s", n, middlewareNames())
+		}
+		m, err := f(config)
+		if err != nil {
+			return nil, fmt.Errorf("error while creating %s middleware, error: %s", n, err.Error())
+		}
+		c = append(c, m)
+	}
+	return c, nil
+}
+
+func middlewareNames() string {
+	var names []string
+	for n := range factories {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Redact replaces fields of json data with RedactedValue, fields are dotted paths(e.g. card.number) and
+// fields of objects in arrays are redacted in all of the objects. Data that is not json is not changed.
+func Redact(fields ...string) Middleware {
+	paths := make([][]string, len(fields))
+	for i, f := range fields {
+		paths[i] = strings.Split(f, ".")
+	}
+	return MiddlewareFunc(func(ctx context.Context, e *Envelope) error {
+		v, raw, ok := decodeJSON(e.Data)
+		if !ok {
+			return nil
+		}
+		changed := false
+		for _, p := range paths {
+			var c bool
+			v, c = redact(v, p)
+			changed = changed || c
+		}
+		if !changed {
+			return nil
+		}
+		if !raw {
+			e.Data = v
+			return nil
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error while encoding redacted data, error: %s", err.Error())
+		}
+		if _, ok := e.Data.([]byte); ok {
+			e.Data = b
+		} else {
+			e.Data = string(b)
+		}
+		return nil
+	})
+}
+
+// decodeJSON returns data as generic json values, raw is true when data is json text.
+func decodeJSON(data interface{}) (v interface{}, raw bool, ok bool) {
+	switch d := data.(type) {
+	case map[string]interface{}, []interface{}:
+		return d, false, true
+	case string:
+		err := json.Unmarshal([]byte(d), &v)
+		return v, true, err == nil
+	case []byte:
+		err := json.Unmarshal(d, &v)
+		return v, true, err == nil
+	case nil:
+		return nil, false, false
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, false, false
+	}
+	err = json.Unmarshal(b, &v)
+	return v, false, err == nil
+}
+
+// redact returns a copy of v in which the field at path is replaced, objects and arrays on the path
+// are copied so data of hub messages that is shared between connections is not changed.
+func redact(v interface{}, path []string) (interface{}, bool) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		child, ok := x[path[0]]
+		if !ok {
+			return v, false
+		}
+		var nv interface{} = RedactedValue
+		if len(path) > 1 {
+			var changed bool
+			if nv, changed = redact(child, path[1:]); !changed {
+				return v, false
+			}
+		}
+		c := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			c[k] = e
+		}
+		c[path[0]] = nv
+		return c, true
+	case []interface{}:
+		var c []interface{}
+		for i, e := range x {
+			ne, changed := redact(e, path)
+			if !changed {
+				continue
+			}
+			if c == nil {
+				c = make([]interface{}, len(x))
+				copy(c, x)
+			}
+			c[i] = ne
+		}
+		if c == nil {
+			return v, false
+		}
+		return c, true
+	}
+	return v, false
+}
+
+// MaxSize rejects messages whose data is larger than size bytes, data that is not a string or bytes
+// is measured as json.
+func MaxSize(size int) Middleware {
+	return MiddlewareFunc(func(ctx context.Context, e *Envelope) error {
+		b, err := encodeData(e.Data)
+		if err != nil {
+			return fmt.Errorf("error while measuring message size, error: %s", err.Error())
+		}
+		if len(b) > size {
+			return fmt.Errorf("message is larger than %d bytes", size)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	m := Redact("password", "card.number", "items.secret")
+	ctx := context.Background()
+
+	e := &Envelope{Data: `{"user": "john", "password": "123", "card": {"number": "4111", "exp": "12/30"}}`}
+	assert.NoError(t, m.Process(ctx, e))
+	assert.JSONEq(t, `{"user": "john", "password": "[REDACTED]", "card": {"number": "[REDACTED]", "exp": "12/30"}}`, e.Data.(string))
+
+	e = &Envelope{Data: []byte(`{"items": [{"secret": 1}, {"name": "a"}]}`)}
+	assert.NoError(t, m.Process(ctx, e))
+	assert.JSONEq(t, `{"items": [{"secret": "[REDACTED]"}, {"name": "a"}]}`, string(e.Data.([]byte)))
+
+	// Decoded data is copied, so the original data is not changed.
+	data := map[string]interface{}{"password": "123", "card": map[string]interface{}{"number": "4111"}}
+	e = &Envelope{Data: data}
+	assert.NoError(t, m.Process(ctx, e))
+	assert.Equal(t, map[string]interface{}{"password": RedactedValue, "card": map[string]interface{}{"number": RedactedValue}}, e.Data)
+	assert.Equal(t, "123", data["password"])
+	assert.Equal(t, "4111", data["card"].(map[string]interface{})["number"])
+
+	e = &Envelope{Data: struct {
+		Password string `json:"password"`
+	}{"123"}}
+	assert.NoError(t, m.Process(ctx, e))
+	assert.Equal(t, map[string]interface{}{"password": RedactedValue}, e.Data)
+
+	for _, d := range []interface{}{"plain text", `{"user": "john"}`, float64(3), nil} {
+		e = &Envelope{Data: d}
+		assert.NoError(t, m.Process(ctx, e))
+		assert.Equal(t, d, e.Data)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	m := MaxSize(8)
+	ctx := context.Background()
+	assert.NoError(t, m.Process(ctx, &Envelope{Data: "12345678"}))
+	assert.Error(t, m.Process(ctx, &Envelope{Data: "123456789"}))
+	assert.NoError(t, m.Process(ctx, &Envelope{Data: map[string]int{"a": 1}}))
+	assert.Error(t, m.Process(ctx, &Envelope{Data: map[string]int{"abc": 100}}))
+}
+
+func TestNewChain(t *testing.T) {
+	var order []string
+	RegisterMiddleware("audit", func(c Configuration) (Middleware, error) {
+		return MiddlewareFunc(func(ctx context.Context, e *Envelope) error {
+			order = append(order, e.Data.(string))
+			return nil
+		}), nil
+	})
+	c, err := NewChain(Configuration{RedactFields: []string{"password"}, MaxMessageSize: 64}, "size", "redact", "audit")
+	assert.NoError(t, err)
+	assert.Len(t, c, 3)
+	e := &Envelope{Data: `{"password":"123"}`}
+	assert.NoError(t, c.Process(context.Background(), e))
+	assert.Equal(t, []string{`{"password":"[REDACTED]"}`}, order)
+
+	// Chains stop at the first rejection.
+	assert.Error(t, c.Process(context.Background(), &Envelope{Data: string(make([]byte, 65))}))
+	assert.Len(t, order, 1)
+
+	_, err = NewChain(Configuration{}, "unknown")
+	assert.Error(t, err)
+	_, err = NewChain(Configuration{}, "redact")
+	assert.Error(t, err)
+
+	c, err = NewChain(Configuration{})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Process(context.Background(), &Envelope{}))
+}
+
+func TestChain_Rejects(t *testing.T) {
+	reject := MiddlewareFunc(func(ctx context.Context, e *Envelope) error { return errors.New("rejected") })
+	called := false
+	next := MiddlewareFunc(func(ctx context.Context, e *Envelope) error { called = true; return nil })
+	assert.Error(t, Chain{reject, next}.Process(context.Background(), &Envelope{}))
+	assert.False(t, called)
+}
@@ -0,0 +1,405 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/mqtt"
+)
+
+// MQTTSubprotocol is the websocket subprotocol of MQTT connections.
+const MQTTSubprotocol = "mqtt"
+
+// mqttConn is state of an MQTT connection that is not shared with websocket connections.
+type mqttConn struct {
+	sess *session
+	// id is the id of the connection in connections of the instance, clientID is the mqtt client identifier.
+	id       string
+	clientID string
+	// will is published when the connection is closed without a DISCONNECT.
+	will *mqtt.Will
+	// out receives messages of all subscriptions of the connection, the writer of the session reads it.
+	out chan *hub.Message
+	// subs are subscriptions of the connection by their mqtt topic filter.
+	subs map[string]*mqttSubscription
+}
+
+// mqttSubscription is the hub subscription of an mqtt topic filter.
+type mqttSubscription struct {
+	patterns []string
+	cancel   func()
+	// ready is closed after retained messages of the subscription are passed to the connection, live
+	// messages are passed after it.
+	ready chan struct{}
+}
+
+// MQTT is a http handler that upgrades connections of MQTT 3.1.1 clients to websocket, clients subscribe to
+// and publish QoS 0 messages of hub topics. Topic levels are separated by / and + and # wildcards are
+// translated to websub wildcards, so devices/+/alerts is the devices.*.alerts pattern.
+func (h *SockHub) MQTT(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.admit(w, r)
+	if !ok {
+		return
+	}
+	defer t.Release()
+	wsConn, err := h.mqttUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.WithField("error", err).Error("mqtt upgrade error")
+		return
+	}
+	defer func() {
+		if err := wsConn.Close(); err != nil {
+			h.logger.WithField("error", err).Error("error while closing mqtt connection")
+		}
+	}()
+	connections.WithLabelValues("false", t.Label()).Inc()
+	// A frame can hold a whole packet with its fixed header.
+	wsConn.SetReadLimit(int64(h.mqttMaxPacketSize) + 5)
+	if err := wsConn.SetReadDeadline(time.Now().Add(h.Config.PongWait)); err != nil {
+		h.logger.WithField("error", err.Error()).Error("error while setting read deadline")
+		return
+	}
+	br := bufio.NewReader(&messageStream{conn: wsConn, binary: true})
+
+	// The first packet of a client must be CONNECT.
+	p, err := mqtt.ReadPacket(br, h.mqttMaxPacketSize)
+	if err != nil {
+		h.logger.WithField("remote_addr", r.RemoteAddr).WithError(err).Info("could not read mqtt connect packet")
+		return
+	}
+	cp, ok := p.(*mqtt.ConnectPacket)
+	if !ok {
+		h.logger.WithField("remote_addr", r.RemoteAddr).Info("first mqtt packet is not connect")
+		return
+	}
+	sess := &session{conn: wsConn, tenant: t, protocol: MQTTSubprotocol, writeWait: h.Config.WriteWait}
+	mc, code, err := h.mqttConnect(sess, cp)
+	if err != nil {
+		h.logger.WithField("remote_addr", r.RemoteAddr).WithError(err).Info("invalid mqtt connect packet")
+		return
+	}
+	if err := sess.write(websocket.BinaryMessage, (&mqtt.ConnackPacket{ReturnCode: code}).Encode()); err != nil || code != mqtt.Accepted {
+		return
+	}
+	h.logger.WithField("username", sess.username).WithField("client_id", mc.clientID).Info("mqtt connection created for user")
+	defer h.logger.WithField("username", sess.username).WithField("client_id", mc.clientID).Info("mqtt connection closed")
+
+	// Connections are closed when no packet or pong is received in one and a half keep alive.
+	wait := h.Config.PongWait
+	if cp.KeepAlive > 0 {
+		wait = time.Duration(cp.KeepAlive) * time.Second * 3 / 2
+	}
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(wait))
+	})
+
+	untrack := h.track(&ConnectionInfo{
+		ID:          mc.id,
+		Username:    sess.username,
+		Tenant:      t.Label(),
+		Topics:      []string{},
+		Protocol:    MQTTSubprotocol,
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: time.Now(),
+	})
+	defer untrack()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer func() {
+		for _, s := range mc.subs {
+			s.cancel()
+		}
+	}()
+	pingTicker := time.NewTicker(h.Config.PingInterval)
+	defer pingTicker.Stop()
+	h.writer(ctx, sess, &hub.Subscription{Topics: MQTTSubprotocol, MessageChannel: mc.out}, pingTicker.C, nil)
+
+	clean := h.mqttReader(ctx, mc, br, wait)
+	if !clean && mc.will != nil {
+		h.publishWill(mc)
+	}
+}
+
+// mqttConnect authenticates the user of a CONNECT packet and returns the connection and the return code of
+// the CONNACK, usernames of users are their mqtt username or client id. Connections that violate the protocol
+// are closed without a CONNACK.
+func (h *SockHub) mqttConnect(sess *session, cp *mqtt.ConnectPacket) (*mqttConn, byte, error) {
+	if cp.ProtocolName != "MQTT" || cp.ProtocolLevel != mqtt.ProtocolLevel {
+		return nil, mqtt.UnacceptableProtocolVersion, nil
+	}
+	// Sessions are not persisted, so clients that resume sessions must have an id.
+	if cp.ClientID == "" && !cp.CleanSession {
+		return nil, mqtt.IdentifierRejected, nil
+	}
+	if cp.Will != nil {
+		if _, err := mqtt.ToTopic(cp.Will.Topic); err != nil {
+			return nil, 0, fmt.Errorf("invalid will topic, error: %s", err.Error())
+		}
+	}
+	id := strconv.FormatUint(atomic.AddUint64(&h.connSeq, 1), 10)
+	mc := &mqttConn{
+		sess:     sess,
+		id:       id,
+		clientID: cp.ClientID,
+		will:     cp.Will,
+		out:      make(chan *hub.Message, h.subscriptionBufferSize),
+		subs:     make(map[string]*mqttSubscription),
+	}
+	if mc.clientID == "" {
+		mc.clientID = "mqtt-" + id
+	}
+	// TODO Authenticate user with the password.
+	sess.username = mc.clientID
+	if cp.Username != nil && *cp.Username != "" {
+		sess.username = *cp.Username
+	}
+	// mqtt users receive QoS 0 messages, so latest messages of coalesced topics are enough.
+	sess.coalescer = newCoalescer(h.windows)
+	return mc, mqtt.Accepted, nil
+}
+
+// mqttReader reads packets of a connection until it's closed, it returns true when client disconnects with
+// a DISCONNECT packet.
+func (h *SockHub) mqttReader(ctx context.Context, mc *mqttConn, br io.ByteReader, wait time.Duration) bool {
+	sess := mc.sess
+	for {
+		if err := sess.conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+			h.logger.WithField("error", err.Error()).Error("error while setting read deadline")
+			return false
+		}
+		p, err := mqtt.ReadPacket(br, h.mqttMaxPacketSize)
+		if err != nil {
+			h.logger.WithField("username", sess.username).WithError(err).Debug("mqtt connection is closed")
+			return false
+		}
+		var reply mqtt.Packet
+		switch p := p.(type) {
+		case *mqtt.PublishPacket:
+			if p.QoS > 1 {
+				h.logger.WithField("username", sess.username).WithField("topic", p.Topic).Info("mqtt QoS 2 publishes are not supported")
+				return false
+			}
+			h.mqttPublish(ctx, sess, p)
+			if p.QoS == 1 {
+				reply = &mqtt.PubackPacket{PacketID: p.PacketID}
+			}
+		case *mqtt.SubscribePacket:
+			reply = h.mqttSubscribe(ctx, mc, p)
+		case *mqtt.UnsubscribePacket:
+			h.mqttUnsubscribe(mc, p.Filters)
+			reply = &mqtt.UnsubackPacket{PacketID: p.PacketID}
+		case *mqtt.PingreqPacket:
+			reply = &mqtt.PingrespPacket{}
+		case *mqtt.DisconnectPacket:
+			return true
+		default:
+			h.logger.WithField("username", sess.username).WithField("packet", fmt.Sprintf("%T", p)).Info("unexpected mqtt packet from user")
+			return false
+		}
+		if reply == nil {
+			continue
+		}
+		if err := sess.write(websocket.BinaryMessage, reply.Encode()); err != nil {
+			h.logger.WithField("username", sess.username).WithError(err).Error("error while sending mqtt packet to user")
+			return false
+		}
+		// Retained messages of new subscriptions are sent after their SUBACK.
+		if sp, ok := p.(*mqtt.SubscribePacket); ok {
+			h.mqttRetained(ctx, mc, sp)
+		}
+	}
+}
+
+// mqttPublish publishes an mqtt message like messages of websocket users, an empty retained message removes
+// the retained message of its topic.
+func (h *SockHub) mqttPublish(ctx context.Context, sess *session, p *mqtt.PublishPacket) {
+	topic, err := mqtt.ToTopic(p.Topic)
+	if err != nil {
+		h.logger.WithField("username", sess.username).WithError(err).Info("invalid mqtt publish topic")
+		return
+	}
+	h.publish(ctx, sess, &ClientMessage{Topic: topic, Body: string(p.Payload), Retain: p.Retain})
+}
+
+// mqttSubscribe subscribes a connection to topic filters and returns the SUBACK, all subscriptions are
+// granted QoS 0.
+func (h *SockHub) mqttSubscribe(ctx context.Context, mc *mqttConn, p *mqtt.SubscribePacket) *mqtt.SubackPacket {
+	ack := &mqtt.SubackPacket{PacketID: p.PacketID, ReturnCodes: make([]byte, len(p.Subscriptions))}
+	for i, s := range p.Subscriptions {
+		// TODO authorize user access to the topics.
+		patterns, err := mqtt.ToPatterns(s.Filter)
+		if err != nil {
+			h.logger.WithField("username", mc.sess.username).WithError(err).Info("invalid mqtt topic filter")
+			ack.ReturnCodes[i] = mqtt.SubscribeFailure
+			continue
+		}
+		// A subscription replaces the existing subscription of the same filter.
+		if old, ok := mc.subs[s.Filter]; ok {
+			old.cancel()
+			delete(mc.subs, s.Filter)
+		}
+		sub, err := h.mqttHubSubscribe(ctx, mc, patterns)
+		if err != nil {
+			h.logger.WithField("username", mc.sess.username).WithField("filter", s.Filter).WithError(err).Info("mqtt subscription failed for user")
+			ack.ReturnCodes[i] = mqtt.SubscribeFailure
+			continue
+		}
+		mc.subs[s.Filter] = sub
+	}
+	h.mqttTopics(mc)
+	return ack
+}
+
+// mqttHubSubscribe creates the hub subscription of patterns and passes its messages to the connection.
+func (h *SockHub) mqttHubSubscribe(ctx context.Context, mc *mqttConn, patterns []string) (*mqttSubscription, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var sub *hub.Subscription
+	var err error
+	s := &mqttSubscription{patterns: patterns, cancel: cancel, ready: make(chan struct{})}
+	if h.shared != nil {
+		var unsubscribe func()
+		if sub, unsubscribe, err = h.shared.subscribe(mc.sess.tenant.Topics(patterns)...); err == nil {
+			s.cancel = func() {
+				unsubscribe()
+				cancel()
+			}
+		}
+	} else {
+		sub, err = h.Hub.Subscribe(ctx, mc.sess.tenant.Topics(patterns)...)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return
+		}
+		for {
+			select {
+			case msg := <-sub.MessageChannel:
+				select {
+				case mc.out <- msg:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// mqttUnsubscribe cancels subscriptions of topic filters.
+func (h *SockHub) mqttUnsubscribe(mc *mqttConn, filters []string) {
+	for _, f := range filters {
+		if s, ok := mc.subs[f]; ok {
+			s.cancel()
+			delete(mc.subs, f)
+		}
+	}
+	h.mqttTopics(mc)
+}
+
+// mqttTopics updates topics of the connection in connections of the instance.
+func (h *SockHub) mqttTopics(mc *mqttConn) {
+	topics := []string{}
+	for _, s := range mc.subs {
+		topics = append(topics, s.patterns...)
+	}
+	h.setTopics(mc.id, topics)
+}
+
+// mqttRetained passes retained messages of granted filters of a SUBSCRIBE to the connection, then live
+// messages of the subscriptions are passed so an older retained message doesn't follow a newer one.
+func (h *SockHub) mqttRetained(ctx context.Context, mc *mqttConn, p *mqtt.SubscribePacket) {
+	var patterns []string
+	subs := make(map[*mqttSubscription]bool)
+	for _, s := range p.Subscriptions {
+		if sub, ok := mc.subs[s.Filter]; ok && !subs[sub] {
+			subs[sub] = true
+			patterns = append(patterns, sub.patterns...)
+		}
+	}
+	defer func() {
+		for sub := range subs {
+			close(sub.ready)
+		}
+	}()
+	if len(patterns) == 0 {
+		return
+	}
+	for _, msg := range h.retained(ctx, mc.sess, patterns) {
+		select {
+		case mc.out <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publishWill publishes the will message of a connection that is closed without a DISCONNECT.
+func (h *SockHub) publishWill(mc *mqttConn) {
+	// The request context is done when the connection is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), h.Config.WriteWait)
+	defer cancel()
+	h.logger.WithField("username", mc.sess.username).WithField("topic", mc.will.Topic).Debug("publishing mqtt will")
+	h.mqttPublish(ctx, mc.sess, &mqtt.PublishPacket{Topic: mc.will.Topic, Payload: mc.will.Message, Retain: mc.will.Retain})
+}
+
+// deliverMQTT writes a hub message to an mqtt user in a QoS 0 PUBLISH.
+func (h *SockHub) deliverMQTT(sess *session, msg *hub.Message) error {
+	b, err := encodeData(msg.Data)
+	if err != nil {
+		h.logger.WithField("channel", msg.Topic).WithError(err).Error("could not encode message data")
+		return nil
+	}
+	p := &mqtt.PublishPacket{Topic: mqtt.FromTopic(msg.Topic), Retain: msg.Retained, Payload: b}
+	return sess.write(websocket.BinaryMessage, p.Encode())
+}
+
+// messageStream reads websocket messages of a connection as one stream, packets of MQTT and frames of STOMP
+// can span messages.
+type messageStream struct {
+	conn *websocket.Conn
+	// binary rejects text messages, MQTT packets must be sent in binary messages.
+	binary bool
+	r      io.Reader
+}
+
+func (s *messageStream) Read(p []byte) (int, error) {
+	for {
+		if s.r == nil {
+			mt, r, err := s.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if s.binary && mt != websocket.BinaryMessage {
+				return 0, errors.New("text messages are not accepted")
+			}
+			s.r = r
+		}
+		n, err := s.r.Read(p)
+		if err == io.EOF {
+			s.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
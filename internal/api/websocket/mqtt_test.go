@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/pkg/mqtt"
+	"github.com/stretchr/testify/assert"
+)
+
+// mqttClient is a test client that writes and reads mqtt packets of a connection.
+type mqttClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+	r    *bufio.Reader
+}
+
+func dialMQTT(t *testing.T, ts *testServer, cp *mqtt.ConnectPacket) (*mqttClient, *mqtt.ConnackPacket) {
+	s := httptest.NewServer(http.HandlerFunc(ts.sh.MQTT))
+	t.Cleanup(s.Close)
+	d := &websocket.Dialer{Subprotocols: []string{MQTTSubprotocol}}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	assert.Equal(t, MQTTSubprotocol, conn.Subprotocol())
+	c := &mqttClient{t: t, conn: conn, r: bufio.NewReader(&messageStream{conn: conn, binary: true})}
+	c.write(cp)
+	ack, _ := c.read().(*mqtt.ConnackPacket)
+	return c, ack
+}
+
+func (c *mqttClient) write(p mqtt.Packet) {
+	assert.NoError(c.t, c.conn.WriteMessage(websocket.BinaryMessage, p.Encode()))
+}
+
+func (c *mqttClient) read() mqtt.Packet {
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	p, err := mqtt.ReadPacket(c.r, 0)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return p
+}
+
+func TestSockHub_MQTT(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	ctx := context.Background()
+	assert.NoError(t, ts.hub.PublishRetained(ctx, "status.device0", "online"))
+
+	username := "device1"
+	c, ack := dialMQTT(t, ts, &mqtt.ConnectPacket{
+		ProtocolName:  "MQTT",
+		ProtocolLevel: mqtt.ProtocolLevel,
+		CleanSession:  true,
+		ClientID:      "c1",
+		Username:      &username,
+		Will:          &mqtt.Will{Topic: "status/device1", Message: []byte("offline"), Retain: true},
+	})
+	assert.Equal(t, &mqtt.ConnackPacket{ReturnCode: mqtt.Accepted}, ack)
+
+	// Invalid filters are rejected and retained messages of granted filters are sent after the SUBACK.
+	c.write(&mqtt.SubscribePacket{PacketID: 1, Subscriptions: []mqtt.Subscription{
+		{Filter: "devices/+/alerts", QoS: 1},
+		{Filter: "status/#"},
+		{Filter: "bad/#/filter"},
+	}})
+	assert.Equal(t, &mqtt.SubackPacket{PacketID: 1, ReturnCodes: []byte{0, 0, mqtt.SubscribeFailure}}, c.read())
+	assert.Equal(t, &mqtt.PublishPacket{Topic: "status/device0", Retain: true, Payload: []byte("online")}, c.read())
+	cs := ts.sh.Connections("device1")
+	if assert.Len(t, cs, 1) {
+		assert.Equal(t, MQTTSubprotocol, cs[0].Protocol)
+		assert.Equal(t, []string{"devices.*.alerts", "status", "status.>"}, cs[0].Topics)
+	}
+	assert.Equal(t, &Presence{Topic: "devices.d1.alerts", Users: []string{"device1"}}, ts.sh.Presence("devices.d1.alerts"))
+
+	assert.NoError(t, ts.hub.Publish(ctx, "devices.d1.alerts", "fire"))
+	assert.Equal(t, &mqtt.PublishPacket{Topic: "devices/d1/alerts", Payload: []byte("fire")}, c.read())
+
+	// Publishes of mqtt clients are received by websocket users, QoS 1 publishes are acknowledged.
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=devices.d2.alerts", "devices.d2.alerts")
+	c.write(&mqtt.PublishPacket{Topic: "devices/d2/alerts", QoS: 1, PacketID: 5, Payload: []byte("smoke")})
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "smoke", string(b))
+	// The client is subscribed to the topic too, so it may receive the message before the PUBACK.
+	assert.ElementsMatch(t, []mqtt.Packet{
+		&mqtt.PubackPacket{PacketID: 5},
+		&mqtt.PublishPacket{Topic: "devices/d2/alerts", Payload: []byte("smoke")},
+	}, []mqtt.Packet{c.read(), c.read()})
+
+	c.write(&mqtt.UnsubscribePacket{PacketID: 2, Filters: []string{"devices/+/alerts"}})
+	assert.Equal(t, &mqtt.UnsubackPacket{PacketID: 2}, c.read())
+	c.write(&mqtt.PingreqPacket{})
+	assert.Equal(t, &mqtt.PingrespPacket{}, c.read())
+	assert.Equal(t, []string{"status", "status.>"}, ts.sh.Connections("device1")[0].Topics)
+
+	// The will is published when the connection is closed without a DISCONNECT.
+	_ = c.conn.Close()
+	var data interface{}
+	for i := 0; i < 100 && data == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if msgs, _ := ts.hub.Retained(ctx, "status.device1"); len(msgs) > 0 {
+			data = msgs[0].Data
+		}
+	}
+	assert.Equal(t, "offline", data)
+}
+
+func TestSockHub_MQTTConnect(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	_, ack := dialMQTT(t, ts, &mqtt.ConnectPacket{ProtocolName: "MQIsdp", ProtocolLevel: 3, CleanSession: true})
+	assert.Equal(t, &mqtt.ConnackPacket{ReturnCode: mqtt.UnacceptableProtocolVersion}, ack)
+	_, ack = dialMQTT(t, ts, &mqtt.ConnectPacket{ProtocolName: "MQTT", ProtocolLevel: mqtt.ProtocolLevel})
+	assert.Equal(t, &mqtt.ConnackPacket{ReturnCode: mqtt.IdentifierRejected}, ack)
+
+	// Clients without an id get one and their username is their client id.
+	c, ack := dialMQTT(t, ts, &mqtt.ConnectPacket{ProtocolName: "MQTT", ProtocolLevel: mqtt.ProtocolLevel, CleanSession: true})
+	assert.Equal(t, &mqtt.ConnackPacket{ReturnCode: mqtt.Accepted}, ack)
+	cs := ts.sh.Connections("")
+	if assert.Len(t, cs, 1) {
+		assert.True(t, strings.HasPrefix(cs[0].Username, "mqtt-"))
+	}
+	c.write(&mqtt.DisconnectPacket{})
+	for i := 0; i < 100 && len(ts.sh.Connections("")) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Empty(t, ts.sh.Connections(""))
+}
+
+func TestSockHub_MQTTRetained(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	ts.sh.Hub = &retainingHub{RedisHub: ts.hub, live: "new"}
+	assert.NoError(t, ts.hub.PublishRetained(context.Background(), "news", "old"))
+	username := "device1"
+	c, ack := dialMQTT(t, ts, &mqtt.ConnectPacket{ProtocolName: "MQTT", ProtocolLevel: mqtt.ProtocolLevel, CleanSession: true, ClientID: "c1", Username: &username})
+	assert.Equal(t, &mqtt.ConnackPacket{ReturnCode: mqtt.Accepted}, ack)
+
+	// The retained message is sent before live messages that are published while it's read.
+	c.write(&mqtt.SubscribePacket{PacketID: 1, Subscriptions: []mqtt.Subscription{{Filter: "news"}}})
+	assert.Equal(t, &mqtt.SubackPacket{PacketID: 1, ReturnCodes: []byte{0}}, c.read())
+	assert.Equal(t, &mqtt.PublishPacket{Topic: "news", Retain: true, Payload: []byte("old")}, c.read())
+	assert.Equal(t, &mqtt.PublishPacket{Topic: "news", Payload: []byte("new")}, c.read())
+}
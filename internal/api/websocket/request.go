@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"context"
+	"github.com/mammadmodi/websub/pkg/hub"
+)
+
+// ReplyMessage is type of frames that carry replies of requests.
+const ReplyMessage = "reply"
+
+// ReplyFrame is structure of messages that are sent to users as replies of their requests, Error is set
+// when no reply is received.
+type ReplyFrame struct {
+	Type  string      `json:"type"`
+	ID    string      `json:"id"`
+	Topic string      `json:"topic"`
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// request publishes body of a user request to its topic and sends the first reply(or an error after
+// request timeout) to the user in background, ID of the request is kept in the reply frame. Requests are
+// validated like publishes and replies pass through the outbound chain like messages, requests of a user
+// are rejected when MaxPendingRequests of them wait for their reply. Reply topics are not sent to websocket
+// users, so requests are answered by responders that subscribe to the hub.
+func (h *SockHub) request(ctx context.Context, sess *session, cm *ClientMessage) {
+	rf := &ReplyFrame{Type: ReplyMessage, ID: cm.ID, Topic: cm.Topic}
+	rh, ok := h.Hub.(hub.RequestHub)
+	if !ok {
+		rf.Error = "requests are not supported by the hub"
+		h.reply(sess, rf)
+		return
+	}
+
+	e, err := h.inbound(ctx, sess, cm)
+	if err != nil {
+		rf.Error = err.Error()
+		h.reply(sess, rf)
+		return
+	}
+	if !h.validate(sess, cm, e) {
+		return
+	}
+	select {
+	case sess.requests <- struct{}{}:
+	default:
+		h.logger.WithField("username", sess.username).WithField("topic", cm.Topic).Info("request rejected because of pending requests")
+		rf.Error = "too many pending requests"
+		h.reply(sess, rf)
+		return
+	}
+
+	go func() {
+		defer func() { <-sess.requests }()
+		rctx, cancel := context.WithTimeout(ctx, h.Config.RequestTimeout)
+		defer cancel()
+		msg, err := rh.Request(rctx, sess.tenant.Topic(e.Topic), e.Data)
+		if err != nil {
+			h.logger.WithField("username", sess.username).WithField("topic", cm.Topic).WithError(err).Info("request failed")
+			rf.Error = "no reply is received"
+		} else if m, ok := h.outbound(rctx, sess, msg); !ok {
+			rf.Error = "reply is rejected"
+		} else {
+			rf.Data = newMessageFrame(m).Data
+		}
+		h.reply(sess, rf)
+	}()
+}
+
+// reply writes a reply frame to the user.
+func (h *SockHub) reply(sess *session, rf *ReplyFrame) {
+	h.writeFrame(sess, rf)
+}
@@ -4,9 +4,13 @@ package websocket
 
 import (
 	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/internal/api/schema"
 	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/origin"
+	"github.com/mammadmodi/websub/pkg/tenant"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -20,6 +24,64 @@ type Configuration struct {
 	WriteWait time.Duration `default:"20s" split_words:"true"`
 	// ReadLimit is maximum size of messages(in Bytes) that is received from user.
 	ReadLimit int64 `default:"4096" split_words:"true"`
+	// AckTimeout is duration that server waits for acknowledgement of a message before redelivering it.
+	AckTimeout time.Duration `default:"10s" split_words:"true"`
+	// MaxDeliveries is number of times that an unacknowledged message is delivered before it's nacked.
+	MaxDeliveries int `default:"5" split_words:"true"`
+	// RequestTimeout is duration that server waits for the reply of a request.
+	RequestTimeout time.Duration `default:"5s" split_words:"true"`
+	// MaxPendingRequests is maximum number of requests of a connection that wait for their reply, more
+	// requests are answered with an error.
+	MaxPendingRequests int `default:"16" split_words:"true"`
+	// QueueGroups are queue groups that connections can join and their tokens(e.g. workers:secret), connections
+	// pass the token of their group as group_token. Queue groups are disabled when it's empty.
+	QueueGroups map[string]string `split_words:"true"`
+	// AdminToken is the bearer token of connections and presence apis, the apis are disabled when it's empty.
+	AdminToken string `split_words:"true"`
+	// AckTopic is the topic that acknowledgement reports are published to, reports are dropped if it's empty.
+	AckTopic string `split_words:"true"`
+	// AllowedOrigins is the origin policy of connections, it's comma separated list of exact origins,
+	// wildcard subdomains(https://*.example.com), regexes(/^https://.+$/), same-host or * that allows all
+	// origins.
+	AllowedOrigins origin.Policy `default:"same-host" split_words:"true"`
+	// FilterMaxLength, FilterMaxNodes and FilterMaxDepth limit complexity of subscription filters.
+	FilterMaxLength int `default:"512" split_words:"true"`
+	FilterMaxNodes  int `default:"32" split_words:"true"`
+	FilterMaxDepth  int `default:"8" split_words:"true"`
+	// InboundMiddlewares and OutboundMiddlewares are names of middlewares of messages that users publish
+	// and messages that are sent to users, in the order they run.
+	InboundMiddlewares  []string `split_words:"true"`
+	OutboundMiddlewares []string `split_words:"true"`
+	// RedactFields are dotted paths of fields that are replaced by the redact middleware.
+	RedactFields []string `split_words:"true"`
+	// MaxMessageSize is maximum size of messages(in Bytes) that pass the size middleware.
+	MaxMessageSize int `default:"65536" split_words:"true"`
+	// SharedSubscriptions makes connections share one hub subscription of each topic, by default each
+	// connection has its own hub subscription. Durable and queue group subscriptions are never shared.
+	SharedSubscriptions bool `default:"false" split_words:"true"`
+	// SubscriptionBufferSize is number of messages of shared subscriptions that are buffered for each
+	// connection, messages of a connection whose buffer is full are dropped.
+	SubscriptionBufferSize int `default:"256" split_words:"true"`
+	// MaxBatchSize and MaxBatchInterval limit batches of connections(batch_size and batch_ms), batch_size is
+	// MaxBatchSize when connection doesn't set it.
+	MaxBatchSize     int           `default:"500" split_words:"true"`
+	MaxBatchInterval time.Duration `default:"1s" split_words:"true"`
+	// CoalesceTopics are coalescing windows of topic patterns(e.g. prices.*:100ms,fx.>:50ms), only the latest
+	// message of a matching topic in each window is delivered.
+	CoalesceTopics map[string]time.Duration `split_words:"true"`
+	// MQTTMaxPacketSize is maximum size of packets(in Bytes) that are received from MQTT clients.
+	MQTTMaxPacketSize int `default:"65536" split_words:"true"`
+	// GraphQLTopics are topics of root fields of graphql subscriptions(e.g. orderCreated:orders.created),
+	// fields that are not listed subscribe to the topic of their name.
+	GraphQLTopics map[string]string `envconfig:"graphql_topics"`
+	// GraphQLInitTimeout is duration that server waits for connection_init of graphql connections.
+	GraphQLInitTimeout time.Duration `default:"3s" envconfig:"graphql_init_timeout"`
+	// EnableCompression negotiates permessage-deflate with clients that support it.
+	EnableCompression bool `default:"false" split_words:"true"`
+	// CompressionLevel is the flate level of compressed messages, from -2(huffman only) to 9(best compression).
+	CompressionLevel int `default:"1" split_words:"true"`
+	// CompressionThreshold is minimum size of messages(in Bytes) that are compressed.
+	CompressionThreshold int `default:"256" split_words:"true"`
 }
 
 // SockHub tunnels websocket messages(in and out) to a pubsub hub.
@@ -27,21 +89,89 @@ type SockHub struct {
 	// Hub is a core pubsub driver(e.g. RedisHub) that is used to tunneling messages.
 	Hub    hub.Hub
 	Config Configuration
+	// Schemas validates bodies of user publishes, publishes are not validated when it's nil.
+	Schemas *schema.Registry
+	// Tenants resolves tenants of connections, topics are not namespaced and limited when it's nil.
+	Tenants *tenant.Registry
+	// Inbound and Outbound are middleware chains of messages that users publish and messages that are
+	// sent to users.
+	Inbound  Chain
+	Outbound Chain
 
 	logger   *logrus.Logger
 	upgrader *websocket.Upgrader
+	// mqttUpgrader negotiates the mqtt subprotocol, mqttMaxPacketSize is MQTTMaxPacketSize or its default.
+	mqttUpgrader      *websocket.Upgrader
+	mqttMaxPacketSize int
+	// ackTimeout, maxDeliveries and maxPendingRequests are AckTimeout, MaxDeliveries and MaxPendingRequests or
+	// their defaults when they're not positive.
+	ackTimeout         time.Duration
+	maxDeliveries      int
+	maxPendingRequests int
+	// subscriptionBufferSize is SubscriptionBufferSize or its default when it's not positive.
+	subscriptionBufferSize int
+	// shared holds shared subscriptions of topics, it's nil when subscriptions are dedicated.
+	shared *fanout
+	// windows are coalescing windows of CoalesceTopics.
+	windows []coalesceWindow
+	// conns are connections of the instance by their id.
+	connsMu sync.RWMutex
+	conns   map[string]*ConnectionInfo
+	connSeq uint64
 }
 
 // NewSockHub creates a SockHub object.
 func NewSockHub(config Configuration, hub hub.Hub, logger *logrus.Logger) *SockHub {
 	m := &SockHub{
-		Hub:    hub,
-		Config: config,
-		logger: logger,
-		upgrader: &websocket.Upgrader{
-			// TODO you should not ignore origin check in production.
-			CheckOrigin: func(r *http.Request) bool { return true },
-		},
+		Hub:     hub,
+		Config:  config,
+		logger:  logger,
+		conns:   make(map[string]*ConnectionInfo),
+		windows: sortWindows(config.CoalesceTopics),
+	}
+	m.subscriptionBufferSize = config.SubscriptionBufferSize
+	if m.subscriptionBufferSize <= 0 {
+		m.subscriptionBufferSize = 256
+	}
+	if config.SharedSubscriptions {
+		m.shared = newFanout(hub, m.subscriptionBufferSize, logger)
+	}
+	m.upgrader = &websocket.Upgrader{
+		CheckOrigin:       m.checkOrigin,
+		EnableCompression: config.EnableCompression,
+		Subprotocols:      []string{StompSubprotocol, GraphQLSubprotocol},
+	}
+	m.ackTimeout, m.maxDeliveries = config.AckTimeout, config.MaxDeliveries
+	if m.ackTimeout <= 0 {
+		m.ackTimeout = 10 * time.Second
+	}
+	if m.maxDeliveries <= 0 {
+		m.maxDeliveries = 5
+	}
+	m.maxPendingRequests = config.MaxPendingRequests
+	if m.maxPendingRequests <= 0 {
+		m.maxPendingRequests = 16
+	}
+	m.mqttMaxPacketSize = config.MQTTMaxPacketSize
+	if m.mqttMaxPacketSize <= 0 {
+		m.mqttMaxPacketSize = 65536
+	}
+	m.mqttUpgrader = &websocket.Upgrader{
+		CheckOrigin:  m.checkOrigin,
+		Subprotocols: []string{MQTTSubprotocol},
 	}
 	return m
 }
+
+// checkOrigin checks origin of a connection request with the origin policy.
+func (h *SockHub) checkOrigin(r *http.Request) bool {
+	if h.Config.AllowedOrigins.Allowed(r) {
+		return true
+	}
+	origin.RejectedOrigins.WithLabelValues("socket").Inc()
+	h.logger.
+		WithField("origin", r.Header.Get("Origin")).
+		WithField("username", r.URL.Query().Get("username")).
+		Warn("connection rejected because of its origin")
+	return false
+}
@@ -3,10 +3,13 @@ package websocket
 import (
 	"github.com/golang/mock/gomock"
 	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/origin"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestNewSockHub(t *testing.T) {
@@ -20,4 +23,27 @@ func TestNewSockHub(t *testing.T) {
 	assert.Equal(t, c, sh.Config)
 	assert.Equal(t, l, sh.logger)
 	assert.NotNil(t, sh.upgrader)
+
+	// Acknowledgements and requests fall back to defaults when their config is not positive.
+	assert.Equal(t, 10*time.Second, sh.ackTimeout)
+	assert.Equal(t, 5, sh.maxDeliveries)
+	assert.Equal(t, 16, sh.maxPendingRequests)
+
+	// Negative buffer sizes fall back to the default so buffers of connections can be created.
+	sh = NewSockHub(Configuration{SubscriptionBufferSize: -1}, h, l)
+	assert.Equal(t, 256, sh.subscriptionBufferSize)
+}
+
+func TestSockHub_CheckOrigin(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	p, _ := origin.NewPolicy("https://example.com")
+	sh := NewSockHub(Configuration{AllowedOrigins: *p}, nil, l)
+
+	r := httptest.NewRequest("GET", "/socket/connect", nil)
+	r.Header.Set("Origin", "https://example.com")
+	assert.True(t, sh.upgrader.CheckOrigin(r))
+
+	r.Header.Set("Origin", "https://evil.com")
+	assert.False(t, sh.upgrader.CheckOrigin(r))
 }
@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/pkg/filter"
+	"github.com/mammadmodi/websub/pkg/tenant"
+	"sync"
+	"time"
+)
+
+// session holds state of a websocket connection of a user.
+type session struct {
+	username string
+	conn     *websocket.Conn
+	// tenant namespaces topics of the user and limits its messages, it's nil when tenants are not enabled.
+	tenant *tenant.Tenant
+	// acks tracks messages that are not acknowledged by the user, it's nil when the
+	// user doesn't acknowledge messages.
+	acks *ackTracker
+	// frames is true when messages are wrapped in MessageFrame, messages of users who acknowledge
+	// messages are always wrapped.
+	frames bool
+	// batch collects messages that are written in one frame, messages are written one by one when it's nil.
+	batch *batch
+	// coalescer delivers only the latest message of coalesced topics in each window, it's nil when no topic
+	// is coalesced.
+	coalescer *coalescer
+	// protocol is the subprotocol of MQTT and STOMP connections, messages of MQTT connections are written in
+	// PUBLISH packets and rejected publishes of STOMP connections are reported with ERROR frames.
+	protocol string
+	// requests holds a slot for each request of the user that waits for its reply.
+	requests chan struct{}
+	// filter selects messages that are written to the user, all messages are written when it's nil.
+	filter *filter.Filter
+	// compress is true when permessage-deflate is negotiated, messages smaller than
+	// compressionThreshold are not compressed.
+	compress             bool
+	compressionThreshold int
+	// wire counts bytes that are written to the underlying connection.
+	wire *countingConn
+
+	writeWait time.Duration
+	// writeMu serializes writes because websocket connections support one concurrent writer.
+	writeMu sync.Mutex
+}
+
+// write writes a message to the connection with a write deadline.
+func (s *session) write(messageType int, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(s.writeWait)); err != nil {
+		return fmt.Errorf("error while setting write deadline, error: %s", err.Error())
+	}
+	compressed := s.compress && len(data) >= s.compressionThreshold
+	s.conn.EnableWriteCompression(compressed)
+	if s.wire == nil || (messageType != websocket.TextMessage && messageType != websocket.BinaryMessage) {
+		return s.conn.WriteMessage(messageType, data)
+	}
+
+	before := s.wire.Written()
+	if err := s.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	observeWrite(compressed, int64(len(data)), s.wire.Written()-before)
+	return nil
+}
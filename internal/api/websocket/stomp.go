@@ -0,0 +1,610 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/stomp"
+	"github.com/mammadmodi/websub/pkg/tenant"
+)
+
+// StompSubprotocol is the websocket subprotocol of STOMP 1.2 connections, Connect serves connections that
+// negotiate it.
+const StompSubprotocol = "v12.stomp"
+
+// Acknowledgement modes of STOMP subscriptions.
+const (
+	stompAutoAck             = "auto"
+	stompClientAck           = "client"
+	stompClientIndividualAck = "client-individual"
+)
+
+// stompTopicPrefix is the destination prefix of topics in Spring brokers, /topic/orders.created is the
+// orders.created topic.
+const stompTopicPrefix = "/topic/"
+
+// stompConn is state of a STOMP connection that is not shared with websocket connections.
+type stompConn struct {
+	sess *session
+	id   string
+	// out receives messages of all subscriptions of the connection, the writer writes them in MESSAGE frames.
+	out chan *stompDelivery
+	// seq numbers messages that have no id.
+	seq uint64
+
+	// mu guards subscriptions and their delivery order which are used by the reader and the writer.
+	mu   sync.Mutex
+	subs map[string]*stompSubscription
+}
+
+// stompSubscription is the hub subscription of a SUBSCRIBE frame.
+type stompSubscription struct {
+	id     string
+	topic  string
+	prefix string
+	ack    string
+	// acks tracks unacknowledged messages of client and client-individual subscriptions, order is their ids
+	// in the order they're delivered for cumulative acknowledgements of client subscriptions.
+	acks   *ackTracker
+	order  []string
+	cancel func()
+}
+
+// stompDelivery is a message of a subscription.
+type stompDelivery struct {
+	sub *stompSubscription
+	msg *hub.Message
+}
+
+// stomp serves a STOMP 1.2 connection, users subscribe to topics with SUBSCRIBE frames and publish with SEND
+// frames. Subscriptions with client acknowledgements use durable subscriptions like connections with ack.
+func (h *SockHub) stomp(w http.ResponseWriter, r *http.Request, t *tenant.Tenant) {
+	wsConn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.WithField("error", err).Error("stomp upgrade error")
+		return
+	}
+	defer func() {
+		if err := wsConn.Close(); err != nil {
+			h.logger.WithField("error", err).Error("error while closing stomp connection")
+		}
+	}()
+	compress := h.Config.EnableCompression && offersDeflate(r)
+	connections.WithLabelValues(strconv.FormatBool(compress), t.Label()).Inc()
+	wsConn.SetReadLimit(h.Config.ReadLimit)
+	if err := wsConn.SetReadDeadline(time.Now().Add(h.Config.PongWait)); err != nil {
+		h.logger.WithField("error", err.Error()).Error("error while setting read deadline")
+		return
+	}
+	br := bufio.NewReader(&messageStream{conn: wsConn})
+	sess := &session{
+		conn:                 wsConn,
+		tenant:               t,
+		protocol:             StompSubprotocol,
+		compress:             compress,
+		compressionThreshold: h.Config.CompressionThreshold,
+		writeWait:            h.Config.WriteWait,
+	}
+
+	// The first frame of a client must be CONNECT, heart-beats before it are skipped.
+	var f *stomp.Frame
+	for f == nil || f.Command == "" {
+		if f, err = stomp.ReadFrame(br, int(h.Config.ReadLimit)); err != nil {
+			h.logger.WithField("remote_addr", r.RemoteAddr).WithError(err).Info("could not read stomp connect frame")
+			return
+		}
+	}
+	if f.Command != stomp.CONNECT && f.Command != stomp.STOMP {
+		h.stompError(sess, f.Get("receipt"), "first frame must be CONNECT", nil)
+		return
+	}
+	if !acceptsStompVersion(f.Get("accept-version")) {
+		h.stompError(sess, "", "supported protocol version is "+stomp.Version, nil)
+		return
+	}
+	// TODO Authenticate user with the passcode.
+	sess.username = f.Get("login")
+	if sess.username == "" {
+		sess.username = r.URL.Query().Get("username")
+	}
+	if sess.username == "" {
+		h.stompError(sess, "", "login cannot be empty", nil)
+		return
+	}
+
+	// Server sends and wants heart-beats every ping interval, it sends them less often to clients that ask
+	// for a longer interval and waits one and a half interval of the client for its heart-beats.
+	cx, cy := parseHeartBeat(f.Get("heart-beat"))
+	interval := h.Config.PingInterval
+	wait := h.Config.PongWait
+	if cx > 0 {
+		if d := maxDuration(cx, interval) * 3 / 2; d > wait {
+			wait = d
+		}
+	}
+	sc := &stompConn{
+		sess: sess,
+		id:   strconv.FormatUint(atomic.AddUint64(&h.connSeq, 1), 10),
+		out:  make(chan *stompDelivery, h.subscriptionBufferSize),
+		subs: make(map[string]*stompSubscription),
+	}
+	connected := stomp.NewFrame(stomp.CONNECTED,
+		"version", stomp.Version,
+		"heart-beat", fmt.Sprintf("%d,%d", interval.Milliseconds(), interval.Milliseconds()),
+		"server", "websub",
+		"session", sc.id,
+	)
+	if err := sess.write(websocket.TextMessage, connected.Encode()); err != nil {
+		h.logger.WithField("username", sess.username).WithError(err).Error("error while sending connected frame")
+		return
+	}
+	h.logger.WithField("username", sess.username).Info("stomp connection created for user")
+	defer h.logger.WithField("username", sess.username).Info("stomp connection closed")
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(wait))
+	})
+
+	untrack := h.track(&ConnectionInfo{
+		ID:          sc.id,
+		Username:    sess.username,
+		Tenant:      t.Label(),
+		Topics:      []string{},
+		Protocol:    StompSubprotocol,
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: time.Now(),
+	})
+	defer untrack()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer func() {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		for _, s := range sc.subs {
+			s.cancel()
+		}
+	}()
+
+	// Heart-beats are sent when client wants them, otherwise websocket pings keep the connection alive.
+	heartbeats := cy > 0
+	if heartbeats {
+		interval = maxDuration(interval, cy)
+	}
+	pingTicker := time.NewTicker(interval)
+	defer pingTicker.Stop()
+	go h.stompWriter(ctx, sc, pingTicker.C, heartbeats)
+	h.stompReader(ctx, sc, br, wait)
+}
+
+// stompReader reads frames of a connection until it's closed or a frame is rejected with an ERROR frame.
+func (h *SockHub) stompReader(ctx context.Context, sc *stompConn, br *bufio.Reader, wait time.Duration) {
+	sess := sc.sess
+	for {
+		if err := sess.conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+			h.logger.WithField("error", err.Error()).Error("error while setting read deadline")
+			return
+		}
+		f, err := stomp.ReadFrame(br, int(h.Config.ReadLimit))
+		if err == stomp.ErrMalformed {
+			h.stompError(sess, "", err.Error(), nil)
+			return
+		}
+		if err != nil {
+			h.logger.WithField("username", sess.username).WithError(err).Debug("stomp connection is closed")
+			return
+		}
+		receipt := f.Get("receipt")
+		switch f.Command {
+		case "":
+			continue
+		case stomp.SEND:
+			if f.Get("transaction") != "" {
+				h.stompError(sess, receipt, "transactions are not supported", nil)
+				return
+			}
+			topic, _, err := stompTopic(f.Get("destination"))
+			if err != nil {
+				h.stompError(sess, receipt, err.Error(), nil)
+				return
+			}
+			// A rejected publish is reported with an ERROR frame which closes the connection, so no receipt
+			// is written after it.
+			if !h.publish(ctx, sess, &ClientMessage{ID: receipt, Topic: topic, Body: string(f.Body), Retain: f.Get("retain") == "true"}) {
+				return
+			}
+		case stomp.SUBSCRIBE:
+			if err := h.stompSubscribe(ctx, sc, f); err != nil {
+				h.stompError(sess, receipt, err.Error(), nil)
+				return
+			}
+		case stomp.UNSUBSCRIBE:
+			h.stompUnsubscribe(sc, f.Get("id"))
+		case stomp.ACK, stomp.NACK:
+			h.stompAcknowledge(ctx, sc, f)
+		case stomp.DISCONNECT:
+			h.stompReceipt(sess, receipt)
+			return
+		case stomp.BEGIN, stomp.COMMIT, stomp.ABORT:
+			h.stompError(sess, receipt, "transactions are not supported", nil)
+			return
+		default:
+			h.stompError(sess, receipt, fmt.Sprintf("'%s' is not a valid command", f.Command), nil)
+			return
+		}
+		if err := h.stompReceipt(sess, receipt); err != nil {
+			return
+		}
+	}
+}
+
+// stompSubscribe creates the hub subscription of a SUBSCRIBE frame and passes its messages to the connection,
+// retained messages of the topic are sent to subscriptions without client acknowledgements.
+func (h *SockHub) stompSubscribe(ctx context.Context, sc *stompConn, f *stomp.Frame) error {
+	id := f.Get("id")
+	if id == "" {
+		return errors.New("subscription id cannot be empty")
+	}
+	sc.mu.Lock()
+	_, exists := sc.subs[id]
+	sc.mu.Unlock()
+	if exists {
+		return fmt.Errorf("subscription '%s' already exists", id)
+	}
+	// TODO authorize user access to the topic.
+	topic, prefix, err := stompTopic(f.Get("destination"))
+	if err != nil {
+		return err
+	}
+	sub := &stompSubscription{id: id, topic: topic, prefix: prefix, ack: f.Get("ack")}
+	if sub.ack == "" {
+		sub.ack = stompAutoAck
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	sub.cancel = cancel
+	hubTopic := sc.sess.tenant.Topic(topic)
+	var hs *hub.Subscription
+	switch sub.ack {
+	case stompAutoAck:
+		if h.shared != nil {
+			var unsubscribe func()
+			if hs, unsubscribe, err = h.shared.subscribe(hubTopic); err == nil {
+				sub.cancel = func() {
+					unsubscribe()
+					cancel()
+				}
+			}
+		} else {
+			hs, err = h.Hub.Subscribe(ctx, hubTopic)
+		}
+	case stompClientAck, stompClientIndividualAck:
+		dh, ok := h.Hub.(hub.DurableHub)
+		if !ok {
+			cancel()
+			return errors.New("acknowledgements are not supported by the hub")
+		}
+		sub.acks = newAckTracker(h.ackTimeout, h.maxDeliveries)
+		hs, err = dh.SubscribeFrom(ctx, "", hubTopic)
+	default:
+		cancel()
+		return fmt.Errorf("'%s' is not a valid ack mode", sub.ack)
+	}
+	if err != nil {
+		cancel()
+		return fmt.Errorf("error while subscribing to %s, error: %s", topic, err.Error())
+	}
+
+	sc.mu.Lock()
+	sc.subs[id] = sub
+	sc.mu.Unlock()
+	h.stompTopics(sc)
+	go func() {
+		// retained messages are sent before live ones, so an older retained message doesn't follow a newer one.
+		if sub.acks == nil {
+			for _, msg := range h.retained(ctx, sc.sess, []string{topic}) {
+				select {
+				case sc.out <- &stompDelivery{sub: sub, msg: msg}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		for {
+			select {
+			case msg := <-hs.MessageChannel:
+				select {
+				case sc.out <- &stompDelivery{sub: sub, msg: msg}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// stompUnsubscribe cancels a subscription of the connection.
+func (h *SockHub) stompUnsubscribe(sc *stompConn, id string) {
+	sc.mu.Lock()
+	sub, ok := sc.subs[id]
+	delete(sc.subs, id)
+	sc.mu.Unlock()
+	if !ok {
+		h.logger.WithField("username", sc.sess.username).WithField("id", id).Debug("unsubscribe of unknown subscription")
+		return
+	}
+	sub.cancel()
+	h.stompTopics(sc)
+}
+
+// stompTopics updates topics of the connection in connections of the instance.
+func (h *SockHub) stompTopics(sc *stompConn) {
+	sc.mu.Lock()
+	topics := make([]string, 0, len(sc.subs))
+	for _, s := range sc.subs {
+		topics = append(topics, s.topic)
+	}
+	sc.mu.Unlock()
+	h.setTopics(sc.id, topics)
+}
+
+// stompAcknowledge stops tracking of acknowledged messages and reports them, an ACK or NACK of a client
+// subscription applies to the message and messages that are delivered before it.
+func (h *SockHub) stompAcknowledge(ctx context.Context, sc *stompConn, f *stomp.Frame) {
+	sub, ids := sc.acknowledged(f.Get("id"))
+	if len(ids) == 0 {
+		h.logger.WithField("username", sc.sess.username).WithField("id", f.Get("id")).Debug("acknowledgement of unknown message")
+		return
+	}
+	status := AckStatus
+	if f.Command == stomp.NACK {
+		status = NackStatus
+	}
+	for _, id := range ids {
+		if msg, ok := sub.acks.remove(id); ok {
+			h.report(ctx, sc.sess, msg, status)
+		}
+	}
+}
+
+// delivered records the first delivery of a message of a subscription with acknowledgements.
+func (sc *stompConn) delivered(sub *stompSubscription, msg *hub.Message, now time.Time) {
+	sub.acks.delivered(msg, now)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sub.order = append(sub.order, msg.ID)
+}
+
+// acknowledged removes ids of messages that are acknowledged by an ack header(<subscription>:<message id>)
+// from the delivery order of their subscription and returns them.
+func (sc *stompConn) acknowledged(ack string) (*stompSubscription, []string) {
+	i := strings.LastIndex(ack, ":")
+	if i < 0 {
+		return nil, nil
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sub, ok := sc.subs[ack[:i]]
+	if !ok || sub.acks == nil {
+		return nil, nil
+	}
+	for j, id := range sub.order {
+		if id != ack[i+1:] {
+			continue
+		}
+		if sub.ack == stompClientAck {
+			ids := sub.order[:j+1]
+			sub.order = append([]string(nil), sub.order[j+1:]...)
+			return sub, ids
+		}
+		sub.order = append(sub.order[:j:j], sub.order[j+1:]...)
+		return sub, []string{id}
+	}
+	return nil, nil
+}
+
+// forget removes a message that is not tracked anymore from the delivery order of its subscription.
+func (sc *stompConn) forget(sub *stompSubscription, id string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for j, o := range sub.order {
+		if o == id {
+			sub.order = append(sub.order[:j:j], sub.order[j+1:]...)
+			return
+		}
+	}
+}
+
+// stompWriter writes messages of subscriptions, heart-beats or pings on each tick of ping and redeliveries of
+// unacknowledged messages.
+func (h *SockHub) stompWriter(ctx context.Context, sc *stompConn, ping <-chan time.Time, heartbeats bool) {
+	sess := sc.sess
+	redeliver := time.NewTicker(h.ackTimeout / 2)
+	defer redeliver.Stop()
+	for {
+		select {
+		case d := <-sc.out:
+			msg, ok := h.outbound(ctx, sess, d.msg)
+			if !ok {
+				continue
+			}
+			if d.sub.acks != nil {
+				sc.delivered(d.sub, msg, time.Now())
+			}
+			if err := h.stompMessage(sc, d.sub, msg); err != nil {
+				h.logger.WithField("error", err).Error("error while sending message to user")
+				return
+			}
+		case <-ping:
+			var err error
+			if heartbeats {
+				err = sess.write(websocket.TextMessage, []byte("\n"))
+			} else {
+				err = sess.write(websocket.PingMessage, []byte{})
+			}
+			if err != nil {
+				h.logger.WithField("error", err.Error()).Error("error while sending heart-beat")
+				return
+			}
+		case now := <-redeliver.C:
+			sc.mu.Lock()
+			subs := make([]*stompSubscription, 0, len(sc.subs))
+			for _, s := range sc.subs {
+				if s.acks != nil {
+					subs = append(subs, s)
+				}
+			}
+			sc.mu.Unlock()
+			for _, s := range subs {
+				msgs, exhausted := s.acks.expired(now)
+				for _, msg := range exhausted {
+					sc.forget(s, msg.ID)
+					h.report(ctx, sess, msg, NackStatus)
+				}
+				for _, msg := range msgs {
+					s.acks.delivered(msg, now)
+					if err := h.stompMessage(sc, s, msg); err != nil {
+						h.logger.WithField("error", err).Error("error while redelivering message to user")
+						return
+					}
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stompMessage writes a message of a subscription in a MESSAGE frame, frames that are not valid utf-8 are
+// written in binary messages.
+func (h *SockHub) stompMessage(sc *stompConn, sub *stompSubscription, msg *hub.Message) error {
+	b, err := encodeData(msg.Data)
+	if err != nil {
+		h.logger.WithField("channel", msg.Topic).WithError(err).Error("could not encode message data")
+		return nil
+	}
+	id := msg.ID
+	if id == "" {
+		sc.seq++
+		id = strconv.FormatUint(sc.seq, 10)
+	}
+	contentType := "text/plain"
+	if json.Valid(b) {
+		contentType = "application/json"
+	}
+	f := stomp.NewFrame(stomp.MESSAGE,
+		"subscription", sub.id,
+		"message-id", id,
+		"destination", sub.prefix+msg.Topic,
+		"content-type", contentType,
+	)
+	if sub.acks != nil {
+		f.Header["ack"] = sub.id + ":" + msg.ID
+	}
+	if msg.Retained {
+		f.Header["retained"] = "true"
+	}
+	f.Body = b
+	mt := websocket.TextMessage
+	if !utf8.Valid(b) {
+		mt = websocket.BinaryMessage
+	}
+	return sc.sess.write(mt, f.Encode())
+}
+
+// stompReceipt writes the RECEIPT of a frame when client asks for it.
+func (h *SockHub) stompReceipt(sess *session, receipt string) error {
+	if receipt == "" {
+		return nil
+	}
+	err := sess.write(websocket.TextMessage, stomp.NewFrame(stomp.RECEIPT, "receipt-id", receipt).Encode())
+	if err != nil {
+		h.logger.WithField("username", sess.username).WithError(err).Error("error while sending receipt to user")
+	}
+	return err
+}
+
+// stompReject reports a rejected publish with an ERROR frame, ID of the ErrorFrame is the receipt of the SEND.
+func (h *SockHub) stompReject(sess *session, ef *ErrorFrame) {
+	h.stompError(sess, ef.ID, ef.Error, ef.Details)
+}
+
+// stompError writes an ERROR frame and closes the connection as STOMP requires, details are the json body of
+// the frame.
+func (h *SockHub) stompError(sess *session, receipt, message string, details interface{}) {
+	h.logger.WithField("username", sess.username).WithField("error", message).Info("stomp frame rejected")
+	f := stomp.NewFrame(stomp.ERROR, "message", message)
+	if receipt != "" {
+		f.Header["receipt-id"] = receipt
+	}
+	if details != nil {
+		if b, err := json.Marshal(details); err == nil {
+			f.Header["content-type"] = "application/json"
+			f.Body = b
+		}
+	}
+	if err := sess.write(websocket.TextMessage, f.Encode()); err != nil {
+		h.logger.WithField("username", sess.username).WithError(err).Error("error while sending error frame to user")
+	}
+	_ = sess.conn.Close()
+}
+
+// stompTopic returns the topic of a destination and its prefix, destinations are topics or topics with the
+// /topic/ prefix.
+func stompTopic(destination string) (topic, prefix string, err error) {
+	if strings.HasPrefix(destination, stompTopicPrefix) {
+		topic, prefix = strings.TrimPrefix(destination, stompTopicPrefix), stompTopicPrefix
+	} else if !strings.HasPrefix(destination, "/") {
+		topic = destination
+	}
+	if topic == "" {
+		return "", "", fmt.Errorf("'%s' is not a valid destination, destinations are topics or %s<topic>", destination, stompTopicPrefix)
+	}
+	return topic, prefix, nil
+}
+
+// acceptsStompVersion reports whether the accept-version header of CONNECT has the supported version.
+func acceptsStompVersion(versions string) bool {
+	for _, v := range strings.Split(versions, ",") {
+		if strings.TrimSpace(v) == stomp.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeartBeat parses the heart-beat header of CONNECT, cx is the interval that client sends heart-beats
+// and cy is the interval it wants to receive them, zero means no heart-beats.
+func parseHeartBeat(header string) (cx, cy time.Duration) {
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	x, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	y, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || x < 0 || y < 0 {
+		return 0, 0
+	}
+	return time.Duration(x) * time.Millisecond, time.Duration(y) * time.Millisecond
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/stomp"
+	"github.com/stretchr/testify/assert"
+)
+
+// stompClient is a test client that writes and reads stomp frames of a connection.
+type stompClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+	r    *bufio.Reader
+}
+
+func dialStomp(t *testing.T, ts *testServer, headers ...string) (*stompClient, *stomp.Frame) {
+	d := &websocket.Dialer{Subprotocols: []string{"v10.stomp", "v11.stomp", StompSubprotocol}}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	assert.Equal(t, StompSubprotocol, conn.Subprotocol())
+	c := &stompClient{t: t, conn: conn, r: bufio.NewReader(&messageStream{conn: conn})}
+	c.write(stomp.NewFrame(stomp.CONNECT, headers...))
+	return c, c.read()
+}
+
+func (c *stompClient) write(f *stomp.Frame) {
+	assert.NoError(c.t, c.conn.WriteMessage(websocket.TextMessage, f.Encode()))
+}
+
+// read reads the next frame that is not a heart-beat.
+func (c *stompClient) read() *stomp.Frame {
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		f, err := stomp.ReadFrame(c.r, 0)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if f.Command != "" {
+			return f
+		}
+	}
+}
+
+func TestSockHub_Stomp(t *testing.T) {
+	ts := newTestServer(t, Configuration{AckTopic: "acks"})
+	ts.hub.Config.StreamMaxLen = 100
+	ctx := context.Background()
+
+	c, f := dialStomp(t, ts, "accept-version", "1.1,1.2", "login", "alice", "heart-beat", "0,0")
+	assert.Equal(t, stomp.CONNECTED, f.Command)
+	assert.Equal(t, stomp.Version, f.Get("version"))
+
+	c.write(stomp.NewFrame(stomp.SUBSCRIBE, "id", "sub-0", "destination", "/topic/orders.*", "receipt", "r1"))
+	assert.Equal(t, stomp.NewFrame(stomp.RECEIPT, "receipt-id", "r1"), c.read())
+	assert.NoError(t, ts.hub.Publish(ctx, "orders.created", map[string]int{"id": 1}))
+	f = c.read()
+	assert.Equal(t, stomp.MESSAGE, f.Command)
+	assert.Equal(t, "sub-0", f.Get("subscription"))
+	assert.Equal(t, "/topic/orders.created", f.Get("destination"))
+	assert.Equal(t, "application/json", f.Get("content-type"))
+	assert.Equal(t, `{"id":1}`, string(f.Body))
+
+	// Messages that are sent by stomp users are received by websocket users.
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=payments", "payments")
+	c.write(&stomp.Frame{Command: stomp.SEND, Header: map[string]string{"destination": "payments", "receipt": "r2"}, Body: []byte("paid")})
+	assert.Equal(t, stomp.NewFrame(stomp.RECEIPT, "receipt-id", "r2"), c.read())
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "paid", string(b))
+
+	// Acknowledgements of client-individual subscriptions are reported to the ack topic.
+	reports, err := ts.hub.Subscribe(ctx, "acks")
+	assert.NoError(t, err)
+	c.write(stomp.NewFrame(stomp.SUBSCRIBE, "id", "sub-1", "destination", "jobs", "ack", "client-individual", "receipt", "r3"))
+	assert.Equal(t, stomp.NewFrame(stomp.RECEIPT, "receipt-id", "r3"), c.read())
+	cs := ts.sh.Connections("alice")
+	if assert.Len(t, cs, 1) {
+		assert.Equal(t, StompSubprotocol, cs[0].Protocol)
+		assert.Equal(t, []string{"jobs", "orders.*"}, cs[0].Topics)
+	}
+	assert.NoError(t, ts.hub.Publish(ctx, "jobs", "job1"))
+	f = c.read()
+	assert.Equal(t, "sub-1", f.Get("subscription"))
+	assert.Equal(t, "sub-1:"+f.Get("message-id"), f.Get("ack"))
+	assert.Equal(t, "job1", string(f.Body))
+	c.write(stomp.NewFrame(stomp.ACK, "id", f.Get("ack")))
+	select {
+	case msg := <-reports.MessageChannel:
+		assert.Equal(t, map[string]interface{}{"id": f.Get("message-id"), "topic": "jobs", "username": "alice", "status": AckStatus}, msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("ack report is not published")
+	}
+
+	c.write(stomp.NewFrame(stomp.UNSUBSCRIBE, "id", "sub-0", "receipt", "r4"))
+	assert.Equal(t, stomp.NewFrame(stomp.RECEIPT, "receipt-id", "r4"), c.read())
+	assert.Equal(t, []string{"jobs"}, ts.sh.Connections("alice")[0].Topics)
+
+	// Invalid frames are rejected with an ERROR frame and the connection is closed.
+	c.write(&stomp.Frame{Command: stomp.SEND, Header: map[string]string{"destination": "/queue/jobs", "receipt": "r5"}})
+	f = c.read()
+	assert.Equal(t, stomp.ERROR, f.Command)
+	assert.Equal(t, "r5", f.Get("receipt-id"))
+	_, err = stomp.ReadFrame(c.r, 0)
+	assert.Error(t, err)
+}
+
+func TestSockHub_StompSendFailure(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	c, f := dialStomp(t, ts, "accept-version", "1.2", "login", "alice")
+	assert.Equal(t, stomp.CONNECTED, f.Command)
+
+	// A SEND that the hub doesn't accept is answered with an ERROR frame instead of its receipt.
+	ts.redis.SetError("hub is down")
+	c.write(&stomp.Frame{Command: stomp.SEND, Header: map[string]string{"destination": "payments", "receipt": "r1"}, Body: []byte("paid")})
+	f = c.read()
+	assert.Equal(t, stomp.ERROR, f.Command)
+	assert.Equal(t, "r1", f.Get("receipt-id"))
+	assert.Equal(t, "message could not be published", f.Get("message"))
+	_, err := stomp.ReadFrame(c.r, 0)
+	assert.Error(t, err)
+}
+
+func TestSockHub_StompRetained(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	ts.sh.Hub = &retainingHub{RedisHub: ts.hub, live: "new"}
+	assert.NoError(t, ts.hub.PublishRetained(context.Background(), "news", "old"))
+	c, f := dialStomp(t, ts, "accept-version", "1.2", "login", "alice")
+	assert.Equal(t, stomp.CONNECTED, f.Command)
+
+	// The retained message is sent before live messages that are published while it's read.
+	c.write(stomp.NewFrame(stomp.SUBSCRIBE, "id", "sub-0", "destination", "news"))
+	for _, body := range []string{"old", "new"} {
+		f = c.read()
+		assert.Equal(t, stomp.MESSAGE, f.Command)
+		assert.Equal(t, body, string(f.Body))
+	}
+}
+
+func TestSockHub_StompConnect(t *testing.T) {
+	ts := newTestServer(t, Configuration{})
+	_, f := dialStomp(t, ts, "accept-version", "1.0,1.1", "login", "alice")
+	assert.Equal(t, stomp.ERROR, f.Command)
+	_, f = dialStomp(t, ts, "accept-version", "1.2")
+	assert.Equal(t, stomp.NewFrame(stomp.ERROR, "message", "login cannot be empty"), f)
+
+	// Websocket users who don't offer the subprotocol are not affected.
+	conn := ts.dial(t, websocket.DefaultDialer, "username=john&topics=news", "news")
+	assert.Equal(t, "", conn.Subprotocol())
+}
+
+func TestStompConn_Acknowledged(t *testing.T) {
+	sc := &stompConn{subs: make(map[string]*stompSubscription)}
+	client := &stompSubscription{id: "c", ack: stompClientAck, acks: newAckTracker(time.Minute, 5)}
+	individual := &stompSubscription{id: "i", ack: stompClientIndividualAck, acks: newAckTracker(time.Minute, 5)}
+	sc.subs["c"], sc.subs["i"] = client, individual
+	now := time.Now()
+	for _, id := range []string{"1", "2", "3"} {
+		sc.delivered(client, &hub.Message{ID: id}, now)
+		sc.delivered(individual, &hub.Message{ID: id}, now)
+	}
+
+	sub, ids := sc.acknowledged("c:2")
+	assert.Equal(t, client, sub)
+	assert.Equal(t, []string{"1", "2"}, ids)
+	assert.Equal(t, []string{"3"}, client.order)
+
+	sub, ids = sc.acknowledged("i:2")
+	assert.Equal(t, individual, sub)
+	assert.Equal(t, []string{"2"}, ids)
+	assert.Equal(t, []string{"1", "3"}, individual.order)
+
+	sc.forget(individual, "1")
+	assert.Equal(t, []string{"3"}, individual.order)
+	_, ids = sc.acknowledged("i:2")
+	assert.Empty(t, ids)
+	_, ids = sc.acknowledged("x:3")
+	assert.Empty(t, ids)
+}
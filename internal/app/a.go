@@ -3,8 +3,12 @@ package app
 import (
 	"context"
 	"fmt"
+	"github.com/mammadmodi/websub/internal/api/inbound"
+	"github.com/mammadmodi/websub/internal/api/schema"
+	"github.com/mammadmodi/websub/internal/api/webhook"
 	"github.com/mammadmodi/websub/internal/api/websocket"
-	//"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/mammadmodi/websub/pkg/tlsconfig"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"net/http"
 )
@@ -24,20 +28,49 @@ type App struct {
 	Config  *Configs
 	Logger  *logrus.Logger
 	SockHub *websocket.SockHub
+	// Webhooks is nil when webhook dispatcher is not enabled.
+	Webhooks *webhook.Dispatcher
+	// Hooks is nil when inbound webhooks are not enabled.
+	Hooks *inbound.Receiver
+	// Schemas is nil when schema validation is not enabled.
+	Schemas *schema.Registry
 
 	server *http.Server
 }
 
 // Start runs api server in background
 func (a *App) Start(ctx context.Context) error {
+	if a.Webhooks != nil {
+		if err := a.Webhooks.Start(ctx); err != nil {
+			return err
+		}
+	}
+
 	// running http server
 	mux := a.initMux()
 	a.server = &http.Server{
 		Addr:    fmt.Sprintf("%s:%v", a.Config.Addr, a.Config.Port),
 		Handler: mux,
 	}
+	if a.Config.TLS.Enabled {
+		tc, reloader, err := tlsconfig.NewServerConfig(a.Config.TLS)
+		if err != nil {
+			return fmt.Errorf("error while creating tls config, error: %v", err)
+		}
+		a.server.TLSConfig = tc
+		go reloader.Watch(ctx, a.Config.TLS.ReloadInterval, func(err error) {
+			a.Logger.WithError(err).Error("error while reloading tls certificate")
+		})
+	}
 	go func() {
-		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if a.server.TLSConfig != nil {
+			// Certificate is provided by GetCertificate of tls config.
+			err = a.server.ListenAndServeTLS("", "")
+		} else {
+			err = a.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			a.Logger.Panicf("error while running gin http server, error: %v", err)
 		}
 	}()
@@ -49,6 +82,10 @@ func (a *App) Stop(ctx context.Context) {
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), a.Config.GracefulTimeout)
 	defer cancel()
 
+	if a.Webhooks != nil {
+		a.Webhooks.Stop()
+	}
+
 	if err := a.server.Shutdown(ctxWithTimeout); err != nil {
 		a.Logger.Errorf("failed to gracefully shutdown the http server, %s", err)
 	} else {
@@ -63,6 +100,19 @@ func (a *App) initMux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/socket/form", a.Home)
 	mux.HandleFunc("/socket/connect", a.SockHub.Connect)
+	mux.HandleFunc("/mqtt", a.SockHub.MQTT)
+	mux.HandleFunc("/socket/connections", a.cors("connections", a.SockHub.ConnectionsAdmin))
+	mux.HandleFunc("/socket/presence", a.cors("presence", a.SockHub.PresenceAdmin))
+	if a.Webhooks != nil {
+		mux.HandleFunc("/webhooks", a.cors("webhooks", a.Webhooks.Admin))
+	}
+	if a.Hooks != nil {
+		mux.HandleFunc("/hooks/", a.cors("hooks", a.Hooks.Hook))
+	}
+	if a.Schemas != nil {
+		mux.HandleFunc("/schemas", a.cors("schemas", a.Schemas.Admin))
+	}
+	mux.Handle("/metrics", promhttp.Handler())
 
 	return mux
 }
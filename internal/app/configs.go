@@ -3,28 +3,44 @@ package app
 import (
 	"fmt"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/mammadmodi/websub/internal/api/inbound"
+	"github.com/mammadmodi/websub/internal/api/schema"
+	"github.com/mammadmodi/websub/internal/api/webhook"
 	"github.com/mammadmodi/websub/internal/api/websocket"
 	"github.com/mammadmodi/websub/pkg/logger"
 	"github.com/mammadmodi/websub/pkg/nats"
 	"github.com/mammadmodi/websub/pkg/redis"
+	"github.com/mammadmodi/websub/pkg/tenant"
+	"github.com/mammadmodi/websub/pkg/tlsconfig"
 	"time"
 )
 
 const (
-	RedisHub = "redis_hub"
-	NatsHub  = "nats_hub"
+	RedisHub  = "redis_hub"
+	NatsHub   = "nats_hub"
+	MemoryHub = "memory_hub"
 )
 
 // Configs is struct that contains all configuration of all parts of application
 type Configs struct {
-	SockHubConfig   websocket.Configuration
-	RedisConfigs    redis.Configs
-	NatsConfigs     nats.Configs
-	LoggingConfigs  logger.Configuration
-	HubDriver       string        `default:"redis_hub" split_words:"true"`
-	Addr            string        `default:"127.0.0.1"`
-	Port            int           `default:"8379"`
-	GracefulTimeout time.Duration `default:"15s" split_words:"true"`
+	SockHubConfig     websocket.Configuration
+	WebhookConfigs    webhook.Configuration
+	InboundConfigs    inbound.Configuration
+	SchemaConfigs     schema.Configuration
+	TenantConfigs     tenant.Configuration
+	RedisConfigs      redis.Configs
+	NatsConfigs       nats.Configs
+	LoggingConfigs    logger.Configuration
+	HubDriver         string        `default:"redis_hub" split_words:"true"`
+	HubCodec          string        `default:"json" split_words:"true"`
+	HubStream         string        `default:"websub" split_words:"true"`
+	HubStreamMaxLen   int64         `default:"0" split_words:"true"`
+	HubStreamSubjects []string      `split_words:"true"`
+	HubRetainStream   string        `split_words:"true"`
+	Addr              string        `default:"127.0.0.1"`
+	Port              int           `default:"8379"`
+	GracefulTimeout   time.Duration `default:"15s" split_words:"true"`
+	TLS               tlsconfig.Configs
 }
 
 // NewConfiguration returns a configuration that is loaded with environment variables
@@ -43,6 +59,44 @@ func NewConfiguration() (*Configs, error) {
 	}
 	config.SockHubConfig = sockHubConfig
 
+	// loading webhook configs
+	webhookConfigs := webhook.Configuration{}
+	err = envconfig.Process("websub_webhook", &webhookConfigs)
+	if err != nil {
+		return nil, fmt.Errorf("error while processing webhook configs from env variables, error: %v", err)
+	}
+	config.WebhookConfigs = webhookConfigs
+
+	// loading inbound webhook configs and routes
+	inboundConfigs := inbound.Configuration{}
+	err = envconfig.Process("websub_inbound", &inboundConfigs)
+	if err != nil {
+		return nil, fmt.Errorf("error while processing inbound webhook configs from env variables, error: %v", err)
+	}
+	if inboundConfigs.Enabled {
+		inboundConfigs.Routes, err = inbound.LoadRoutes(inboundConfigs.RoutesFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading inbound webhook routes, error: %v", err)
+		}
+	}
+	config.InboundConfigs = inboundConfigs
+
+	// loading topic schema configs
+	schemaConfigs := schema.Configuration{}
+	err = envconfig.Process("websub_schema", &schemaConfigs)
+	if err != nil {
+		return nil, fmt.Errorf("error while processing schema configs from env variables, error: %v", err)
+	}
+	config.SchemaConfigs = schemaConfigs
+
+	// loading tenant configs
+	tenantConfigs := tenant.Configuration{}
+	err = envconfig.Process("websub_tenant", &tenantConfigs)
+	if err != nil {
+		return nil, fmt.Errorf("error while processing tenant configs from env variables, error: %v", err)
+	}
+	config.TenantConfigs = tenantConfigs
+
 	// loading logging configs
 	loggingConfig := logger.Configuration{}
 	err = envconfig.Process("websub_logging", &loggingConfig)
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"github.com/mammadmodi/websub/pkg/origin"
+	"net/http"
+)
+
+// corsMaxAge is the duration(in seconds) that browsers can cache preflight responses.
+const corsMaxAge = "600"
+
+// cors handles CORS of an http api with the origin policy of websocket connections, requests
+// of disallowed origins are rejected.
+func (a *App) cors(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o := r.Header.Get("Origin")
+		if o == "" {
+			next(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !a.Config.SockHubConfig.AllowedOrigins.AllowedOrigin(o, r.Host) {
+			origin.RejectedOrigins.WithLabelValues(endpoint).Inc()
+			a.Logger.WithField("origin", o).WithField("endpoint", endpoint).Warn("request rejected because of its origin")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", o)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
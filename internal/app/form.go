@@ -4,16 +4,29 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 )
 
 // Home is a http handler that renders a html form that can be create web socket
 // connection with the websocket server.
 func (a *App) Home(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("username")
-	topics := r.URL.Query().Get("topics")
-	urlFormat := "ws://%s:%d/socket/connect?username=%s&topics=%s"
-	socketUrl := fmt.Sprintf(urlFormat, a.Config.Addr, a.Config.Port, username, topics)
-	if err := homeTemplate.Execute(w, socketUrl); err != nil {
+	q := url.Values{}
+	q.Set("username", r.URL.Query().Get("username"))
+	q.Set("topics", r.URL.Query().Get("topics"))
+	socketUrl := url.URL{
+		Scheme:   "ws",
+		Host:     r.Host,
+		Path:     "/socket/connect",
+		RawQuery: q.Encode(),
+	}
+	// Scheme is wss when the form is served over tls, by websub or by a tls terminating proxy.
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		socketUrl.Scheme = "wss"
+	}
+	if socketUrl.Host == "" {
+		socketUrl.Host = fmt.Sprintf("%s:%d", a.Config.Addr, a.Config.Port)
+	}
+	if err := homeTemplate.Execute(w, socketUrl.String()); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/mammadmodi/websub/pkg/nats"
+	"github.com/mammadmodi/websub/pkg/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// NewHub creates the hub of HubDriver with its client configs, redis hubs of sentinel mode resubscribe on
+// master switches until ctx is done.
+func NewHub(ctx context.Context, c *Configs, l *logrus.Logger) (hub.Hub, error) {
+	codec, err := hub.NewCodec(c.HubCodec)
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing hub codec, error: %s", err.Error())
+	}
+
+	switch c.HubDriver {
+	case RedisHub:
+		rc, err := redis.NewClient(c.RedisConfigs)
+		if err != nil {
+			return nil, fmt.Errorf("error while initializing redis client, error: %s", err.Error())
+		}
+		if _, err = rc.Ping(ctx).Result(); err != nil {
+			return nil, fmt.Errorf("cannot get ping response with redis client, error: %s", err.Error())
+		}
+
+		rhc := &hub.RedisHubConfig{Codec: codec, Sharded: c.RedisConfigs.ShardedPubSub, RetainPrefix: c.HubStream + ":retained:"}
+		if c.HubStreamMaxLen > 0 {
+			rhc.StreamMaxLen = c.HubStreamMaxLen
+			rhc.StreamPrefix = c.HubStream + ":"
+		}
+		rh := hub.NewRedisHub(rc, l, rhc)
+		if c.RedisConfigs.Mode == redis.Sentinel {
+			// subscriptions follow master switches of sentinels as soon as they happen.
+			go func() {
+				err := redis.WatchFailover(ctx, c.RedisConfigs, func(addr string) {
+					l.WithField("master", addr).Warn("redis master is switched, resubscribing")
+					rh.Resubscribe()
+				})
+				if err != nil {
+					l.WithError(err).Error("could not watch redis failover")
+				}
+			}()
+		}
+		return rh, nil
+	case NatsHub:
+		nc, err := nats.NewClientWithLogger(c.NatsConfigs, l)
+		if err != nil {
+			return nil, fmt.Errorf("error while initializing nats client, error: %s", err.Error())
+		}
+		nhc := &hub.NatsHubConfig{Codec: codec, RetainStream: c.HubRetainStream}
+		if c.HubStreamMaxLen > 0 {
+			nhc.Stream = c.HubStream
+		}
+		nh := hub.NewNatsHub(nc, l, nhc)
+		if c.HubStreamMaxLen > 0 {
+			if err := nh.EnsureStream(c.HubStreamSubjects, c.HubStreamMaxLen); err != nil {
+				return nil, fmt.Errorf("error while initializing nats stream, error: %s", err.Error())
+			}
+		}
+		if c.HubRetainStream != "" {
+			if err := nh.EnsureRetainStream(); err != nil {
+				return nil, fmt.Errorf("error while initializing nats retain stream, error: %s", err.Error())
+			}
+		}
+		return nh, nil
+	case MemoryHub:
+		// messages are only delivered to connections of this instance.
+		return hub.NewMemoryHub(l, &hub.MemoryHubConfig{Codec: codec}), nil
+	default:
+		return nil, fmt.Errorf("'%s' is not a valid hub driver", c.HubDriver)
+	}
+}
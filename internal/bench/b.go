@@ -0,0 +1,414 @@
+// Package bench is a load generator of websub. It opens websocket connections that subscribe to a
+// distribution of topics, publishes messages at a target rate and reports fan-out latency, delivery
+// loss and resource usage of the server.
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mammadmodi/websub/pkg/client"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+// Distributions of connections between topics.
+const (
+	// Uniform spreads connections evenly between topics.
+	Uniform = "uniform"
+	// Zipf subscribes most connections to a few hot topics.
+	Zipf = "zipf"
+)
+
+// zipfS is the exponent of zipf distribution.
+const zipfS = 1.2
+
+// Config is configuration of a bench.
+type Config struct {
+	// URL is the websocket endpoint of the server.
+	URL   string
+	Token string
+	// MetricsURL is the prometheus endpoint of the server that resource usage is scraped from, resources
+	// of the server are not reported when it's empty.
+	MetricsURL   string
+	Connections  int
+	Topics       int
+	Distribution string
+	Messages     int
+	// Rate is number of published messages per second.
+	Rate float64
+	// PayloadSize is size of padding that is added to messages.
+	PayloadSize int
+	// DialConcurrency is number of connections that are opened concurrently.
+	DialConcurrency int
+	// Drain is duration that bench waits for deliveries after the last publish.
+	Drain time.Duration
+}
+
+// Publisher publishes bench messages, hubs and clients are publishers.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, data interface{}) error
+}
+
+// Latency is the fan-out latency distribution in milliseconds.
+type Latency struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p999_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+// Resources is resource usage of a process.
+type Resources struct {
+	Goroutines  int     `json:"goroutines"`
+	HeapBytes   uint64  `json:"heap_bytes"`
+	Connections float64 `json:"connections,omitempty"`
+}
+
+// Report is the result of a bench.
+type Report struct {
+	Connections       int     `json:"connections"`
+	FailedConnections int     `json:"failed_connections"`
+	ConnectSeconds    float64 `json:"connect_seconds"`
+	Topics            int     `json:"topics"`
+	Distribution      string  `json:"distribution"`
+	Messages          int     `json:"messages"`
+	PublishRate       float64 `json:"publish_rate"`
+	Expected          int     `json:"expected"`
+	Received          int     `json:"received"`
+	Lost              int     `json:"lost"`
+	Duplicates        int     `json:"duplicates"`
+	LossRatio         float64 `json:"loss_ratio"`
+	Latency           Latency `json:"latency"`
+	// Client is resource usage of the bench process after connections are opened, it includes the
+	// server when the server runs in the same process.
+	Client Resources `json:"client"`
+	// Server is resource usage of the server after connections are opened.
+	Server *Resources `json:"server,omitempty"`
+}
+
+// payload is the message of bench, its send time is used to measure fan-out latency.
+type payload struct {
+	Seq     int    `json:"seq"`
+	Sent    int64  `json:"sent"`
+	Padding string `json:"padding,omitempty"`
+}
+
+// subscriber is state of a bench connection.
+type subscriber struct {
+	client    *client.Client
+	received  []bool
+	latencies []time.Duration
+	dups      int
+}
+
+// Run runs a bench with connections to the server and publishes with pub, it stops early when ctx is done.
+func Run(ctx context.Context, config Config, pub Publisher, logger *logrus.Logger) (*Report, error) {
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(ioutil.Discard)
+	}
+	if config.Connections <= 0 || config.Topics <= 0 || config.Messages <= 0 || config.Rate <= 0 {
+		return nil, fmt.Errorf("connections, topics, messages and rate must be positive")
+	}
+	if config.DialConcurrency <= 0 {
+		config.DialConcurrency = 50
+	}
+	if config.Distribution == "" {
+		config.Distribution = Uniform
+	}
+	assignment, err := assign(config.Connections, config.Topics, config.Distribution)
+	if err != nil {
+		return nil, err
+	}
+	subscribers := make([]int, config.Topics)
+	for _, t := range assignment {
+		subscribers[t]++
+	}
+
+	r := &Report{Connections: config.Connections, Topics: config.Topics, Distribution: config.Distribution, Messages: config.Messages}
+	start := time.Now()
+	subs, failed := connect(ctx, config, assignment, logger)
+	r.ConnectSeconds = time.Since(start).Seconds()
+	r.FailedConnections = failed
+	defer func() {
+		for _, s := range subs {
+			if s != nil {
+				_ = s.client.Close()
+			}
+		}
+	}()
+	for i, s := range subs {
+		if s == nil {
+			subscribers[assignment[i]]--
+		}
+	}
+
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, s := range subs {
+		if s == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(s *subscriber) {
+			defer wg.Done()
+			s.receive(rctx)
+		}(s)
+	}
+
+	// Subscriptions of the server are created in background after connections are opened.
+	time.Sleep(500 * time.Millisecond)
+	r.Client = processResources()
+	if config.MetricsURL != "" {
+		if r.Server, err = scrape(ctx, config.MetricsURL); err != nil {
+			logger.WithError(err).Warn("could not scrape resources of the server")
+		}
+	}
+
+	padding := strings.Repeat("x", config.PayloadSize)
+	interval := time.Duration(float64(time.Second) / config.Rate)
+	start = time.Now()
+	published := 0
+	for i := 0; i < config.Messages; i++ {
+		// Messages are paced by their schedule, so slow publishes don't lower the rate.
+		if d := time.Until(start.Add(time.Duration(i) * interval)); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		topic := Topic(i % config.Topics)
+		if err := pub.Publish(ctx, topic, &payload{Seq: i, Sent: time.Now().UnixNano(), Padding: padding}); err != nil {
+			return nil, fmt.Errorf("error while publishing message %d, error: %s", i, err.Error())
+		}
+		published++
+		r.Expected += subscribers[i%config.Topics]
+	}
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+		r.PublishRate = float64(published) / elapsed
+	}
+	select {
+	case <-time.After(config.Drain):
+	case <-ctx.Done():
+	}
+	cancel()
+	wg.Wait()
+
+	var latencies []time.Duration
+	for _, s := range subs {
+		if s == nil {
+			continue
+		}
+		latencies = append(latencies, s.latencies...)
+		r.Duplicates += s.dups
+	}
+	r.Received = len(latencies)
+	r.Lost = r.Expected - r.Received
+	if r.Expected > 0 {
+		r.LossRatio = float64(r.Lost) / float64(r.Expected)
+	}
+	r.Latency = summarize(latencies)
+	return r, nil
+}
+
+// Topic returns name of the i'th bench topic.
+func Topic(i int) string {
+	return fmt.Sprintf("bench.%d", i)
+}
+
+// assign returns the topic index of each connection.
+func assign(conns, topics int, distribution string) ([]int, error) {
+	a := make([]int, conns)
+	switch distribution {
+	case Uniform:
+		for i := range a {
+			a[i] = i % topics
+		}
+	case Zipf:
+		z := rand.NewZipf(rand.New(rand.NewSource(time.Now().UnixNano())), zipfS, 1, uint64(topics-1))
+		for i := range a {
+			a[i] = int(z.Uint64())
+		}
+	default:
+		return nil, fmt.Errorf("'%s' is not a valid distribution, distributions are %s and %s", distribution, Uniform, Zipf)
+	}
+	return a, nil
+}
+
+// connect opens connections concurrently, subscribers of failed connections are nil.
+func connect(ctx context.Context, config Config, assignment []int, logger *logrus.Logger) ([]*subscriber, int) {
+	subs := make([]*subscriber, len(assignment))
+	sem := make(chan struct{}, config.DialConcurrency)
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+	for i, t := range assignment {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i, t int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			c, err := client.Connect(ctx, client.Config{
+				URL:        config.URL,
+				Username:   fmt.Sprintf("bench-%d", i),
+				Topics:     []string{Topic(t)},
+				Token:      config.Token,
+				BufferSize: 256,
+			}, logger)
+			if err != nil {
+				logger.WithField("connection", i).WithError(err).Warn("could not open bench connection")
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				return
+			}
+			subs[i] = &subscriber{client: c, received: make([]bool, config.Messages)}
+		}(i, t)
+	}
+	wg.Wait()
+	return subs, failed
+}
+
+// receive records latencies of messages until ctx is done.
+func (s *subscriber) receive(ctx context.Context) {
+	for {
+		select {
+		case msg, ok := <-s.client.Messages():
+			if !ok {
+				return
+			}
+			p, ok := decode(msg.Data)
+			if !ok || p.Seq < 0 || p.Seq >= len(s.received) {
+				continue
+			}
+			if s.received[p.Seq] {
+				s.dups++
+				continue
+			}
+			s.received[p.Seq] = true
+			s.latencies = append(s.latencies, time.Since(time.Unix(0, p.Sent)))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// decode decodes a bench payload, payloads that are published through the websocket endpoint are
+// received as json text.
+func decode(data interface{}) (*payload, bool) {
+	var b []byte
+	switch d := data.(type) {
+	case string:
+		b = []byte(d)
+	default:
+		var err error
+		if b, err = json.Marshal(d); err != nil {
+			return nil, false
+		}
+	}
+	p := &payload{}
+	if err := json.Unmarshal(b, p); err != nil || p.Sent == 0 {
+		return nil, false
+	}
+	return p, true
+}
+
+// summarize returns the latency distribution of durations.
+func summarize(ds []time.Duration) Latency {
+	if len(ds) == 0 {
+		return Latency{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	return Latency{
+		Mean: ms(sum / time.Duration(len(ds))),
+		P50:  ms(percentile(ds, 0.5)),
+		P90:  ms(percentile(ds, 0.9)),
+		P99:  ms(percentile(ds, 0.99)),
+		P999: ms(percentile(ds, 0.999)),
+		Max:  ms(ds[len(ds)-1]),
+	}
+}
+
+// percentile returns the p percentile of sorted durations with nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// processResources returns resource usage of the bench process.
+func processResources() Resources {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return Resources{Goroutines: runtime.NumGoroutine(), HeapBytes: m.HeapInuse}
+}
+
+// scrape reads resource usage of the server from its prometheus endpoint.
+func scrape(ctx context.Context, url string) (*Resources, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating metrics request, error: %s", err.Error())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while requesting metrics, error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("metrics responded with status %d, %s", resp.StatusCode, b)
+	}
+	families, err := (&expfmt.TextParser{}).TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing metrics, error: %s", err.Error())
+	}
+	value := func(name string) float64 {
+		f, ok := families[name]
+		if !ok || len(f.GetMetric()) == 0 {
+			return 0
+		}
+		m := f.GetMetric()[0]
+		if m.GetGauge() != nil {
+			return m.GetGauge().GetValue()
+		}
+		return m.GetUntyped().GetValue()
+	}
+	return &Resources{
+		Goroutines:  int(value("go_goroutines")),
+		HeapBytes:   uint64(value("go_memstats_heap_inuse_bytes")),
+		Connections: value("websub_socket_active_connections"),
+	}, nil
+}
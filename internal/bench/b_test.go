@@ -0,0 +1,76 @@
+package bench
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	for _, kind := range []string{MemoryHub, MiniRedisHub} {
+		l, err := StartLocal(kind, nil)
+		if !assert.NoError(t, err, kind) {
+			continue
+		}
+		r, err := Run(context.Background(), Config{
+			URL:          l.URL,
+			MetricsURL:   l.MetricsURL,
+			Connections:  20,
+			Topics:       4,
+			Distribution: Zipf,
+			Messages:     40,
+			Rate:         400,
+			PayloadSize:  64,
+			Drain:        500 * time.Millisecond,
+		}, l.Hub, nil)
+		l.Close()
+		if !assert.NoError(t, err, kind) {
+			continue
+		}
+		assert.Equal(t, 0, r.FailedConnections, kind)
+		assert.Greater(t, r.Expected, 0, kind)
+		assert.Equal(t, r.Expected, r.Received, kind)
+		assert.Equal(t, 0, r.Lost, kind)
+		assert.Equal(t, 0, r.Duplicates, kind)
+		assert.Greater(t, r.Latency.Max, 0.0, kind)
+		assert.LessOrEqual(t, r.Latency.P50, r.Latency.P99, kind)
+		assert.Greater(t, r.Client.Goroutines, 0, kind)
+		if assert.NotNil(t, r.Server, kind) {
+			assert.Greater(t, r.Server.HeapBytes, uint64(0), kind)
+		}
+	}
+
+	_, err := StartLocal("postgres", nil)
+	assert.Error(t, err)
+	_, err = Run(context.Background(), Config{}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestAssign(t *testing.T) {
+	a, err := assign(10, 3, Uniform)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 0, 1, 2, 0, 1, 2, 0}, a)
+
+	a, err = assign(1000, 10, Zipf)
+	assert.NoError(t, err)
+	counts := make([]int, 10)
+	for _, t := range a {
+		counts[t]++
+	}
+	assert.Greater(t, counts[0], counts[9])
+
+	_, err = assign(10, 3, "normal")
+	assert.Error(t, err)
+}
+
+func TestSummarize(t *testing.T) {
+	var ds []time.Duration
+	for i := 100; i >= 1; i-- {
+		ds = append(ds, time.Duration(i)*time.Millisecond)
+	}
+	l := summarize(ds)
+	assert.Equal(t, Latency{Mean: 50.5, P50: 50, P90: 90, P99: 99, P999: 100, Max: 100}, l)
+	assert.Equal(t, Latency{}, summarize(nil))
+}
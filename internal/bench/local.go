@@ -0,0 +1,92 @@
+package bench
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/mammadmodi/websub/internal/api/websocket"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// Hubs of local servers.
+const (
+	MemoryHub    = "memory"
+	MiniRedisHub = "miniredis"
+)
+
+// Local is a websub server that runs in the bench process, so benches don't need a deployment.
+type Local struct {
+	// URL is the websocket endpoint and MetricsURL is the prometheus endpoint of the server.
+	URL        string
+	MetricsURL string
+	// Hub is the hub of the server, messages are published to it.
+	Hub hub.Hub
+
+	server *http.Server
+	redis  *miniredis.Miniredis
+}
+
+// StartLocal starts a server on a random local port with a MemoryHub or a RedisHub of an in process
+// miniredis, the server is configured with WEBSUB_SOCK_* environment variables like websub.
+func StartLocal(kind string, logger *logrus.Logger) (*Local, error) {
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(ioutil.Discard)
+	}
+	l := &Local{}
+	switch kind {
+	case MemoryHub:
+		l.Hub = hub.NewMemoryHub(logger, &hub.MemoryHubConfig{BufferSize: 4096})
+	case MiniRedisHub:
+		s, err := miniredis.Run()
+		if err != nil {
+			return nil, fmt.Errorf("error while running miniredis, error: %s", err.Error())
+		}
+		l.redis = s
+		l.Hub = hub.NewRedisHub(redis.NewClient(&redis.Options{Addr: s.Addr()}), logger, nil)
+	default:
+		return nil, fmt.Errorf("'%s' is not a valid local hub, hubs are %s and %s", kind, MemoryHub, MiniRedisHub)
+	}
+
+	c := websocket.Configuration{}
+	if err := envconfig.Process("websub_sock", &c); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error while processing sockhub configs from env variables, error: %s", err.Error())
+	}
+	sh := websocket.NewSockHub(c, l.Hub, logger)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/socket/connect", sh.Connect)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error while listening on a local port, error: %s", err.Error())
+	}
+	l.server = &http.Server{Handler: mux}
+	go func() {
+		if err := l.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Error("local bench server is stopped")
+		}
+	}()
+	l.URL = fmt.Sprintf("ws://%s/socket/connect", ln.Addr())
+	l.MetricsURL = fmt.Sprintf("http://%s/metrics", ln.Addr())
+	return l, nil
+}
+
+// Close stops the server and its miniredis.
+func (l *Local) Close() {
+	if l.server != nil {
+		_ = l.server.Close()
+	}
+	if l.redis != nil {
+		l.redis.Close()
+	}
+}
@@ -0,0 +1,592 @@
+// Package client is a Go client of websub sockets. It receives messages of topics as hub messages, publishes
+// and requests through the socket, reconnects with jittered backoff when the connection is lost and resumes
+// acknowledged subscriptions from the last received message.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/sirupsen/logrus"
+)
+
+// Types of frames that are exchanged with websub.
+const (
+	publishFrame = "publish"
+	requestFrame = "request"
+	ackFrame     = "ack"
+	nackFrame    = "nack"
+	replyFrame   = "reply"
+	errorFrame   = "error"
+)
+
+var (
+	// ErrClosed is returned by methods of a closed client.
+	ErrClosed = errors.New("client is closed")
+	// ErrDisconnected is returned by requests whose connection is lost before the reply.
+	ErrDisconnected = errors.New("connection is lost before the reply")
+)
+
+// Config is configuration of a Client.
+type Config struct {
+	// URL is the websub socket endpoint, e.g. ws://127.0.0.1:8379/socket/connect.
+	URL      string
+	Username string
+	Topics   []string
+	// Token is sent as a bearer token in Authorization header, Header is sent with connection requests.
+	Token  string
+	Header http.Header
+	// Ack subscribes durably, messages must be acknowledged with Ack or Nack and reconnects resume
+	// from the last received message. LastID is the message that the first connection resumes from.
+	Ack    bool
+	LastID string
+	// Group shares messages of topics between clients of the queue group, GroupToken is the token of the
+	// group on the server.
+	Group      string
+	GroupToken string
+	// Filter is an expression that selects messages on the server, e.g. data.region == "eu".
+	Filter string
+	// PongWait is duration that client waits for a ping of the server before reconnecting, it should be
+	// longer than PingInterval of the server.
+	PongWait time.Duration
+	// WriteWait is timeout of writes that don't have a context deadline.
+	WriteWait time.Duration
+	// MinBackoff and MaxBackoff bound delays between reconnect attempts, delays are doubled after each
+	// failed attempt and jittered.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// BufferSize is capacity of Messages channel.
+	BufferSize int
+	// Dialer dials the connections, websocket.DefaultDialer is used when it's nil.
+	Dialer *websocket.Dialer
+}
+
+// PublishError is sent to Errors channel when websub rejects a published message.
+type PublishError struct {
+	ID      string
+	Topic   string
+	Reason  string
+	Details json.RawMessage
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("publish %s to %s is rejected, %s", e.ID, e.Topic, e.Reason)
+}
+
+// frame is the union of frames that are received from websub.
+type frame struct {
+	Type     string          `json:"type"`
+	ID       string          `json:"id"`
+	Topic    string          `json:"topic"`
+	Data     json.RawMessage `json:"data"`
+	Retained bool            `json:"retained"`
+	Error    string          `json:"error"`
+	Details  json.RawMessage `json:"details"`
+}
+
+// clientMessage is structure of frames that are sent to websub.
+type clientMessage struct {
+	Type   string `json:"type"`
+	ID     string `json:"id,omitempty"`
+	Topic  string `json:"topic,omitempty"`
+	Body   string `json:"body,omitempty"`
+	Retain bool   `json:"retain,omitempty"`
+}
+
+// Client is a websub socket client, its methods are safe for concurrent use.
+type Client struct {
+	config Config
+	logger *logrus.Logger
+	dialer *websocket.Dialer
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	topics []string
+	lastID string
+	// connected is closed while the client is connected.
+	connected   chan struct{}
+	pending     map[string]chan *frame
+	resubscribe bool
+	closed      bool
+
+	writeMu  sync.Mutex
+	seq      uint64
+	messages chan *hub.Message
+	errors   chan error
+	// ctx is cancelled when the client is closed.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// Connect connects to websub and receives messages in background until the client is closed.
+func Connect(ctx context.Context, config Config, logger *logrus.Logger) (*Client, error) {
+	if config.URL == "" || config.Username == "" || len(config.Topics) == 0 {
+		return nil, fmt.Errorf("url, username and topics cannot be empty")
+	}
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(ioutil.Discard)
+	}
+	if config.PongWait == 0 {
+		config.PongWait = 30 * time.Second
+	}
+	if config.WriteWait == 0 {
+		config.WriteWait = 10 * time.Second
+	}
+	if config.MinBackoff == 0 {
+		config.MinBackoff = 500 * time.Millisecond
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 30 * time.Second
+	}
+	if config.BufferSize == 0 {
+		config.BufferSize = 64
+	}
+	c := &Client{
+		config:    config,
+		logger:    logger,
+		dialer:    config.Dialer,
+		topics:    append([]string(nil), config.Topics...),
+		lastID:    config.LastID,
+		connected: make(chan struct{}),
+		pending:   make(map[string]chan *frame),
+		messages:  make(chan *hub.Message, config.BufferSize),
+		errors:    make(chan error, config.BufferSize),
+		stopped:   make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if c.dialer == nil {
+		c.dialer = websocket.DefaultDialer
+	}
+	conn, err := c.dial(ctx)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+	go c.run(conn)
+	return c, nil
+}
+
+// Messages returns the channel of received messages, it's closed when the client is closed. Messages
+// are not read from the connection while the channel is full.
+func (c *Client) Messages() <-chan *hub.Message {
+	return c.messages
+}
+
+// Errors returns the channel of PublishErrors, errors are dropped when the channel is full.
+func (c *Client) Errors() <-chan error {
+	return c.errors
+}
+
+// Topics returns topics of the client.
+func (c *Client) Topics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.topics...)
+}
+
+// Publish publishes data to topic, strings and bytes are published as they are and other values are encoded
+// to json. Rejections of websub are sent to Errors channel.
+func (c *Client) Publish(ctx context.Context, topic string, data interface{}) error {
+	body, err := encode(data)
+	if err != nil {
+		return err
+	}
+	return c.write(ctx, &clientMessage{Type: publishFrame, ID: c.nextID(), Topic: topic, Body: body})
+}
+
+// PublishRetained publishes data to topic and keeps it as the retained message of topic that new subscribers
+// receive first, the retained message of topic is removed when data is nil.
+func (c *Client) PublishRetained(ctx context.Context, topic string, data interface{}) error {
+	var body string
+	if data != nil {
+		var err error
+		if body, err = encode(data); err != nil {
+			return err
+		}
+	}
+	return c.write(ctx, &clientMessage{Type: publishFrame, ID: c.nextID(), Topic: topic, Body: body, Retain: true})
+}
+
+// Request publishes data to topic and waits for the reply until ctx is done or the server's request timeout.
+func (c *Client) Request(ctx context.Context, topic string, data interface{}) (*hub.Message, error) {
+	body, err := encode(data)
+	if err != nil {
+		return nil, err
+	}
+	id := c.nextID()
+	ch := make(chan *frame, 1)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(ctx, &clientMessage{Type: requestFrame, ID: id, Topic: topic, Body: body}); err != nil {
+		return nil, err
+	}
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		if f.Error != "" {
+			return nil, fmt.Errorf("request to %s failed, error: %s", topic, f.Error)
+		}
+		return &hub.Message{Topic: f.Topic, Data: decode(f.Data)}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, ErrClosed
+	}
+}
+
+// Ack acknowledges a message of an Ack client.
+func (c *Client) Ack(ctx context.Context, id string) error {
+	return c.write(ctx, &clientMessage{Type: ackFrame, ID: id})
+}
+
+// Nack rejects a message of an Ack client.
+func (c *Client) Nack(ctx context.Context, id string) error {
+	return c.write(ctx, &clientMessage{Type: nackFrame, ID: id})
+}
+
+// Subscribe adds topics to the client. Subscriptions of websub are created per connection, so the client
+// reconnects with the new topics, Ack clients resume from the last message and other clients may miss
+// messages that are published during the reconnect.
+func (c *Client) Subscribe(topics ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := false
+	for _, t := range topics {
+		if !contains(c.topics, t) {
+			c.topics = append(c.topics, t)
+			changed = true
+		}
+	}
+	return c.reconnectLocked(changed)
+}
+
+// Unsubscribe removes topics from the client and reconnects like Subscribe, at least one topic must remain.
+func (c *Client) Unsubscribe(topics ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var remaining []string
+	for _, t := range c.topics {
+		if !contains(topics, t) {
+			remaining = append(remaining, t)
+		}
+	}
+	if len(remaining) == 0 {
+		return fmt.Errorf("client must be subscribed to at least one topic")
+	}
+	changed := len(remaining) != len(c.topics)
+	c.topics = remaining
+	return c.reconnectLocked(changed)
+}
+
+// reconnectLocked closes the connection to reconnect without backoff.
+func (c *Client) reconnectLocked(changed bool) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if !changed || c.conn == nil {
+		return nil
+	}
+	c.resubscribe = true
+	return c.conn.Close()
+}
+
+// Close closes the connection and stops reconnecting.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.cancel()
+	conn := c.conn
+	c.mu.Unlock()
+
+	var err error
+	if conn != nil {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(c.config.WriteWait))
+		err = conn.Close()
+	}
+	<-c.stopped
+	return err
+}
+
+// dial connects to websub with current topics and last message id.
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u, err := url.Parse(c.config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing websub url, error: %s", err.Error())
+	}
+	c.mu.Lock()
+	q := u.Query()
+	q.Set("username", c.config.Username)
+	q.Set("topics", strings.Join(c.topics, ","))
+	q.Set("frames", "true")
+	if c.config.Ack {
+		q.Set("ack", "true")
+		if c.lastID != "" {
+			q.Set("last_id", c.lastID)
+		}
+	}
+	c.mu.Unlock()
+	if c.config.Group != "" {
+		q.Set("group", c.config.Group)
+		q.Set("group_token", c.config.GroupToken)
+	}
+	if c.config.Filter != "" {
+		q.Set("filter", c.config.Filter)
+	}
+	u.RawQuery = q.Encode()
+	header := c.config.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if c.config.Token != "" {
+		header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+
+	conn, resp, err := c.dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			b, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("error while connecting to websub, status: %d, error: %s %s", resp.StatusCode, err.Error(), b)
+		}
+		return nil, fmt.Errorf("error while connecting to websub, error: %s", err.Error())
+	}
+	// The server pings the client, a connection without pings is considered lost after pong wait.
+	_ = conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(c.config.WriteWait))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		_ = conn.Close()
+		return nil, ErrClosed
+	}
+	c.conn = conn
+	close(c.connected)
+	return conn, nil
+}
+
+// run reads the connection and reconnects until the client is closed.
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.stopped)
+	defer close(c.messages)
+	for {
+		err := c.read(conn)
+		c.mu.Lock()
+		c.conn = nil
+		c.connected = make(chan struct{})
+		// Replies of pending requests are sent to the lost connection.
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		closed, resubscribe := c.closed, c.resubscribe
+		c.resubscribe = false
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+		if !resubscribe {
+			c.logger.WithField("username", c.config.Username).WithError(err).Warn("websub connection is lost, reconnecting")
+		}
+		if conn = c.reconnect(resubscribe); conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials until a connection is created or the client is closed, the first attempt of a
+// resubscription is not delayed.
+func (c *Client) reconnect(resubscribe bool) *websocket.Conn {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 || !resubscribe {
+			select {
+			case <-time.After(backoff(attempt, c.config.MinBackoff, c.config.MaxBackoff)):
+			case <-c.ctx.Done():
+				return nil
+			}
+		}
+		ctx, cancel := context.WithTimeout(c.ctx, c.config.PongWait)
+		conn, err := c.dial(ctx)
+		cancel()
+		if err == nil {
+			c.logger.WithField("username", c.config.Username).WithField("attempt", attempt+1).Info("reconnected to websub")
+			return conn
+		}
+		if c.ctx.Err() != nil {
+			return nil
+		}
+		c.logger.WithField("username", c.config.Username).WithField("attempt", attempt+1).WithError(err).Warn("could not reconnect to websub")
+	}
+}
+
+// backoff returns delay of a reconnect attempt, it's a random duration between half and all of the
+// exponential delay so reconnects of many clients are spread.
+func backoff(attempt int, min, max time.Duration) time.Duration {
+	d := max
+	if attempt < 32 && min<<uint(attempt) < max && min<<uint(attempt) > 0 {
+		d = min << uint(attempt)
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return d/2 + time.Duration(jitter.Int63n(int64(d/2)+1))
+}
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// read dispatches frames of the connection until it fails.
+func (c *Client) read(conn *websocket.Conn) error {
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
+		f := &frame{}
+		if err := json.Unmarshal(b, f); err != nil {
+			c.logger.WithField("username", c.config.Username).WithError(err).Error("invalid frame from websub")
+			continue
+		}
+		switch f.Type {
+		case "":
+			msg := &hub.Message{ID: f.ID, Topic: f.Topic, Data: decode(f.Data), Retained: f.Retained}
+			select {
+			case c.messages <- msg:
+			case <-c.ctx.Done():
+				return ErrClosed
+			}
+			if msg.ID != "" {
+				c.mu.Lock()
+				c.lastID = msg.ID
+				c.mu.Unlock()
+			}
+		case replyFrame:
+			c.mu.Lock()
+			ch, ok := c.pending[f.ID]
+			c.mu.Unlock()
+			if ok {
+				ch <- f
+			}
+		case errorFrame:
+			select {
+			case c.errors <- &PublishError{ID: f.ID, Topic: f.Topic, Reason: f.Error, Details: f.Details}:
+			default:
+				c.logger.WithField("id", f.ID).WithField("error", f.Error).Warn("publish error is dropped")
+			}
+		default:
+			c.logger.WithField("type", f.Type).Debug("unknown frame from websub")
+		}
+	}
+}
+
+// write writes a frame when the client is connected, it waits for reconnects until ctx is done.
+func (c *Client) write(ctx context.Context, cm *clientMessage) error {
+	for {
+		c.mu.Lock()
+		closed, conn, connected := c.closed, c.conn, c.connected
+		c.mu.Unlock()
+		if closed {
+			return ErrClosed
+		}
+		if conn == nil {
+			select {
+			case <-connected:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-c.ctx.Done():
+				return ErrClosed
+			}
+		}
+
+		c.writeMu.Lock()
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(c.config.WriteWait)
+		}
+		_ = conn.SetWriteDeadline(deadline)
+		err := conn.WriteJSON(cm)
+		c.writeMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("error while writing to websub, error: %s", err.Error())
+		}
+		return nil
+	}
+}
+
+func (c *Client) nextID() string {
+	return strconv.FormatUint(atomic.AddUint64(&c.seq, 1), 10)
+}
+
+// encode converts data to body of a client message.
+func encode(data interface{}) (string, error) {
+	switch d := data.(type) {
+	case string:
+		return d, nil
+	case []byte:
+		return string(d), nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("error while encoding data to json, error: %s", err.Error())
+	}
+	return string(b), nil
+}
+
+// decode decodes data of a frame to generic json values.
+func decode(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	var d interface{}
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return string(raw)
+	}
+	return d
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mammadmodi/websub/internal/api/websocket"
+	"github.com/mammadmodi/websub/pkg/hub"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer serves a SockHub over a durable redis hub which is backed by miniredis.
+func newTestServer(t *testing.T) (*httptest.Server, *hub.RedisHub, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := logrus.New()
+	l.SetOutput(ioutil.Discard)
+	rh := hub.NewRedisHub(redis.NewClient(&redis.Options{Addr: s.Addr()}), l, &hub.RedisHubConfig{StreamMaxLen: 100})
+	sh := websocket.NewSockHub(websocket.Configuration{
+		PingInterval:   50 * time.Millisecond,
+		PongWait:       time.Minute,
+		WriteWait:      time.Second,
+		ReadLimit:      4096,
+		AckTimeout:     time.Minute,
+		MaxDeliveries:  5,
+		RequestTimeout: time.Second,
+	}, rh, l)
+	sh.Inbound = websocket.Chain{websocket.MaxSize(16)}
+	ts := httptest.NewServer(http.HandlerFunc(sh.Connect))
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return ts, rh, s
+}
+
+func testConfig(ts *httptest.Server, topics ...string) Config {
+	return Config{
+		URL:        "ws" + strings.TrimPrefix(ts.URL, "http") + "/socket/connect",
+		Username:   "john",
+		Topics:     topics,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	}
+}
+
+// waitSubscribed waits until redis has subscribers of topics.
+func waitSubscribed(t *testing.T, s *miniredis.Miniredis, topics ...string) {
+	for i := 0; i < 100; i++ {
+		subscribed := true
+		for _, n := range s.PubSubNumSub(topics...) {
+			subscribed = subscribed && n > 0
+		}
+		if subscribed {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("subscriptions are not created")
+}
+
+func receive(t *testing.T, c *Client) *hub.Message {
+	select {
+	case msg := <-c.Messages():
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("message is not received")
+		return nil
+	}
+}
+
+func TestConnect(t *testing.T) {
+	ts, rh, s := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := Connect(ctx, Config{URL: ts.URL}, nil)
+	assert.Error(t, err)
+	c := testConfig(ts, "news")
+	c.Group = "readers"
+	c.Ack = true
+	_, err = Connect(ctx, c, nil)
+	assert.Error(t, err)
+
+	cl, err := Connect(ctx, testConfig(ts, "news"), nil)
+	assert.NoError(t, err)
+	waitSubscribed(t, s, "news")
+
+	assert.NoError(t, rh.Publish(ctx, "news", map[string]string{"title": "hello"}))
+	msg := receive(t, cl)
+	assert.Equal(t, "news", msg.Topic)
+	assert.Equal(t, map[string]interface{}{"title": "hello"}, msg.Data)
+
+	sub, err := rh.Subscribe(ctx, "comments")
+	assert.NoError(t, err)
+	assert.NoError(t, cl.Publish(ctx, "comments", "nice"))
+	select {
+	case m := <-sub.MessageChannel:
+		assert.Equal(t, "nice", m.Data)
+	case <-time.After(time.Second):
+		t.Fatal("published message is not received")
+	}
+
+	assert.NoError(t, cl.Publish(ctx, "comments", strings.Repeat("a", 17)))
+	select {
+	case err := <-cl.Errors():
+		pe, ok := err.(*PublishError)
+		assert.True(t, ok)
+		assert.Equal(t, "comments", pe.Topic)
+	case <-time.After(time.Second):
+		t.Fatal("publish error is not received")
+	}
+
+	assert.NoError(t, cl.Close())
+	_, ok := <-cl.Messages()
+	assert.False(t, ok)
+	assert.Equal(t, ErrClosed, cl.Publish(ctx, "comments", "late"))
+}
+
+func TestClient_Request(t *testing.T) {
+	ts, rh, s := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := rh.Subscribe(ctx, "rpc.unread")
+	assert.NoError(t, err)
+	go func() {
+		msg := <-sub.MessageChannel
+		_ = rh.Publish(ctx, msg.Reply, 3)
+	}()
+
+	cl, err := Connect(ctx, testConfig(ts, "news"), nil)
+	assert.NoError(t, err)
+	defer cl.Close()
+	waitSubscribed(t, s, "news")
+	reply, err := cl.Request(ctx, "rpc.unread", "john")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), reply.Data)
+
+	_, err = cl.Request(ctx, "rpc.missing", "john")
+	assert.Error(t, err)
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	ts, rh, s := newTestServer(t)
+	ctx := context.Background()
+	c := testConfig(ts, "orders")
+	c.Ack = true
+	cl, err := Connect(ctx, c, nil)
+	assert.NoError(t, err)
+	defer cl.Close()
+	for i := 0; i < 100 && !s.Exists("orders"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.NoError(t, rh.Publish(ctx, "orders", "first"))
+	msg := receive(t, cl)
+	assert.Equal(t, "first", msg.Data)
+	assert.NotEmpty(t, msg.ID)
+	assert.NoError(t, cl.Ack(ctx, msg.ID))
+
+	// Messages that are published while the client is disconnected are received after reconnect.
+	ts.CloseClientConnections()
+	assert.NoError(t, rh.Publish(ctx, "orders", "second"))
+	msg = receive(t, cl)
+	assert.Equal(t, "second", msg.Data)
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	ts, rh, s := newTestServer(t)
+	ctx := context.Background()
+	cl, err := Connect(ctx, testConfig(ts, "news"), nil)
+	assert.NoError(t, err)
+	defer cl.Close()
+
+	assert.NoError(t, cl.Subscribe("sports", "news"))
+	assert.Equal(t, []string{"news", "sports"}, cl.Topics())
+	waitSubscribed(t, s, "news", "sports")
+	assert.NoError(t, rh.Publish(ctx, "sports", "goal"))
+	assert.Equal(t, "goal", receive(t, cl).Data)
+
+	assert.Error(t, cl.Unsubscribe("news", "sports"))
+	assert.NoError(t, cl.Unsubscribe("news"))
+	assert.Equal(t, []string{"sports"}, cl.Topics())
+}
+
+func TestClient_PublishRetained(t *testing.T) {
+	ts, rh, s := newTestServer(t)
+	ctx := context.Background()
+	cl, err := Connect(ctx, testConfig(ts, "status"), nil)
+	assert.NoError(t, err)
+	defer cl.Close()
+	waitSubscribed(t, s, "status")
+	assert.NoError(t, cl.PublishRetained(ctx, "status", "online"))
+	msg := receive(t, cl)
+	assert.Equal(t, "online", msg.Data)
+	assert.False(t, msg.Retained)
+
+	// New subscribers receive the retained message first.
+	other, err := Connect(ctx, testConfig(ts, "status"), nil)
+	assert.NoError(t, err)
+	defer other.Close()
+	msg = receive(t, other)
+	assert.Equal(t, "online", msg.Data)
+	assert.True(t, msg.Retained)
+
+	assert.NoError(t, cl.PublishRetained(ctx, "status", nil))
+	var msgs []*hub.Message
+	for i := 0; i < 100; i++ {
+		if msgs, err = rh.Retained(ctx, "status"); err != nil || len(msgs) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 100; attempt++ {
+		d := backoff(attempt, 100*time.Millisecond, time.Second)
+		expected := time.Second
+		if attempt < 4 {
+			expected = 100 * time.Millisecond << uint(attempt)
+		}
+		assert.True(t, d >= expected/2 && d <= expected, "attempt %d: %s", attempt, d)
+	}
+}
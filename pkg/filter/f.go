@@ -0,0 +1,550 @@
+// Package filter compiles small expressions that select messages by their topic and fields of their data,
+// e.g. data.region == "eu" && (data.amount > 100 || topic == "orders.vip").
+//
+// An expression compares a field with a literal(==, !=, <, <=, >, >=), checks membership of a field in a list
+// of literals(data.region in ["eu", "us"]), or checks that a field is truthy(data.urgent), and expressions
+// are combined with &&, || and ! and grouped with parentheses. Fields are topic, data or a dotted path in data
+// like data.customer.address.city, numeric tokens index arrays(data.items.0.sku). Literals are json strings,
+// numbers, true, false and null.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Roots of field paths.
+const (
+	TopicField = "topic"
+	DataField  = "data"
+)
+
+// Limits bound complexity of expressions, zero values are not limited.
+type Limits struct {
+	// MaxLength is maximum length of an expression.
+	MaxLength int
+	// MaxNodes is maximum number of comparisons, literals and operators of an expression.
+	MaxNodes int
+	// MaxDepth is maximum nesting of parentheses and negations.
+	MaxDepth int
+}
+
+// Filter is a compiled expression.
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses expr and checks it against limits.
+func Compile(expr string, limits Limits) (*Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("filter cannot be empty")
+	}
+	if limits.MaxLength > 0 && len(expr) > limits.MaxLength {
+		return nil, fmt.Errorf("filter is longer than %d characters", limits.MaxLength)
+	}
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, limits: limits}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != eofToken {
+		return nil, fmt.Errorf("unexpected %s at %d", t, t.pos)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// String returns the expression of the filter.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether a message of topic with data is selected by the filter. Data is a decoded value
+// of a hub codec, json payloads in strings and bytes are decoded before evaluation and data that can't
+// be decoded has no fields.
+func (f *Filter) Match(topic string, data interface{}) bool {
+	return f.root.eval(&env{topic: topic, data: normalize(data)})
+}
+
+// normalize converts data to generic json values.
+func normalize(data interface{}) interface{} {
+	var b []byte
+	switch d := data.(type) {
+	case nil, bool, float64, map[string]interface{}, []interface{}:
+		return d
+	case string:
+		b = []byte(d)
+	case []byte:
+		b = d
+	default:
+		var err error
+		if b, err = json.Marshal(d); err != nil {
+			return nil
+		}
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		// Plain text payloads can still be compared as strings.
+		if s, ok := data.(string); ok {
+			return s
+		}
+		if bs, ok := data.([]byte); ok {
+			return string(bs)
+		}
+		return nil
+	}
+	return v
+}
+
+type env struct {
+	topic string
+	data  interface{}
+}
+
+// lookup returns value of a field path, ok is false when the field doesn't exist.
+func (e *env) lookup(path []string) (interface{}, bool) {
+	if path[0] == TopicField {
+		return e.topic, true
+	}
+	v := e.data
+	for _, seg := range path[1:] {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = c[seg]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, false
+			}
+			v = c[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+type node interface {
+	eval(e *env) bool
+}
+
+type orNode struct{ left, right node }
+
+func (n *orNode) eval(e *env) bool { return n.left.eval(e) || n.right.eval(e) }
+
+type andNode struct{ left, right node }
+
+func (n *andNode) eval(e *env) bool { return n.left.eval(e) && n.right.eval(e) }
+
+type notNode struct{ x node }
+
+func (n *notNode) eval(e *env) bool { return !n.x.eval(e) }
+
+// truthNode is true when the field exists and is not false, null, zero or empty.
+type truthNode struct{ path []string }
+
+func (n *truthNode) eval(e *env) bool {
+	v, ok := e.lookup(n.path)
+	if !ok {
+		return false
+	}
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case float64:
+		return x != 0
+	case string:
+		return x != ""
+	case []interface{}:
+		return len(x) > 0
+	case map[string]interface{}:
+		return len(x) > 0
+	}
+	return true
+}
+
+type cmpNode struct {
+	path    []string
+	op      string
+	literal interface{}
+}
+
+func (n *cmpNode) eval(e *env) bool {
+	v, ok := e.lookup(n.path)
+	if !ok {
+		// Missing fields are only different from literals.
+		return n.op == "!="
+	}
+	switch n.op {
+	case "==":
+		return equal(v, n.literal)
+	case "!=":
+		return !equal(v, n.literal)
+	}
+	c, ok := compare(v, n.literal)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+type inNode struct {
+	path     []string
+	literals []interface{}
+}
+
+func (n *inNode) eval(e *env) bool {
+	v, ok := e.lookup(n.path)
+	if !ok {
+		return false
+	}
+	for _, l := range n.literals {
+		if equal(v, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares a field with a literal, fields that are objects or arrays are not equal to any literal.
+func equal(v, literal interface{}) bool {
+	switch l := literal.(type) {
+	case nil:
+		return v == nil
+	case bool:
+		b, ok := v.(bool)
+		return ok && b == l
+	case float64:
+		f, ok := v.(float64)
+		return ok && f == l
+	case string:
+		s, ok := v.(string)
+		return ok && s == l
+	}
+	return false
+}
+
+// compare orders two numbers or two strings, ok is false for other values.
+func compare(v, literal interface{}) (int, bool) {
+	switch l := literal.(type) {
+	case float64:
+		f, ok := v.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case f < l:
+			return -1, true
+		case f > l:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(s, l), true
+	}
+	return 0, false
+}
+
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	fieldToken
+	literalToken
+	opToken
+	punctToken
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == eofToken {
+		return "end of filter"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// lex splits an expression to tokens.
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||") ||
+			strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") ||
+			strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, token{kind: opToken, text: expr[i : i+2], pos: i})
+			i += 2
+		case c == '<' || c == '>' || c == '!':
+			tokens = append(tokens, token{kind: opToken, text: expr[i : i+1], pos: i})
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == ',':
+			tokens = append(tokens, token{kind: punctToken, text: expr[i : i+1], pos: i})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			var s string
+			if err := json.Unmarshal([]byte(expr[i:end+1]), &s); err != nil {
+				return nil, fmt.Errorf("invalid string at %d, error: %s", i, err.Error())
+			}
+			tokens = append(tokens, token{kind: literalToken, text: expr[i : end+1], value: s, pos: i})
+			i = end + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for ; end < len(expr) && strings.IndexByte("0123456789.eE+-", expr[end]) >= 0; end++ {
+			}
+			f, err := strconv.ParseFloat(expr[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number '%s' at %d", expr[i:end], i)
+			}
+			tokens = append(tokens, token{kind: literalToken, text: expr[i:end], value: f, pos: i})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for ; end < len(expr) && isFieldChar(expr[end]); end++ {
+			}
+			t := token{kind: fieldToken, text: expr[i:end], pos: i}
+			switch t.text {
+			case "true", "false":
+				t.kind, t.value = literalToken, t.text == "true"
+			case "null":
+				t.kind = literalToken
+			case "in":
+				t.kind = opToken
+			}
+			tokens = append(tokens, t)
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: eofToken, pos: len(expr)}), nil
+}
+
+func isFieldChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || (c >= '0' && c <= '9') || unicode.IsLetter(rune(c))
+}
+
+// parser is a recursive descent parser of expressions, || has lower precedence than &&.
+type parser struct {
+	tokens []token
+	i      int
+	limits Limits
+	nodes  int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != eofToken {
+		p.i++
+	}
+	return t
+}
+
+// count counts n nodes and fails when the expression has more nodes than the limit.
+func (p *parser) count(n int) error {
+	p.nodes += n
+	if p.limits.MaxNodes > 0 && p.nodes > p.limits.MaxNodes {
+		return fmt.Errorf("filter is too complex, it has more than %d nodes", p.limits.MaxNodes)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == opToken && p.peek().text == "||" {
+		p.next()
+		if err := p.count(1); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == opToken && p.peek().text == "&&" {
+		p.next()
+		if err := p.count(1); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	switch {
+	case t.kind == opToken && t.text == "!":
+		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		if err := p.count(1); err != nil {
+			return nil, err
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	case t.kind == punctToken && t.text == "(":
+		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.text != ")" || c.kind != punctToken {
+			return nil, fmt.Errorf("expected ')' at %d, got %s", c.pos, c)
+		}
+		return x, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) enter() error {
+	p.depth++
+	if p.limits.MaxDepth > 0 && p.depth > p.limits.MaxDepth {
+		return fmt.Errorf("filter is nested deeper than %d levels", p.limits.MaxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) parseComparison() (node, error) {
+	t := p.next()
+	if t.kind != fieldToken {
+		return nil, fmt.Errorf("expected a field at %d, got %s", t.pos, t)
+	}
+	path := strings.Split(t.text, ".")
+	if (path[0] != TopicField && path[0] != DataField) || (path[0] == TopicField && len(path) > 1) {
+		return nil, fmt.Errorf("unknown field '%s' at %d, fields are %s or paths of %s", t.text, t.pos, TopicField, DataField)
+	}
+	for _, seg := range path {
+		if seg == "" {
+			return nil, fmt.Errorf("invalid field '%s' at %d", t.text, t.pos)
+		}
+	}
+	if err := p.count(1); err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	if op.kind != opToken || op.text == "&&" || op.text == "||" || op.text == "!" {
+		return &truthNode{path: path}, nil
+	}
+	p.next()
+	if op.text == "in" {
+		literals, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{path: path, literals: literals}, nil
+	}
+	l, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return &cmpNode{path: path, op: op.text, literal: l}, nil
+}
+
+func (p *parser) parseList() ([]interface{}, error) {
+	if t := p.next(); t.kind != punctToken || t.text != "[" {
+		return nil, fmt.Errorf("expected '[' at %d, got %s", t.pos, t)
+	}
+	var literals []interface{}
+	for {
+		l, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		literals = append(literals, l)
+		t := p.next()
+		if t.kind == punctToken && t.text == "]" {
+			return literals, nil
+		}
+		if t.kind != punctToken || t.text != "," {
+			return nil, fmt.Errorf("expected ',' or ']' at %d, got %s", t.pos, t)
+		}
+	}
+}
+
+func (p *parser) parseLiteral() (interface{}, error) {
+	t := p.next()
+	if t.kind != literalToken {
+		return nil, fmt.Errorf("expected a literal at %d, got %s", t.pos, t)
+	}
+	if err := p.count(1); err != nil {
+		return nil, err
+	}
+	return t.value, nil
+}
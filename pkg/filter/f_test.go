@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestFilter_Match(t *testing.T) {
+	data := map[string]interface{}{
+		"region": "eu",
+		"amount": float64(120),
+		"urgent": true,
+		"note":   nil,
+		"items":  []interface{}{map[string]interface{}{"sku": "a-1"}},
+	}
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`data.region == "eu"`, true},
+		{`data.region != "eu"`, false},
+		{`data.amount > 100`, true},
+		{`data.amount <= 100`, false},
+		{`data.amount >= 120 && data.amount < 120.5`, true},
+		{`data.region == "us" || data.amount > 100`, true},
+		{`data.region == "us" || (data.urgent && data.amount < 10)`, false},
+		{`!(data.region == "us")`, true},
+		{`data.urgent`, true},
+		{`!data.note`, true},
+		{`data.note == null`, true},
+		{`data.missing`, false},
+		{`data.missing == "x"`, false},
+		{`data.missing != "x"`, true},
+		{`data.region in ["us", "eu"]`, true},
+		{`data.amount in [1, 2]`, false},
+		{`data.items.0.sku == "a-1"`, true},
+		{`data.items.1.sku == "a-1"`, false},
+		{`data.region > 10`, false},
+		{`data.region < "fr"`, true},
+		{`topic == "orders.created"`, true},
+		{`topic == "orders.created" && data.urgent == false`, false},
+	}
+	for _, tt := range tests {
+		f, err := Compile(tt.expr, Limits{})
+		if !assert.NoError(t, err, tt.expr) {
+			continue
+		}
+		assert.Equal(t, tt.match, f.Match("orders.created", data), tt.expr)
+	}
+}
+
+func TestFilter_MatchPayloads(t *testing.T) {
+	f, err := Compile(`data.region == "eu"`, Limits{})
+	assert.NoError(t, err)
+	assert.True(t, f.Match("orders", `{"region": "eu"}`))
+	assert.True(t, f.Match("orders", []byte(`{"region": "eu"}`)))
+	assert.True(t, f.Match("orders", struct {
+		Region string `json:"region"`
+	}{"eu"}))
+	assert.False(t, f.Match("orders", "not json"))
+	assert.False(t, f.Match("orders", nil))
+
+	f, err = Compile(`data == "hello"`, Limits{})
+	assert.NoError(t, err)
+	assert.True(t, f.Match("greetings", "hello"))
+	assert.True(t, f.Match("greetings", []byte("hello")))
+}
+
+func TestCompile(t *testing.T) {
+	invalid := []string{
+		``,
+		`region == "eu"`,
+		`topic.name == "x"`,
+		`data..region`,
+		`data.region ==`,
+		`data.region == data.other`,
+		`data.region == "eu`,
+		`data.region in "eu"`,
+		`data.region in ["eu" "us"]`,
+		`(data.region == "eu"`,
+		`data.region == "eu")`,
+		`data.region == 'eu'`,
+		`data.region == "eu" &&`,
+	}
+	for _, expr := range invalid {
+		_, err := Compile(expr, Limits{})
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	_, err := Compile(`data.region == "eu"`, Limits{MaxLength: 10})
+	assert.Error(t, err)
+
+	// a comparison has two nodes and each operator has one.
+	_, err = Compile(`data.a == 1 && data.b == 2`, Limits{MaxNodes: 5})
+	assert.NoError(t, err)
+	_, err = Compile(`data.a == 1 && data.b == 2 && data.c`, Limits{MaxNodes: 5})
+	assert.Error(t, err)
+	_, err = Compile(`data.a in [1, 2, 3, 4, 5]`, Limits{MaxNodes: 5})
+	assert.Error(t, err)
+
+	_, err = Compile(`((data.a))`, Limits{MaxDepth: 2})
+	assert.NoError(t, err)
+	_, err = Compile(`((!data.a))`, Limits{MaxDepth: 2})
+	assert.Error(t, err)
+	_, err = Compile(strings.Repeat("(", 10000)+"data.a"+strings.Repeat(")", 10000), Limits{MaxDepth: 8})
+	assert.Error(t, err)
+}
@@ -0,0 +1,413 @@
+// Package graphql parses GraphQL operations that websub serves, fields of an operation are selected from
+// data of messages. Fragments, directives and block strings are not supported.
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Types of operations.
+const (
+	Query        = "query"
+	Mutation     = "mutation"
+	Subscription = "subscription"
+)
+
+// Operation is an operation of a document.
+type Operation struct {
+	Type string
+	Name string
+	// Defaults are default values of variables.
+	Defaults map[string]interface{}
+	Fields   []*Field
+}
+
+// Field is a selected field with its arguments, values of arguments are json like values and Variable.
+type Field struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]interface{}
+	Selections []*Field
+}
+
+// Variable is a reference to a variable in a value.
+type Variable string
+
+// Key is the key of the field in results, it's the alias when the field has one.
+func (f *Field) Key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Parse parses a document and returns its operation of operationName, the name can be empty when the
+// document has one operation.
+func Parse(document, operationName string) (*Operation, error) {
+	tokens, err := lex(document)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	var ops []*Operation
+	for p.peek().kind != eofToken {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("document has no operation")
+	}
+	if operationName == "" {
+		if len(ops) > 1 {
+			return nil, fmt.Errorf("operation name is required for documents with %d operations", len(ops))
+		}
+		return ops[0], nil
+	}
+	for _, op := range ops {
+		if op.Name == operationName {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("operation '%s' is not found", operationName)
+}
+
+// Resolve replaces variables of a value with their values, defaults of the operation are used for
+// variables that are not given. ok is false when a variable of the value has no value.
+func (op *Operation) Resolve(v interface{}, variables map[string]interface{}) (value interface{}, ok bool) {
+	switch v := v.(type) {
+	case Variable:
+		if val, ok := variables[string(v)]; ok {
+			return val, true
+		}
+		val, ok := op.Defaults[string(v)]
+		return val, ok
+	case []interface{}:
+		l := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			r, ok := op.Resolve(e, variables)
+			if !ok {
+				return nil, false
+			}
+			l = append(l, r)
+		}
+		return l, true
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			r, ok := op.Resolve(e, variables)
+			if !ok {
+				return nil, false
+			}
+			m[k] = r
+		}
+		return m, true
+	default:
+		return v, true
+	}
+}
+
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	punctToken
+	nameToken
+	valueToken
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == eofToken {
+		return "end of document"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// lex splits a document to tokens, commas are ignored like white spaces.
+func lex(doc string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(doc); {
+		c := doc[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(doc) && doc[i] != '\n' && doc[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(doc[i:], "..."):
+			return nil, fmt.Errorf("fragments are not supported")
+		case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+			tokens = append(tokens, token{kind: punctToken, text: doc[i : i+1], pos: i})
+			i++
+		case strings.HasPrefix(doc[i:], `"""`):
+			return nil, fmt.Errorf("block strings are not supported")
+		case c == '"':
+			end := i + 1
+			for ; end < len(doc) && doc[end] != '"' && doc[end] != '\n'; end++ {
+				if doc[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(doc) || doc[end] != '"' {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			var s string
+			if err := json.Unmarshal([]byte(doc[i:end+1]), &s); err != nil {
+				return nil, fmt.Errorf("invalid string at %d, error: %s", i, err.Error())
+			}
+			tokens = append(tokens, token{kind: valueToken, text: doc[i : end+1], value: s, pos: i})
+			i = end + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for ; end < len(doc) && strings.IndexByte("0123456789.eE+-", doc[end]) >= 0; end++ {
+			}
+			f, err := strconv.ParseFloat(doc[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number '%s' at %d", doc[i:end], i)
+			}
+			tokens = append(tokens, token{kind: valueToken, text: doc[i:end], value: f, pos: i})
+			i = end
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			end := i + 1
+			for ; end < len(doc) && isNameChar(doc[end]); end++ {
+			}
+			tokens = append(tokens, token{kind: nameToken, text: doc[i:end], pos: i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: eofToken, pos: len(doc)}), nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parser is a recursive descent parser of documents.
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != eofToken {
+		p.i++
+	}
+	return t
+}
+
+// is reports whether the next token is the punctuator and consumes it.
+func (p *parser) is(punct string) bool {
+	if t := p.peek(); t.kind == punctToken && t.text == punct {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.is(punct) {
+		t := p.peek()
+		return fmt.Errorf("expected '%s' but found %s at %d", punct, t, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	t := p.next()
+	if t.kind != nameToken {
+		return "", fmt.Errorf("expected a name but found %s at %d", t, t.pos)
+	}
+	return t.text, nil
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{Type: Query, Defaults: make(map[string]interface{})}
+	if t := p.peek(); t.kind == nameToken {
+		switch t.text {
+		case Query, Mutation, Subscription:
+			op.Type = t.text
+		default:
+			return nil, fmt.Errorf("'%s' is not an operation type at %d", t.text, t.pos)
+		}
+		p.next()
+		if p.peek().kind == nameToken {
+			op.Name = p.next().text
+		}
+		if p.is("(") {
+			if err := p.parseVariables(op); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if t := p.peek(); t.kind == punctToken && t.text == "@" {
+		return nil, fmt.Errorf("directives are not supported")
+	}
+	var err error
+	op.Fields, err = p.parseSelections()
+	return op, err
+}
+
+// parseVariables parses variable definitions after their opening parenthesis.
+func (p *parser) parseVariables(op *Operation) error {
+	for !p.is(")") {
+		if err := p.expect("$"); err != nil {
+			return err
+		}
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		if err := p.parseType(); err != nil {
+			return err
+		}
+		if p.is("=") {
+			v, err := p.parseValue(true)
+			if err != nil {
+				return err
+			}
+			op.Defaults[name] = v
+		}
+	}
+	return nil
+}
+
+// parseType skips a type, types are not checked.
+func (p *parser) parseType() error {
+	if p.is("[") {
+		if err := p.parseType(); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	p.is("!")
+	return nil
+}
+
+func (p *parser) parseSelections() ([]*Field, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []*Field
+	for !p.is("}") {
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("selection set cannot be empty")
+	}
+	return fields, nil
+}
+
+func (p *parser) parseField() (*Field, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f := &Field{Name: name}
+	if p.is(":") {
+		f.Alias = name
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("(") {
+		f.Arguments = make(map[string]interface{})
+		for !p.is(")") {
+			n, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if f.Arguments[n], err = p.parseValue(false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if t := p.peek(); t.kind == punctToken && t.text == "@" {
+		return nil, fmt.Errorf("directives are not supported")
+	}
+	if t := p.peek(); t.kind == punctToken && t.text == "{" {
+		if f.Selections, err = p.parseSelections(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// parseValue parses a value, constant values of defaults can't have variables. Enum values are strings.
+func (p *parser) parseValue(constant bool) (interface{}, error) {
+	t := p.next()
+	switch {
+	case t.kind == valueToken:
+		return t.value, nil
+	case t.kind == nameToken:
+		switch t.text {
+		case "true", "false":
+			return t.text == "true", nil
+		case "null":
+			return nil, nil
+		}
+		return t.text, nil
+	case t.kind == punctToken && t.text == "$" && !constant:
+		name, err := p.name()
+		return Variable(name), err
+	case t.kind == punctToken && t.text == "[":
+		l := []interface{}{}
+		for !p.is("]") {
+			v, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case t.kind == punctToken && t.text == "{":
+		m := map[string]interface{}{}
+		for !p.is("}") {
+			n, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if m[n], err = p.parseValue(constant); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("expected a value but found %s at %d", t, t.pos)
+}
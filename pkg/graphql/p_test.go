@@ -0,0 +1,77 @@
+package graphql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	doc := `
+# Orders of a region.
+subscription OnOrder($region: String = "eu", $ids: [Int!]) {
+  order: orderCreated(region: $region, ids: $ids, status: PAID, vip: true, meta: {level: 1}) {
+    id
+    customer { name }
+  }
+}
+query Q { news }`
+	op, err := Parse(doc, "OnOrder")
+	assert.NoError(t, err)
+	assert.Equal(t, &Operation{
+		Type:     Subscription,
+		Name:     "OnOrder",
+		Defaults: map[string]interface{}{"region": "eu"},
+		Fields: []*Field{{
+			Alias: "order",
+			Name:  "orderCreated",
+			Arguments: map[string]interface{}{
+				"region": Variable("region"),
+				"ids":    Variable("ids"),
+				"status": "PAID",
+				"vip":    true,
+				"meta":   map[string]interface{}{"level": float64(1)},
+			},
+			Selections: []*Field{{Name: "id"}, {Name: "customer", Selections: []*Field{{Name: "name"}}}},
+		}},
+	}, op)
+	assert.Equal(t, "order", op.Fields[0].Key())
+
+	v, ok := op.Resolve(op.Fields[0].Arguments["region"], nil)
+	assert.True(t, ok)
+	assert.Equal(t, "eu", v)
+	v, ok = op.Resolve(op.Fields[0].Arguments["region"], map[string]interface{}{"region": "us"})
+	assert.True(t, ok)
+	assert.Equal(t, "us", v)
+	_, ok = op.Resolve(op.Fields[0].Arguments["ids"], nil)
+	assert.False(t, ok)
+	v, ok = op.Resolve([]interface{}{Variable("ids"), 1.0}, map[string]interface{}{"ids": 2.0})
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{2.0, 1.0}, v)
+
+	op, err = Parse("{ news }", "")
+	assert.NoError(t, err)
+	assert.Equal(t, Query, op.Type)
+	assert.Equal(t, "news", op.Fields[0].Key())
+}
+
+func TestParse_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"no operation":          "",
+		"two operations":        "query A { a } query B { b }",
+		"fragments":             "subscription { a { ...F } }",
+		"directives":            "subscription { a @skip(if: true) }",
+		"block strings":         `subscription { a(b: """x""") }`,
+		"empty selections":      "subscription { }",
+		"unterminated":          "subscription { a(b: \"x) }",
+		"invalid type":          "fragment F on A { a }",
+		"variable in a default": "subscription ($a: Int = $b) { a }",
+		"missing brace":         "subscription { a",
+	}
+	for name, doc := range cases {
+		_, err := Parse(doc, "")
+		assert.Error(t, err, name)
+	}
+	_, err := Parse("query A { a }", "B")
+	assert.Error(t, err)
+}
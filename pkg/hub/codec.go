@@ -0,0 +1,168 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Names of the codecs that are shipped with hub package.
+const (
+	JSONCodecName     = "json"
+	RawCodecName      = "raw"
+	MsgPackCodecName  = "msgpack"
+	ProtobufCodecName = "protobuf"
+)
+
+// Codec is the serialization layer of hub drivers, a hub encodes message data with its codec
+// before publishing it and decodes received payloads with the same codec.
+type Codec interface {
+	// Name returns name of the codec(e.g. json).
+	Name() string
+	// Marshal encodes data to bytes that will be sent to the backend.
+	Marshal(data interface{}) ([]byte, error)
+	// Unmarshal decodes bytes that are received from the backend.
+	Unmarshal(b []byte) (interface{}, error)
+}
+
+// NewCodec returns the codec which is registered with name.
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case JSONCodecName:
+		return JSONCodec{}, nil
+	case RawCodecName:
+		return RawCodec{}, nil
+	case MsgPackCodecName:
+		return MsgPackCodec{}, nil
+	case ProtobufCodecName:
+		return ProtobufCodec{}, nil
+	default:
+		return nil, fmt.Errorf("'%s' is not a valid codec", name)
+	}
+}
+
+// JSONCodec encodes data with encoding/json and decodes payloads to generic json values.
+type JSONCodec struct{}
+
+// Name returns name of the codec.
+func (JSONCodec) Name() string { return JSONCodecName }
+
+// Marshal encodes data to json.
+func (JSONCodec) Marshal(data interface{}) ([]byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding data to json, error: %s", err.Error())
+	}
+	return b, nil
+}
+
+// Unmarshal decodes a json payload.
+func (JSONCodec) Unmarshal(b []byte) (interface{}, error) {
+	var d interface{}
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, fmt.Errorf("error while decoding json payload, error: %s", err.Error())
+	}
+	return d, nil
+}
+
+// RawCodec passes bytes and strings through untouched and decodes payloads to []byte.
+type RawCodec struct{}
+
+// Name returns name of the codec.
+func (RawCodec) Name() string { return RawCodecName }
+
+// Marshal returns data as bytes, data must be a []byte, a json.RawMessage or a string.
+func (RawCodec) Marshal(data interface{}) ([]byte, error) {
+	switch d := data.(type) {
+	case []byte:
+		return d, nil
+	case json.RawMessage:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	default:
+		return nil, fmt.Errorf("raw codec cannot encode data of type %T", data)
+	}
+}
+
+// Unmarshal returns a copy of the payload.
+func (RawCodec) Unmarshal(b []byte) (interface{}, error) {
+	d := make([]byte, len(b))
+	copy(d, b)
+	return d, nil
+}
+
+// MsgPackCodec encodes data with MessagePack.
+type MsgPackCodec struct{}
+
+// Name returns name of the codec.
+func (MsgPackCodec) Name() string { return MsgPackCodecName }
+
+// Marshal encodes data to MessagePack.
+func (MsgPackCodec) Marshal(data interface{}) ([]byte, error) {
+	b, err := msgpack.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding data to msgpack, error: %s", err.Error())
+	}
+	return b, nil
+}
+
+// Unmarshal decodes a MessagePack payload.
+func (MsgPackCodec) Unmarshal(b []byte) (interface{}, error) {
+	var d interface{}
+	if err := msgpack.Unmarshal(b, &d); err != nil {
+		return nil, fmt.Errorf("error while decoding msgpack payload, error: %s", err.Error())
+	}
+	return d, nil
+}
+
+// ProtobufCodec encodes proto messages wrapped in an Any message, so the receiver can resolve
+// message type from the global proto registry. Bytes(e.g. encoded json) are sent as BytesValue
+// messages and are decoded back to bytes.
+type ProtobufCodec struct{}
+
+// Name returns name of the codec.
+func (ProtobufCodec) Name() string { return ProtobufCodecName }
+
+// Marshal encodes data to protobuf, data must be a proto.Message, a []byte or a json.RawMessage.
+func (ProtobufCodec) Marshal(data interface{}) ([]byte, error) {
+	var m proto.Message
+	switch d := data.(type) {
+	case proto.Message:
+		m = d
+	case []byte:
+		m = wrapperspb.Bytes(d)
+	case json.RawMessage:
+		m = wrapperspb.Bytes(d)
+	default:
+		return nil, fmt.Errorf("protobuf codec cannot encode data of type %T", data)
+	}
+	a, err := anypb.New(m)
+	if err != nil {
+		return nil, fmt.Errorf("error while wrapping proto message, error: %s", err.Error())
+	}
+	b, err := proto.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding data to protobuf, error: %s", err.Error())
+	}
+	return b, nil
+}
+
+// Unmarshal decodes a protobuf payload to the proto.Message that it was created from.
+func (ProtobufCodec) Unmarshal(b []byte) (interface{}, error) {
+	a := &anypb.Any{}
+	if err := proto.Unmarshal(b, a); err != nil {
+		return nil, fmt.Errorf("error while decoding protobuf payload, error: %s", err.Error())
+	}
+	m, err := a.UnmarshalNew()
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving proto message %s, error: %s", a.GetTypeUrl(), err.Error())
+	}
+	if bv, ok := m.(*wrapperspb.BytesValue); ok {
+		return bv.GetValue(), nil
+	}
+	return m, nil
+}
@@ -0,0 +1,97 @@
+package hub
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+)
+
+func TestNewCodec(t *testing.T) {
+	for _, name := range []string{JSONCodecName, RawCodecName, MsgPackCodecName, ProtobufCodecName} {
+		c, err := NewCodec(name)
+		if assert.NoError(t, err) {
+			assert.Equal(t, name, c.Name())
+		}
+	}
+
+	c, err := NewCodec("invalid_codec")
+	assert.Error(t, err)
+	assert.Nil(t, c)
+}
+
+func TestJSONCodec(t *testing.T) {
+	c := JSONCodec{}
+	b, err := c.Marshal(map[string]interface{}{"key": "value"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"key":"value"}`, string(b))
+
+	d, err := c.Unmarshal(b)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "value"}, d)
+
+	_, err = c.Unmarshal([]byte("not json"))
+	assert.Error(t, err)
+
+	_, err = c.Marshal(make(chan int))
+	assert.Error(t, err)
+}
+
+func TestRawCodec(t *testing.T) {
+	c := RawCodec{}
+	b, err := c.Marshal("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), b)
+
+	b, err = c.Marshal([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), b)
+
+	d, err := c.Unmarshal(b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), d)
+
+	b, err = c.Marshal(json.RawMessage(`{"key":"value"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`{"key":"value"}`), b)
+
+	_, err = c.Marshal(12)
+	assert.Error(t, err)
+}
+
+func TestMsgPackCodec(t *testing.T) {
+	c := MsgPackCodec{}
+	b, err := c.Marshal(map[string]interface{}{"key": "value"})
+	assert.NoError(t, err)
+
+	d, err := c.Unmarshal(b)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "value"}, d)
+
+	_, err = c.Unmarshal([]byte{0xc1})
+	assert.Error(t, err)
+}
+
+func TestProtobufCodec(t *testing.T) {
+	c := ProtobufCodec{}
+	b, err := c.Marshal(wrapperspb.String("hello"))
+	assert.NoError(t, err)
+
+	d, err := c.Unmarshal(b)
+	if assert.NoError(t, err) {
+		assert.True(t, proto.Equal(wrapperspb.String("hello"), d.(proto.Message)))
+	}
+
+	b, err = c.Marshal(json.RawMessage(`{"key":"value"}`))
+	assert.NoError(t, err)
+	d, err = c.Unmarshal(b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`{"key":"value"}`), d)
+
+	_, err = c.Marshal("not a proto message")
+	assert.Error(t, err)
+
+	_, err = c.Unmarshal([]byte("not protobuf"))
+	assert.Error(t, err)
+}
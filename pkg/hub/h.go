@@ -1,11 +1,26 @@
 package hub
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotDurable is returned by DurableHub methods when persistence of messages is not enabled in the hub.
+var ErrNotDurable = errors.New("hub is not configured to persist messages")
+
+// ErrNotRetained is returned by RetainHub methods when retained messages are not enabled in the hub.
+var ErrNotRetained = errors.New("hub is not configured to retain messages")
 
 // Message is the data type that's been exchanged between hub implementations and .
 type Message struct {
+	// ID identifies the message in a durable hub, it's empty for messages of a non durable subscription.
+	ID    string      `json:"id,omitempty"`
 	Data  interface{} `json:"data"`
 	Topic string      `json:"topic"`
+	// Reply is the inbox topic of a request, responders publish the reply to it.
+	Reply string `json:"reply,omitempty"`
+	// Retained is true for retained messages that are sent to new subscribers of their topic.
+	Retained bool `json:"retained,omitempty"`
 }
 
 // Subscription is a struct that holds state of a subscription.
@@ -16,8 +31,44 @@ type Subscription struct {
 	MessageChannel chan *Message
 }
 
-// Hub is a messaging channel that implements pub sub exchange pattern.
+// Hub is a messaging channel that implements pub sub exchange pattern. Topics of Subscribe can be
+// patterns with wildcard tokens(e.g. orders.*), see MatchTopic.
 type Hub interface {
 	Publish(ctx context.Context, topic string, data interface{}) (err error)
 	Subscribe(ctx context.Context, topics ...string) (*Subscription, error)
 }
+
+// DurableHub is a Hub that persists published messages, so a subscriber can resume receiving
+// messages from where it left off.
+type DurableHub interface {
+	Hub
+	// SubscribeFrom creates a subscription that delivers messages which are published after the message
+	// with lastID, only new messages are delivered when lastID is empty.
+	SubscribeFrom(ctx context.Context, lastID string, topics ...string) (*Subscription, error)
+}
+
+// QueueHub is a Hub that load balances messages between subscriptions of a queue group, each message of
+// the topics is delivered to only one subscription of the group.
+type QueueHub interface {
+	Hub
+	QueueSubscribe(ctx context.Context, group string, topics ...string) (*Subscription, error)
+}
+
+// RequestHub is a Hub that supports request/reply, a request is published to a topic with a unique
+// inbox as Reply of the message and the first message that is published to the inbox is the reply.
+type RequestHub interface {
+	Hub
+	// Request publishes data to topic and waits for the reply until ctx is done.
+	Request(ctx context.Context, topic string, data interface{}) (*Message, error)
+}
+
+// RetainHub is a Hub that keeps the last retained message of each topic, so new subscribers receive the current
+// state of a topic without waiting for its next update.
+type RetainHub interface {
+	Hub
+	// PublishRetained publishes data to topic and keeps it as the retained message of topic, the retained
+	// message of topic is removed without publishing when data is nil.
+	PublishRetained(ctx context.Context, topic string, data interface{}) error
+	// Retained returns retained messages of topics, topics can be patterns.
+	Retained(ctx context.Context, topics ...string) ([]*Message, error)
+}
@@ -0,0 +1,215 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryHubConfig is configuration of a MemoryHub.
+type MemoryHubConfig struct {
+	// Codec encodes and decodes message data like the network hubs, JSONCodec is used when it's nil.
+	Codec Codec
+	// BufferSize is capacity of message channels of subscriptions, messages of a subscription whose
+	// channel is full are dropped. It's 1024 when it's zero.
+	BufferSize int
+}
+
+// MemoryHub is a Hub that delivers messages in process, it's meant for tests, benchmarks and single
+// instance deployments that don't need a broker.
+type MemoryHub struct {
+	Config *MemoryHubConfig
+	Logger *logrus.Logger
+
+	mu   sync.RWMutex
+	subs map[*memorySubscription]struct{}
+	// next is the round robin counter of queue groups.
+	next map[string]uint64
+	// retained are encoded retained messages by their topic.
+	retained map[string][]byte
+}
+
+type memorySubscription struct {
+	topics []string
+	group  string
+	ch     chan *Message
+}
+
+// NewMemoryHub creates a MemoryHub.
+func NewMemoryHub(logger *logrus.Logger, config *MemoryHubConfig) *MemoryHub {
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(ioutil.Discard)
+	}
+	if config == nil {
+		config = &MemoryHubConfig{}
+	}
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+	if config.BufferSize == 0 {
+		config.BufferSize = 1024
+	}
+	return &MemoryHub{
+		Config:   config,
+		Logger:   logger,
+		subs:     make(map[*memorySubscription]struct{}),
+		next:     make(map[string]uint64),
+		retained: make(map[string][]byte),
+	}
+}
+
+// Publish delivers data to subscriptions of topic, one subscription of each queue group receives it.
+func (m *MemoryHub) Publish(ctx context.Context, topic string, data interface{}) error {
+	return m.publish(topic, "", data)
+}
+
+func (m *MemoryHub) publish(topic, reply string, data interface{}) error {
+	b, err := m.Config.Codec.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error while marshalling message data, error : %s", err.Error())
+	}
+
+	m.mu.Lock()
+	var targets []*memorySubscription
+	groups := make(map[string][]*memorySubscription)
+	for s := range m.subs {
+		if !s.matches(topic) {
+			continue
+		}
+		if s.group == "" {
+			targets = append(targets, s)
+		} else {
+			groups[s.group] = append(groups[s.group], s)
+		}
+	}
+	for g, members := range groups {
+		key := g + "\x00" + topic
+		targets = append(targets, members[m.next[key]%uint64(len(members))])
+		m.next[key]++
+	}
+	m.mu.Unlock()
+
+	for _, s := range targets {
+		// Each subscription decodes its own copy like subscriptions of network hubs.
+		d, err := m.Config.Codec.Unmarshal(b)
+		if err != nil {
+			m.Logger.
+				WithField("topic", topic).
+				WithField("codec", m.Config.Codec.Name()).
+				WithError(err).
+				Error("could not decode memory message")
+			continue
+		}
+		select {
+		case s.ch <- &Message{Data: d, Topic: topic, Reply: reply}:
+		default:
+			m.Logger.WithField("topic", topic).WithField("topics", s.topics).Warn("subscription buffer is full, message is dropped")
+		}
+	}
+	return nil
+}
+
+// PublishRetained publishes data to topic and keeps it as the retained message of topic, the retained message
+// is removed when data is nil.
+func (m *MemoryHub) PublishRetained(ctx context.Context, topic string, data interface{}) error {
+	if data == nil {
+		m.mu.Lock()
+		delete(m.retained, topic)
+		m.mu.Unlock()
+		return nil
+	}
+	b, err := m.Config.Codec.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error while marshalling message data, error : %s", err.Error())
+	}
+	m.mu.Lock()
+	m.retained[topic] = b
+	m.mu.Unlock()
+	return m.publish(topic, "", data)
+}
+
+// Retained returns retained messages of topics, topics can be patterns.
+func (m *MemoryHub) Retained(ctx context.Context, topics ...string) ([]*Message, error) {
+	s := &memorySubscription{topics: topics}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var msgs []*Message
+	for t, b := range m.retained {
+		if !s.matches(t) {
+			continue
+		}
+		d, err := m.Config.Codec.Unmarshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("error while unmarshalling retained message, error : %s", err.Error())
+		}
+		msgs = append(msgs, &Message{Data: d, Topic: t, Retained: true})
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Topic < msgs[j].Topic })
+	return msgs, nil
+}
+
+// Subscribe creates a subscription to topics until ctx is done.
+func (m *MemoryHub) Subscribe(ctx context.Context, topics ...string) (*Subscription, error) {
+	return m.subscribe(ctx, "", topics)
+}
+
+// QueueSubscribe creates a subscription of a queue group, messages are delivered to members of the
+// group in round robin.
+func (m *MemoryHub) QueueSubscribe(ctx context.Context, group string, topics ...string) (*Subscription, error) {
+	if group == "" {
+		return nil, fmt.Errorf("queue group cannot be empty")
+	}
+	return m.subscribe(ctx, group, topics)
+}
+
+func (m *MemoryHub) subscribe(ctx context.Context, group string, topics []string) (*Subscription, error) {
+	s := &memorySubscription{topics: topics, group: group, ch: make(chan *Message, m.Config.BufferSize)}
+	m.mu.Lock()
+	m.subs[s] = struct{}{}
+	m.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		delete(m.subs, s)
+		m.mu.Unlock()
+	}()
+	return &Subscription{Topics: strings.Join(topics, ","), MessageChannel: s.ch}, nil
+}
+
+// Request publishes data to topic with a unique inbox and waits for the reply until ctx is done.
+func (m *MemoryHub) Request(ctx context.Context, topic string, data interface{}) (*Message, error) {
+	inbox, err := NewInbox()
+	if err != nil {
+		return nil, err
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub, err := m.subscribe(sctx, "", []string{inbox})
+	if err != nil {
+		return nil, err
+	}
+	if err := m.publish(topic, inbox, data); err != nil {
+		return nil, err
+	}
+	select {
+	case msg := <-sub.MessageChannel:
+		return &Message{Data: msg.Data, Topic: topic}, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error while waiting for reply of %s, error: %s", topic, ctx.Err().Error())
+	}
+}
+
+func (s *memorySubscription) matches(topic string) bool {
+	for _, t := range s.topics {
+		if t == topic || MatchTopic(t, topic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryHub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewMemoryHub(nil, nil)
+	testHubPubSub(ctx, t, h)
+	testHubPatternSubscribe(ctx, t, h)
+	testHubQueueSubscribe(ctx, t, h)
+	testHubRequest(ctx, t, h)
+	testHubRetain(ctx, t, h)
+}
+
+func TestMemoryHubBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewMemoryHub(nil, &MemoryHubConfig{BufferSize: 2})
+	sub, err := h.Subscribe(ctx, "news")
+	assert.NoError(t, err)
+
+	// Messages are dropped when the buffer of the subscription is full.
+	for _, d := range []string{"a", "b", "c"} {
+		assert.NoError(t, h.Publish(ctx, "news", d))
+	}
+	assert.Equal(t, "a", (<-sub.MessageChannel).Data)
+	assert.Equal(t, "b", (<-sub.MessageChannel).Data)
+	assert.Len(t, sub.MessageChannel, 0)
+
+	// Subscriptions are removed when their context is done.
+	cancel()
+	for i := 0; i < 100; i++ {
+		h.mu.RLock()
+		n := len(h.subs)
+		h.mu.RUnlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("subscription is not removed")
+}
+
+// flakyCodec is a json codec that fails to decode its first payload.
+type flakyCodec struct {
+	JSONCodec
+	decoded int32
+}
+
+func (c *flakyCodec) Unmarshal(b []byte) (interface{}, error) {
+	if atomic.AddInt32(&c.decoded, 1) == 1 {
+		return nil, errors.New("decode failed")
+	}
+	return c.JSONCodec.Unmarshal(b)
+}
+
+func TestMemoryHubDecodeError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewMemoryHub(nil, &MemoryHubConfig{Codec: &flakyCodec{}})
+	s1, err := h.Subscribe(ctx, "news")
+	assert.NoError(t, err)
+	s2, err := h.Subscribe(ctx, "news")
+	assert.NoError(t, err)
+
+	// A subscription whose copy cannot be decoded doesn't stop delivery to other subscriptions.
+	assert.NoError(t, h.Publish(ctx, "news", "hello"))
+	assert.Equal(t, 1, len(s1.MessageChannel)+len(s2.MessageChannel))
+}
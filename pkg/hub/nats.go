@@ -2,23 +2,38 @@ package hub
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/nats-io/nats.go"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// retainedWait is the duration that reading retained messages of a subject waits for messages of the stream.
+const retainedWait = 5 * time.Second
+
 // NatsHub is a nats client wrapper that contains nats pub sub commands.
 type NatsHub struct {
 	Client *nats.Conn
 	Config *NatsHubConfig
 	Logger *logrus.Logger
+
+	js nats.JetStreamContext
 }
 
 // NatsHubConfig is config for NatsHub.
-type NatsHubConfig struct{}
+type NatsHubConfig struct {
+	// Codec encodes and decodes message data, JSONCodec is used when it's nil.
+	Codec Codec
+	// Stream is name of the JetStream stream that persists published messages, messages are published
+	// with core nats when it's empty.
+	Stream string
+	// RetainStream is name of the JetStream stream that keeps retained messages of topics as
+	// <RetainStream>.<topic> subjects, messages are not retained when it's empty.
+	RetainStream string
+}
 
 // NewNatsHub assigns params to a nats hub object and returns it.
 func NewNatsHub(client *nats.Conn, logger *logrus.Logger, config *NatsHubConfig) *NatsHub {
@@ -26,43 +41,292 @@ func NewNatsHub(client *nats.Conn, logger *logrus.Logger, config *NatsHubConfig)
 		logger = logrus.New()
 		logger.SetOutput(ioutil.Discard)
 	}
+	if config == nil {
+		config = &NatsHubConfig{}
+	}
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
 
 	rh := &NatsHub{
 		Client: client,
 		Config: config,
 		Logger: logger,
 	}
+	if config.Stream != "" || config.RetainStream != "" {
+		// JetStream returns an error only for invalid options.
+		rh.js, _ = client.JetStream()
+	}
 
 	return rh
 }
 
+// OnStateChange calls f with the new state of the nats connection when it's disconnected(nats.DISCONNECTED),
+// reconnected(nats.CONNECTED) or closed(nats.CLOSED), a connected connection is disconnected before it's
+// closed. Handlers of the connection that are set before are kept.
+func (n *NatsHub) OnStateChange(f func(state nats.Status)) {
+	disconnected := n.Client.DisconnectErrHandler()
+	n.Client.SetDisconnectErrHandler(func(nc *nats.Conn, err error) {
+		if disconnected != nil {
+			disconnected(nc, err)
+		}
+		f(nats.DISCONNECTED)
+	})
+	reconnected := n.Client.ReconnectHandler()
+	n.Client.SetReconnectHandler(func(nc *nats.Conn) {
+		if reconnected != nil {
+			reconnected(nc)
+		}
+		f(nats.CONNECTED)
+	})
+	closed := n.Client.ClosedHandler()
+	n.Client.SetClosedHandler(func(nc *nats.Conn) {
+		if closed != nil {
+			closed(nc)
+		}
+		f(nats.CLOSED)
+	})
+}
+
+// EnsureStream creates the JetStream stream of the hub for subjects if it doesn't exist, maxMsgs limits
+// number of messages that are kept in the stream.
+func (n *NatsHub) EnsureStream(subjects []string, maxMsgs int64) error {
+	if n.js == nil || n.Config.Stream == "" {
+		return ErrNotDurable
+	}
+	if _, err := n.js.StreamInfo(n.Config.Stream); err == nil {
+		return nil
+	}
+	_, err := n.js.AddStream(&nats.StreamConfig{
+		Name:     n.Config.Stream,
+		Subjects: subjects,
+		MaxMsgs:  maxMsgs,
+	})
+	if err != nil {
+		return fmt.Errorf("error while creating nats stream %s, error: %s", n.Config.Stream, err.Error())
+	}
+	return nil
+}
+
+// EnsureRetainStream creates the JetStream stream of retained messages if it doesn't exist, the stream keeps
+// one message of each subject.
+func (n *NatsHub) EnsureRetainStream() error {
+	if n.js == nil || n.Config.RetainStream == "" {
+		return ErrNotRetained
+	}
+	si, err := n.js.StreamInfo(n.Config.RetainStream)
+	if err == nil {
+		if si.Config.MaxMsgsPerSubject == 1 {
+			return nil
+		}
+		// Streams of older versions keep all messages of subjects.
+		si.Config.MaxMsgsPerSubject = 1
+		_, err = n.js.UpdateStream(&si.Config)
+	} else {
+		_, err = n.js.AddStream(&nats.StreamConfig{
+			Name:              n.Config.RetainStream,
+			Subjects:          []string{n.Config.RetainStream + ".>"},
+			MaxMsgsPerSubject: 1,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("error while creating nats stream %s, error: %s", n.Config.RetainStream, err.Error())
+	}
+	return nil
+}
+
 // Publish publishes a message to a topic.
 func (n *NatsHub) Publish(_ context.Context, topic string, data interface{}) (err error) {
-	b, err := json.Marshal(data)
+	b, err := n.Config.Codec.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("error while marshalling message data, error : %s", err.Error())
 	}
+	// Replies are not persisted, their inboxes are not subjects of the stream.
+	if n.js != nil && n.Config.Stream != "" && !IsInbox(topic) {
+		if _, err = n.js.Publish(topic, b); err != nil {
+			return fmt.Errorf("error while publishing to nats stream, error: %s", err.Error())
+		}
+		n.Logger.WithField("subject", topic).Debug("successfully published to nats stream")
+		return nil
+	}
 	n.Logger.WithField("subject", topic).Debug("successfully published to nats")
 	return n.Client.Publish(topic, b)
 }
 
+// PublishRetained publishes data to topic and keeps it in the retain stream as the retained message of topic,
+// the retained message is removed when data is nil. The stream keeps one message of each subject, so the
+// new message replaces the older one at once and a removal is an empty message.
+func (n *NatsHub) PublishRetained(ctx context.Context, topic string, data interface{}) error {
+	if n.js == nil || n.Config.RetainStream == "" {
+		return ErrNotRetained
+	}
+	var b []byte
+	if data != nil {
+		var err error
+		if b, err = n.Config.Codec.Marshal(data); err != nil {
+			return fmt.Errorf("error while marshalling message data, error : %s", err.Error())
+		}
+	}
+	if _, err := n.js.Publish(n.Config.RetainStream+"."+topic, b); err != nil {
+		return fmt.Errorf("error while retaining message, error: %s", err.Error())
+	}
+	if data == nil {
+		return nil
+	}
+	return n.Publish(ctx, topic, data)
+}
+
+// Retained returns retained messages of topics, topics can be patterns.
+func (n *NatsHub) Retained(ctx context.Context, topics ...string) ([]*Message, error) {
+	if n.js == nil || n.Config.RetainStream == "" {
+		return nil, ErrNotRetained
+	}
+	var msgs []*Message
+	seen := make(map[string]bool)
+	for _, t := range topics {
+		rms, err := n.retained(ctx, n.Config.RetainStream+"."+t)
+		if err != nil {
+			return nil, err
+		}
+		for _, rm := range rms {
+			// Empty messages are removed retained messages.
+			if seen[rm.topic] || len(rm.data) == 0 {
+				continue
+			}
+			seen[rm.topic] = true
+			d, err := n.Config.Codec.Unmarshal(rm.data)
+			if err != nil {
+				n.Logger.WithField("subject", rm.topic).WithError(err).Error("could not decode retained message")
+				continue
+			}
+			msgs = append(msgs, &Message{Data: d, Topic: rm.topic, Retained: true})
+		}
+	}
+	return msgs, nil
+}
+
+// retainedMessage is a message of the retain stream.
+type retainedMessage struct {
+	topic string
+	data  []byte
+}
+
+// retained reads the last messages of subjects of the retain stream that match subject, the message of a
+// subject is read directly and subjects of patterns are read with an ephemeral consumer.
+func (n *NatsHub) retained(ctx context.Context, subject string) ([]*retainedMessage, error) {
+	prefix := n.Config.RetainStream + "."
+	if !IsPattern(subject) {
+		m, err := n.js.GetLastMsg(n.Config.RetainStream, subject)
+		if err == nats.ErrMsgNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error while reading retained message of %s, error: %s", subject, err.Error())
+		}
+		return []*retainedMessage{{topic: strings.TrimPrefix(m.Subject, prefix), data: m.Data}}, nil
+	}
+
+	sub, err := n.js.SubscribeSync(subject, nats.BindStream(n.Config.RetainStream), nats.DeliverLastPerSubject(), nats.AckNone())
+	if err != nil {
+		return nil, fmt.Errorf("error while reading retained messages of %s, error: %s", subject, err.Error())
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+	info, err := sub.ConsumerInfo()
+	if err != nil {
+		return nil, fmt.Errorf("error while reading retained messages of %s, error: %s", subject, err.Error())
+	}
+	if info.NumPending+info.Delivered.Consumer == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, retainedWait)
+	defer cancel()
+	var msgs []*retainedMessage
+	for {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading retained messages of %s, error: %s", subject, err.Error())
+		}
+		md, err := msg.Metadata()
+		if err != nil {
+			return nil, fmt.Errorf("error while reading retained messages of %s, error: %s", subject, err.Error())
+		}
+		msgs = append(msgs, &retainedMessage{topic: strings.TrimPrefix(msg.Subject, prefix), data: msg.Data})
+		if md.NumPending == 0 {
+			return msgs, nil
+		}
+	}
+}
+
 // Subscribe creates a subscription to topic(or topics) and returns it.
 func (n *NatsHub) Subscribe(ctx context.Context, topics ...string) (*Subscription, error) {
+	return n.subscribe(ctx, "", topics)
+}
+
+// QueueSubscribe creates a nats queue subscription to topics, messages are load balanced between
+// subscriptions of the group.
+func (n *NatsHub) QueueSubscribe(ctx context.Context, group string, topics ...string) (*Subscription, error) {
+	if group == "" {
+		return nil, fmt.Errorf("queue group cannot be empty")
+	}
+	return n.subscribe(ctx, group, topics)
+}
+
+// Request sends a nats request to topic and waits for the reply until ctx is done.
+func (n *NatsHub) Request(ctx context.Context, topic string, data interface{}) (*Message, error) {
+	b, err := n.Config.Codec.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while marshalling message data, error : %s", err.Error())
+	}
+	msg, err := n.Client.RequestWithContext(ctx, topic, b)
+	if err != nil {
+		return nil, fmt.Errorf("no reply is received for request to %s, error: %s", topic, err.Error())
+	}
+	d, err := n.Config.Codec.Unmarshal(msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("error while decoding reply, error: %s", err.Error())
+	}
+	return &Message{Data: d, Topic: topic}, nil
+}
+
+// subscribe subscribes to topics, subscriptions join the queue group if it's not empty.
+func (n *NatsHub) subscribe(ctx context.Context, group string, topics []string) (*Subscription, error) {
 	msgChannel := make(chan *Message)
-	subs := make([]*nats.Subscription, len(topics), len(topics))
+	subs := make([]*nats.Subscription, 0, len(topics))
 	for _, t := range topics {
 		subject := t
-		s, err := n.Client.Subscribe(t, func(msg *nats.Msg) {
+		handler := func(msg *nats.Msg) {
 			n.Logger.WithField("subject", subject).Debug("message received by nats")
-			var d interface{}
-			_ = json.Unmarshal(msg.Data, &d)
+			d, err := n.Config.Codec.Unmarshal(msg.Data)
+			if err != nil {
+				n.Logger.
+					WithField("subject", subject).
+					WithField("codec", n.Config.Codec.Name()).
+					WithError(err).
+					Error("could not decode nats message")
+				return
+			}
 			hm := &Message{
 				Data:  d,
-				Topic: subject,
+				Topic: msg.Subject,
+				Reply: msg.Reply,
 			}
-			msgChannel <- hm
-		})
+			select {
+			case msgChannel <- hm:
+			case <-ctx.Done():
+			}
+		}
+		var s *nats.Subscription
+		var err error
+		if group == "" {
+			s, err = n.Client.Subscribe(t, handler)
+		} else {
+			s, err = n.Client.QueueSubscribe(t, group, handler)
+		}
 		if err != nil {
+			for _, s := range subs {
+				_ = s.Unsubscribe()
+			}
 			return nil, fmt.Errorf("error while creating nats subscription to %s, error: %s", subject, err.Error())
 		}
 		subs = append(subs, s)
@@ -84,3 +348,73 @@ func (n *NatsHub) Subscribe(ctx context.Context, topics ...string) (*Subscriptio
 
 	return s, nil
 }
+
+// SubscribeFrom creates a subscription that consumes messages of topics from the JetStream stream of the hub,
+// message ids are stream sequences of messages.
+func (n *NatsHub) SubscribeFrom(ctx context.Context, lastID string, topics ...string) (*Subscription, error) {
+	if n.js == nil || n.Config.Stream == "" {
+		return nil, ErrNotDurable
+	}
+	deliver := nats.DeliverNew()
+	if lastID != "" {
+		seq, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid nats stream sequence", lastID)
+		}
+		deliver = nats.StartSequence(seq + 1)
+	}
+
+	msgChannel := make(chan *Message)
+	subs := make([]*nats.Subscription, 0, len(topics))
+	for _, t := range topics {
+		subject := t
+		s, err := n.js.Subscribe(t, func(msg *nats.Msg) {
+			md, err := msg.Metadata()
+			if err != nil {
+				n.Logger.WithField("subject", subject).WithError(err).Error("could not get nats stream message metadata")
+				return
+			}
+			d, err := n.Config.Codec.Unmarshal(msg.Data)
+			if err != nil {
+				n.Logger.
+					WithField("subject", subject).
+					WithField("codec", n.Config.Codec.Name()).
+					WithError(err).
+					Error("could not decode nats stream message")
+				return
+			}
+			hm := &Message{
+				ID:    strconv.FormatUint(md.Sequence.Stream, 10),
+				Data:  d,
+				Topic: msg.Subject,
+			}
+			select {
+			case msgChannel <- hm:
+			case <-ctx.Done():
+			}
+		}, nats.BindStream(n.Config.Stream), deliver, nats.AckNone())
+		if err != nil {
+			for _, s := range subs {
+				_ = s.Unsubscribe()
+			}
+			return nil, fmt.Errorf("error while creating nats stream subscription to %s, error: %s", subject, err.Error())
+		}
+		subs = append(subs, s)
+	}
+
+	go func() {
+		<-ctx.Done()
+		n.Logger.WithField("subject", topics).Debug("context is done for nats stream subscriptions")
+
+		for _, s := range subs {
+			_ = s.Unsubscribe()
+		}
+	}()
+
+	s := &Subscription{
+		Topics:         strings.Join(topics, ","),
+		MessageChannel: msgChannel,
+	}
+
+	return s, nil
+}
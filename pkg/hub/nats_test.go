@@ -3,10 +3,12 @@ package hub
 import (
 	"context"
 	natsserver "github.com/nats-io/nats-server/test"
+	jsserver "github.com/nats-io/nats-server/v2/test"
 	"github.com/nats-io/nats.go"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func mockNatsHub() (hub *NatsHub, cancel func()) {
@@ -34,6 +36,43 @@ func TestNewNatsHub(t *testing.T) {
 	assert.Equal(t, c, rh.Config)
 }
 
+func TestNatsHub_OnStateChange(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = 8372
+	ns := natsserver.RunServer(&opts)
+	defer ns.Shutdown()
+	reconnects := make(chan struct{}, 1)
+	nc, err := nats.Connect("nats://127.0.0.1:8372", nats.ReconnectWait(50*time.Millisecond), nats.MaxReconnects(-1),
+		nats.ReconnectHandler(func(*nats.Conn) { reconnects <- struct{}{} }))
+	if err != nil {
+		t.Fatalf("cannot connect to mock nats server, error: %v", err)
+	}
+
+	states := make(chan nats.Status, 3)
+	NewNatsHub(nc, nil, nil).OnStateChange(func(state nats.Status) { states <- state })
+	next := func() nats.Status {
+		select {
+		case s := <-states:
+			return s
+		case <-time.After(5 * time.Second):
+			t.Fatal("state change is not received")
+			return 0
+		}
+	}
+
+	ns.Shutdown()
+	assert.Equal(t, nats.DISCONNECTED, next())
+	ns = natsserver.RunServer(&opts)
+	defer ns.Shutdown()
+	assert.Equal(t, nats.CONNECTED, next())
+	// Handlers of the connection are still called.
+	<-reconnects
+	// Closing a connected connection disconnects it first.
+	nc.Close()
+	assert.Equal(t, nats.DISCONNECTED, next())
+	assert.Equal(t, nats.CLOSED, next())
+}
+
 func TestNatsHub(t *testing.T) {
 	hub, stop := mockNatsHub()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -42,4 +81,94 @@ func TestNatsHub(t *testing.T) {
 		cancel()
 	}()
 	testHubPubSub(ctx, t, hub)
+	testHubPatternSubscribe(ctx, t, hub)
+	testHubQueueSubscribe(ctx, t, hub)
+	testHubRequest(ctx, t, hub)
+}
+
+func TestNatsHubSubscribeFrom(t *testing.T) {
+	opts := jsserver.DefaultTestOptions
+	opts.Port = 8370
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	ns := jsserver.RunServer(&opts)
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("cannot connect to mock nats server, error: %v", err)
+	}
+	defer nc.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = NewNatsHub(nc, nil, nil).SubscribeFrom(ctx, "", "topic")
+	assert.Equal(t, ErrNotDurable, err)
+
+	hub := NewNatsHub(nc, nil, &NatsHubConfig{Stream: "websub"})
+	assert.NoError(t, hub.EnsureStream([]string{"topic"}, 100))
+	assert.NoError(t, hub.EnsureStream([]string{"topic"}, 100))
+	assert.NoError(t, hub.Publish(ctx, "topic", "first"))
+	assert.NoError(t, hub.Publish(ctx, "topic", "second"))
+
+	// A new subscription receives only new messages.
+	sub, err := hub.SubscribeFrom(ctx, "", "topic")
+	assert.NoError(t, err)
+	assert.NoError(t, hub.Publish(ctx, "topic", "third"))
+	third := <-sub.MessageChannel
+	assert.Equal(t, "third", third.Data)
+	assert.Equal(t, "3", third.ID)
+
+	// A resumed subscription receives messages which are published after the last id.
+	sub, err = hub.SubscribeFrom(ctx, "1", "topic")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", (<-sub.MessageChannel).Data)
+	assert.Equal(t, "3", (<-sub.MessageChannel).ID)
+
+	_, err = hub.SubscribeFrom(ctx, "invalid", "topic")
+	assert.Error(t, err)
+}
+
+func TestNatsHubRetain(t *testing.T) {
+	opts := jsserver.DefaultTestOptions
+	opts.Port = 8371
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	ns := jsserver.RunServer(&opts)
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("cannot connect to mock nats server, error: %v", err)
+	}
+	defer nc.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = NewNatsHub(nc, nil, nil).Retained(ctx, "topic")
+	assert.Equal(t, ErrNotRetained, err)
+	assert.Equal(t, ErrNotRetained, NewNatsHub(nc, nil, nil).PublishRetained(ctx, "topic", "data"))
+
+	hub := NewNatsHub(nc, nil, &NatsHubConfig{RetainStream: "retained"})
+	assert.NoError(t, hub.EnsureRetainStream())
+	assert.NoError(t, hub.EnsureRetainStream())
+	testHubRetain(ctx, t, hub)
+
+	// The stream keeps one message of each subject, it keeps the removal of status.device1, status.device2,
+	// alerts.device1 and status.device3.
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, hub.PublishRetained(ctx, "status.device3", i))
+	}
+	si, err := hub.js.StreamInfo("retained")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), si.State.Msgs)
+	msgs, err := hub.Retained(ctx, "status.device3")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Message{{Topic: "status.device3", Data: float64(4), Retained: true}}, msgs)
+
+	// Retain streams of older versions are updated to keep one message of each subject.
+	_, err = hub.js.UpdateStream(&nats.StreamConfig{Name: "retained", Subjects: []string{"retained.>"}})
+	assert.NoError(t, err)
+	assert.NoError(t, hub.EnsureRetainStream())
+	si, err = hub.js.StreamInfo("retained")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), si.Config.MaxMsgsPerSubject)
 }
@@ -2,22 +2,53 @@ package hub
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"github.com/go-redis/redis"
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"strings"
+	"sync"
+	"time"
 )
 
+// streamBlock is the duration that a durable subscription blocks on XREAD before checking its context.
+const streamBlock = time.Second
+
+// queueClaimIdle is the duration that a message can be pending in a consumer group before it's claimed
+// by another consumer.
+const queueClaimIdle = time.Minute
+
+// resubscribeBackoff is the duration between attempts of creating a subscription again after failover.
+const resubscribeBackoff = time.Second
+
 // RedisHub is a redis client wrapper that contains redis pub sub commands.
 type RedisHub struct {
 	Client redis.UniversalClient
 	Config *RedisHubConfig
 	Logger *logrus.Logger
+
+	mu       sync.Mutex
+	failover chan struct{}
 }
 
 // RedisHubConfig is config for RedisHub.
-type RedisHubConfig struct{}
+type RedisHubConfig struct {
+	// Codec encodes and decodes message data, JSONCodec is used when it's nil.
+	Codec Codec
+	// StreamMaxLen enables persistence of published messages, each topic is kept in a redis stream
+	// with approximately StreamMaxLen entries. Persistence is disabled when it's zero.
+	StreamMaxLen int64
+	// StreamPrefix is prepended to topics to build their stream keys.
+	StreamPrefix string
+	// Sharded publishes and subscribes with SPUBLISH and SSUBSCRIBE of Redis 7 clusters, topic patterns
+	// cannot be subscribed in this mode.
+	Sharded bool
+	// RetainPrefix is prepended to topics to build keys of their retained messages, it's "retained:" when
+	// it's empty.
+	RetainPrefix string
+}
 
 // NewRedisHub assigns params to a redis hub object and returns it.
 func NewRedisHub(client redis.UniversalClient, logger *logrus.Logger, config *RedisHubConfig) *RedisHub {
@@ -25,38 +56,203 @@ func NewRedisHub(client redis.UniversalClient, logger *logrus.Logger, config *Re
 		logger = logrus.New()
 		logger.SetOutput(ioutil.Discard)
 	}
+	if config == nil {
+		config = &RedisHubConfig{}
+	}
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+	if config.RetainPrefix == "" {
+		config.RetainPrefix = "retained:"
+	}
 
 	rh := &RedisHub{
 		Client: client,
 		Config: config,
 		Logger: logger,
+
+		failover: make(chan struct{}),
 	}
 
 	return rh
 }
 
 // Publish publishes a message to a topic.
-func (r *RedisHub) Publish(_ context.Context, topic string, data interface{}) error {
-	cmd := r.Client.Publish(topic, data)
-	_, err := cmd.Result()
-	return err
+func (r *RedisHub) Publish(ctx context.Context, topic string, data interface{}) error {
+	b, err := r.Config.Codec.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error while marshalling message data, error : %s", err.Error())
+	}
+	if r.Config.StreamMaxLen > 0 && !IsInbox(topic) {
+		err = r.Client.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.Config.StreamPrefix + topic,
+			MaxLen: r.Config.StreamMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{"data": b},
+		}).Err()
+		if err != nil {
+			return fmt.Errorf("error while adding message to redis stream, error: %s", err.Error())
+		}
+	}
+	if r.Config.Sharded {
+		return r.Client.SPublish(ctx, topic, b).Err()
+	}
+	return r.Client.Publish(ctx, topic, b).Err()
 }
 
-// Subscribe creates a subscription to topic(or topics) and returns it.
+// PublishRetained publishes data to topic and keeps it in a redis key as the retained message of topic, the
+// retained message is removed when data is nil.
+func (r *RedisHub) PublishRetained(ctx context.Context, topic string, data interface{}) error {
+	key := r.Config.RetainPrefix + topic
+	if data == nil {
+		if err := r.Client.Del(ctx, key).Err(); err != nil {
+			return fmt.Errorf("error while removing retained message, error: %s", err.Error())
+		}
+		return nil
+	}
+	b, err := r.Config.Codec.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error while marshalling message data, error : %s", err.Error())
+	}
+	// The message is retained before it's published, so subscribers that miss it receive it as retained message.
+	if err := r.Client.Set(ctx, key, b, 0).Err(); err != nil {
+		return fmt.Errorf("error while retaining message, error: %s", err.Error())
+	}
+	return r.Publish(ctx, topic, data)
+}
+
+// Retained returns retained messages of topics, keys of patterns are found with SCAN on all masters.
+func (r *RedisHub) Retained(ctx context.Context, topics ...string) ([]*Message, error) {
+	var keys []string
+	for _, t := range topics {
+		if !containsPattern([]string{t}) {
+			keys = append(keys, r.Config.RetainPrefix+t)
+			continue
+		}
+		matches, err := r.scan(ctx, globEscaper.Replace(r.Config.RetainPrefix)+redisGlob(t))
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range matches {
+			if MatchTopic(t, strings.TrimPrefix(k, r.Config.RetainPrefix)) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := r.Client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = p.Get(ctx, k)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error while getting retained messages, error: %s", err.Error())
+	}
+	seen := make(map[string]bool)
+	var msgs []*Message
+	for i, cmd := range cmds {
+		b, err := cmd.Bytes()
+		if err != nil || seen[keys[i]] {
+			continue
+		}
+		seen[keys[i]] = true
+		d, err := r.Config.Codec.Unmarshal(b)
+		if err != nil {
+			r.Logger.WithField("key", keys[i]).WithError(err).Error("could not decode retained message")
+			continue
+		}
+		msgs = append(msgs, &Message{Data: d, Topic: strings.TrimPrefix(keys[i], r.Config.RetainPrefix), Retained: true})
+	}
+	return msgs, nil
+}
+
+// scan returns keys that match a glob, keys of clusters are scanned on each master.
+func (r *RedisHub) scan(ctx context.Context, match string) ([]string, error) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	scan := func(ctx context.Context, c redis.Cmdable) error {
+		iter := c.Scan(ctx, 0, match, 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	var err error
+	if cc, ok := r.Client.(*redis.ClusterClient); ok {
+		err = cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error { return scan(ctx, c) })
+	} else {
+		err = scan(ctx, r.Client)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while scanning retained messages, error: %s", err.Error())
+	}
+	return keys, nil
+}
+
+// Subscribe creates a subscription to topic(or topics) and returns it. Subscriptions are created again
+// on the current master when Resubscribe is called.
 func (r *RedisHub) Subscribe(ctx context.Context, topics ...string) (*Subscription, error) {
-	ps := r.Client.Subscribe(topics...)
+	// Patterns are subscribed with PSUBSCRIBE, exact topics are subscribed as escaped patterns too
+	// so the subscription is created with one command. Different patterns may have the same glob(e.g.
+	// orders.* and orders.>), so messages of a glob are delivered when any of its patterns matches.
+	globs := make(map[string][]string)
+	if containsPattern(topics) {
+		if r.Config.Sharded {
+			return nil, fmt.Errorf("topic patterns are not supported by sharded redis subscriptions")
+		}
+		for _, t := range topics {
+			g := redisGlob(t)
+			globs[g] = append(globs[g], t)
+		}
+	}
+	failover := r.failoverSignal()
+	ps, err := r.subscribe(ctx, topics, globs)
+	if err != nil {
+		return nil, err
+	}
 	msgChannel := make(chan *Message)
 	go func() {
+		defer func() { _ = ps.Close() }()
 		for {
 			select {
-			case rm := <-ps.Channel():
+			case rm, ok := <-ps.Channel():
+				if !ok {
+					r.Logger.WithField("channels", topics).Error("redis subscription is closed")
+					return
+				}
+				if patterns, ok := globs[rm.Pattern]; ok && !matchAny(patterns, rm.Channel) {
+					continue
+				}
+				reply, p := unframeRequest([]byte(rm.Payload))
 				msg := &Message{
-					Data:  rm.Payload,
+					Data:  r.decode(rm.Channel, p),
 					Topic: rm.Channel,
+					Reply: reply,
+				}
+				select {
+				case msgChannel <- msg:
+				case <-ctx.Done():
+				}
+			case <-failover:
+				failover = r.failoverSignal()
+				// The new subscription is created before closing the old one so messages are not
+				// missed during the switch.
+				nps, ok := r.resubscribe(ctx, topics, globs)
+				if !ok {
+					continue
 				}
-				msgChannel <- msg
-			case <-ctx.Done():
 				_ = ps.Close()
+				ps = nps
+			case <-ctx.Done():
 				r.Logger.
 					WithField("channels", topics).
 					Infof("subscription removed from redis")
@@ -72,3 +268,367 @@ func (r *RedisHub) Subscribe(ctx context.Context, topics ...string) (*Subscripti
 
 	return s, nil
 }
+
+// Request publishes a request to topic and waits for its reply until ctx is done. Redis pub/sub doesn't
+// carry reply topics, so the inbox is framed in the payload and it's set as Reply of messages of
+// RedisHub subscriptions. Requests are not persisted in streams.
+func (r *RedisHub) Request(ctx context.Context, topic string, data interface{}) (*Message, error) {
+	if r.Config.Sharded {
+		return nil, fmt.Errorf("requests are not supported by sharded redis hubs")
+	}
+	b, err := r.Config.Codec.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while marshalling message data, error : %s", err.Error())
+	}
+	inbox, err := NewInbox()
+	if err != nil {
+		return nil, err
+	}
+	ps, err := r.subscribe(ctx, []string{inbox}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = ps.Close() }()
+	if err := r.Client.Publish(ctx, topic, frameRequest(inbox, b)).Err(); err != nil {
+		return nil, fmt.Errorf("error while publishing request, error: %s", err.Error())
+	}
+
+	select {
+	case rm, ok := <-ps.Channel():
+		if !ok {
+			return nil, fmt.Errorf("redis subscription of inbox is closed")
+		}
+		return &Message{Data: r.decode(rm.Channel, []byte(rm.Payload)), Topic: topic}, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no reply is received for request to %s, error: %s", topic, ctx.Err().Error())
+	}
+}
+
+// Resubscribe creates all active subscriptions again, it's called when master of a sentinel redis is
+// switched so subscriptions don't stay on connections to the old master.
+func (r *RedisHub) Resubscribe() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	close(r.failover)
+	r.failover = make(chan struct{})
+}
+
+// failoverSignal returns a channel which is closed on next call of Resubscribe.
+func (r *RedisHub) failoverSignal() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failover
+}
+
+// subscribe subscribes to topics or globs if there are any and waits for confirmation, otherwise messages
+// which are published right after Subscribe returns may be lost.
+func (r *RedisHub) subscribe(ctx context.Context, topics []string, globs map[string][]string) (*redis.PubSub, error) {
+	var ps *redis.PubSub
+	switch {
+	case len(globs) > 0:
+		ps = r.Client.PSubscribe(ctx, keys(globs)...)
+	case r.Config.Sharded:
+		ps = r.Client.SSubscribe(ctx, topics...)
+	default:
+		ps = r.Client.Subscribe(ctx, topics...)
+	}
+	if _, err := ps.Receive(ctx); err != nil {
+		_ = ps.Close()
+		return nil, fmt.Errorf("error while creating redis subscription to %v, error: %s", topics, err.Error())
+	}
+	return ps, nil
+}
+
+// resubscribe retries subscribe until it succeeds or ctx is done.
+func (r *RedisHub) resubscribe(ctx context.Context, topics []string, globs map[string][]string) (*redis.PubSub, bool) {
+	for {
+		ps, err := r.subscribe(ctx, topics, globs)
+		if err == nil {
+			r.Logger.WithField("channels", topics).Info("redis subscription is created again")
+			return ps, true
+		}
+		r.Logger.WithField("channels", topics).WithError(err).Error("error while resubscribing to redis")
+		select {
+		case <-time.After(resubscribeBackoff):
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// SubscribeFrom creates a subscription that reads messages of a topic from its redis stream. Each durable
+// subscription holds a connection of the client's pool while it's waiting for new messages, the topic
+// cannot be a pattern. Ids of messages are ids of their stream, so a lastID can't resume more than one
+// topic and durable subscriptions to several topics are rejected.
+func (r *RedisHub) SubscribeFrom(ctx context.Context, lastID string, topics ...string) (*Subscription, error) {
+	if r.Config.StreamMaxLen <= 0 {
+		return nil, ErrNotDurable
+	}
+	if len(topics) != 1 {
+		return nil, fmt.Errorf("durable redis subscriptions must have one topic")
+	}
+	if containsPattern(topics) {
+		return nil, fmt.Errorf("topic patterns are not supported by durable redis subscriptions")
+	}
+
+	// Streams argument of XREAD is list of keys followed by list of ids.
+	streams := make([]string, 2*len(topics))
+	for i, t := range topics {
+		key := r.Config.StreamPrefix + t
+		id := lastID
+		if id == "" {
+			var err error
+			if id, err = r.lastStreamID(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+		streams[i] = key
+		streams[len(topics)+i] = id
+	}
+
+	msgChannel := make(chan *Message)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				r.Logger.
+					WithField("channels", topics).
+					Infof("durable subscription removed from redis")
+				return
+			default:
+			}
+
+			res, err := r.Client.XRead(ctx, &redis.XReadArgs{Streams: streams, Block: streamBlock}).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			if err != nil {
+				r.Logger.WithField("channels", topics).WithError(err).Error("error while reading redis streams")
+				time.Sleep(streamBlock)
+				continue
+			}
+			for _, stream := range res {
+				i := indexOf(streams[:len(topics)], stream.Stream)
+				for _, xm := range stream.Messages {
+					streams[len(topics)+i] = xm.ID
+					select {
+					case msgChannel <- r.streamMessage(stream.Stream, topics[i], xm):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	s := &Subscription{
+		Topics:         strings.Join(topics, ","),
+		MessageChannel: msgChannel,
+	}
+
+	return s, nil
+}
+
+// QueueSubscribe creates a subscription that reads messages of topics with a redis consumer group, each
+// message is delivered to one subscription of the group and it's acknowledged when it's passed to the
+// subscriber. Messages that are not acknowledged by a subscription for queueClaimIdle(e.g. because its
+// instance is crashed) are claimed by other subscriptions of the group. Topics cannot be patterns.
+func (r *RedisHub) QueueSubscribe(ctx context.Context, group string, topics ...string) (*Subscription, error) {
+	if r.Config.StreamMaxLen <= 0 {
+		return nil, ErrNotDurable
+	}
+	if group == "" {
+		return nil, fmt.Errorf("queue group cannot be empty")
+	}
+	if containsPattern(topics) {
+		return nil, fmt.Errorf("topic patterns are not supported by redis queue subscriptions")
+	}
+
+	// Streams argument of XREADGROUP is list of keys followed by ">" for each key.
+	streams := make([]string, 2*len(topics))
+	for i, t := range topics {
+		key := r.Config.StreamPrefix + t
+		// New groups receive messages which are published after creation of the group.
+		err := r.Client.XGroupCreateMkStream(ctx, key, group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, fmt.Errorf("error while creating redis consumer group %s of %s, error: %s", group, key, err.Error())
+		}
+		streams[i] = key
+		streams[len(topics)+i] = ">"
+	}
+	consumer, err := consumerName(group)
+	if err != nil {
+		return nil, err
+	}
+
+	msgChannel := make(chan *Message)
+	go func() {
+		defer r.removeConsumer(streams[:len(topics)], group, consumer)
+		var lastClaim time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				r.Logger.
+					WithField("channels", topics).
+					WithField("group", group).
+					Infof("queue subscription removed from redis")
+				return
+			default:
+			}
+
+			var res []redis.XStream
+			if time.Since(lastClaim) >= queueClaimIdle {
+				lastClaim = time.Now()
+				res = r.claim(ctx, streams[:len(topics)], group, consumer)
+			}
+			read, err := r.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    group,
+				Consumer: consumer,
+				Streams:  streams,
+				Block:    streamBlock,
+			}).Result()
+			if err != nil && err != redis.Nil && ctx.Err() == nil {
+				r.Logger.WithField("channels", topics).WithField("group", group).WithError(err).Error("error while reading redis consumer group")
+				time.Sleep(streamBlock)
+			}
+			for _, stream := range append(res, read...) {
+				i := indexOf(streams[:len(topics)], stream.Stream)
+				for _, xm := range stream.Messages {
+					select {
+					case msgChannel <- r.streamMessage(stream.Stream, topics[i], xm):
+					case <-ctx.Done():
+						return
+					}
+					if err := r.Client.XAck(ctx, stream.Stream, group, xm.ID).Err(); err != nil {
+						r.Logger.WithField("stream", stream.Stream).WithField("id", xm.ID).WithError(err).Error("could not acknowledge redis stream message")
+					}
+				}
+			}
+		}
+	}()
+
+	s := &Subscription{
+		Topics:         strings.Join(topics, ","),
+		MessageChannel: msgChannel,
+	}
+
+	return s, nil
+}
+
+// claim takes over messages of streams that are pending in the group for more than queueClaimIdle.
+func (r *RedisHub) claim(ctx context.Context, keys []string, group, consumer string) []redis.XStream {
+	var res []redis.XStream
+	for _, key := range keys {
+		xms, _, err := r.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   key,
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  queueClaimIdle,
+			Start:    "0-0",
+			Count:    100,
+		}).Result()
+		if err != nil {
+			r.Logger.WithField("stream", key).WithField("group", group).WithError(err).Error("error while claiming idle redis stream messages")
+			continue
+		}
+		if len(xms) > 0 {
+			res = append(res, redis.XStream{Stream: key, Messages: xms})
+		}
+	}
+	return res
+}
+
+// removeConsumer deletes a consumer from the group of streams if it doesn't have pending messages, pending
+// messages are kept to be claimed by other consumers.
+func (r *RedisHub) removeConsumer(keys []string, group, consumer string) {
+	ctx := context.Background()
+	for _, key := range keys {
+		pending, err := r.Client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   key,
+			Group:    group,
+			Start:    "-",
+			End:      "+",
+			Count:    1,
+			Consumer: consumer,
+		}).Result()
+		if err != nil || len(pending) > 0 {
+			continue
+		}
+		_ = r.Client.XGroupDelConsumer(ctx, key, group, consumer).Err()
+	}
+}
+
+// streamMessage decodes a message of a redis stream.
+func (r *RedisHub) streamMessage(stream, topic string, xm redis.XMessage) *Message {
+	p, _ := xm.Values["data"].(string)
+	return &Message{
+		ID:    xm.ID,
+		Data:  r.decode(stream, []byte(p)),
+		Topic: topic,
+	}
+}
+
+// decode decodes a payload with the codec, payloads that the codec cannot decode(e.g. plain text
+// that is published with redis-cli) are delivered as strings.
+func (r *RedisHub) decode(channel string, p []byte) interface{} {
+	d, err := r.Config.Codec.Unmarshal(p)
+	if err != nil {
+		r.Logger.
+			WithField("channel", channel).
+			WithField("codec", r.Config.Codec.Name()).
+			WithError(err).
+			Debug("could not decode redis message, raw payload is delivered")
+		return string(p)
+	}
+	return d
+}
+
+// consumerName returns a unique name of a consumer of the group.
+func consumerName(group string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error while generating consumer name, error: %s", err.Error())
+	}
+	return group + "-" + hex.EncodeToString(b), nil
+}
+
+// lastStreamID returns id of the last message of a stream or "0" if the stream is empty.
+func (r *RedisHub) lastStreamID(ctx context.Context, key string) (string, error) {
+	xms, err := r.Client.XRevRangeN(ctx, key, "+", "-", 1).Result()
+	if err != nil {
+		return "", fmt.Errorf("error while getting last id of redis stream %s, error: %s", key, err.Error())
+	}
+	if len(xms) == 0 {
+		return "0", nil
+	}
+	return xms[0].ID, nil
+}
+
+func containsPattern(topics []string) bool {
+	for _, t := range topics {
+		if IsPattern(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func keys(m map[string][]string) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func indexOf(s []string, v string) int {
+	for i := range s {
+		if s[i] == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -2,10 +2,13 @@ package hub
 
 import (
 	"context"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
-	"github.com/go-redis/redis"
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -41,3 +44,186 @@ func TestRedisHubPubSub(t *testing.T) {
 	}()
 	testHubPubSub(ctx, t, redisHub)
 }
+
+func TestRedisHubCodec(t *testing.T) {
+	redisHub, stop := mockRedisHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+		cancel()
+	}()
+	assert.Equal(t, JSONCodec{}, redisHub.Config.Codec)
+
+	sub, err := redisHub.Subscribe(ctx, "topic")
+	assert.NoError(t, err)
+
+	// A payload that cannot be decoded is delivered as it is, e.g. plain text of redis-cli publishers.
+	err = redisHub.Client.Publish(ctx, "topic", "hello-john").Err()
+	assert.NoError(t, err)
+	err = redisHub.Publish(ctx, "topic", map[string]interface{}{"key": "value"})
+	assert.NoError(t, err)
+
+	msg := <-sub.MessageChannel
+	assert.Equal(t, "topic", msg.Topic)
+	assert.Equal(t, "hello-john", msg.Data)
+	msg = <-sub.MessageChannel
+	assert.Equal(t, "topic", msg.Topic)
+	assert.Equal(t, map[string]interface{}{"key": "value"}, msg.Data)
+}
+
+func TestRedisHubSubscribeFrom(t *testing.T) {
+	redisHub, stop := mockRedisHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+		cancel()
+	}()
+
+	_, err := redisHub.SubscribeFrom(ctx, "", "topic")
+	assert.Equal(t, ErrNotDurable, err)
+
+	redisHub.Config.StreamMaxLen = 100
+	redisHub.Config.StreamPrefix = "websub:"
+	assert.NoError(t, redisHub.Publish(ctx, "topic", "first"))
+	assert.NoError(t, redisHub.Publish(ctx, "topic", "second"))
+
+	// A new subscription receives only new messages.
+	sub, err := redisHub.SubscribeFrom(ctx, "", "topic")
+	assert.NoError(t, err)
+	assert.NoError(t, redisHub.Publish(ctx, "topic", "third"))
+	third := <-sub.MessageChannel
+	assert.Equal(t, "third", third.Data)
+	assert.Equal(t, "topic", third.Topic)
+	assert.NotEmpty(t, third.ID)
+
+	// A resumed subscription receives messages which are published after the last id.
+	first, err := redisHub.Client.XRange(ctx, "websub:topic", "-", "+").Result()
+	assert.NoError(t, err)
+	sub, err = redisHub.SubscribeFrom(ctx, first[0].ID, "topic")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", (<-sub.MessageChannel).Data)
+	assert.Equal(t, third.ID, (<-sub.MessageChannel).ID)
+
+	// Ids are per stream, so durable subscriptions to several topics are rejected.
+	_, err = redisHub.SubscribeFrom(ctx, third.ID, "topic", "other")
+	assert.Error(t, err)
+}
+
+func TestRedisHubPatternSubscribe(t *testing.T) {
+	redisHub, stop := mockRedisHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+		cancel()
+	}()
+	testHubPatternSubscribe(ctx, t, redisHub)
+}
+
+func TestRedisHubPatternSubscribe_SameGlob(t *testing.T) {
+	redisHub, stop := mockRedisHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+		cancel()
+	}()
+	// orders.> and orders.* have the same redis glob, messages matching any of them are delivered once.
+	sub, err := redisHub.Subscribe(ctx, "orders.>", "orders.*")
+	assert.NoError(t, err)
+	for _, topic := range []string{"orders.created.eu", "orders.created"} {
+		assert.NoError(t, redisHub.Publish(ctx, topic, topic))
+		assert.Equal(t, topic, (<-sub.MessageChannel).Topic)
+	}
+	select {
+	case msg := <-sub.MessageChannel:
+		t.Errorf("message of topic %s must be delivered once", msg.Topic)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRedisHubResubscribe(t *testing.T) {
+	// Connections are dialed to the current master like failover clients.
+	oldMaster, _ := miniredis.Run()
+	newMaster, _ := miniredis.Run()
+	defer oldMaster.Close()
+	defer newMaster.Close()
+	var mu sync.Mutex
+	master := oldMaster.Addr()
+	rc := redis.NewClient(&redis.Options{Dialer: func(context.Context, string, string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return net.Dial("tcp", master)
+	}})
+	redisHub := NewRedisHub(rc, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub, err := redisHub.Subscribe(ctx, "topic")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, oldMaster.PubSubNumSub("topic")["topic"])
+
+	mu.Lock()
+	master = newMaster.Addr()
+	mu.Unlock()
+	redisHub.Resubscribe()
+	for i := 0; i < 100 && oldMaster.PubSubNumSub("topic")["topic"] > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, oldMaster.PubSubNumSub("topic")["topic"])
+	assert.Equal(t, 1, newMaster.PubSubNumSub("topic")["topic"])
+
+	newMaster.Publish("topic", `"after failover"`)
+	select {
+	case msg := <-sub.MessageChannel:
+		assert.Equal(t, "after failover", msg.Data)
+	case <-time.After(time.Second):
+		t.Error("message is not received after failover")
+	}
+}
+
+func TestRedisHubSharded(t *testing.T) {
+	redisHub, stop := mockRedisHub()
+	defer stop()
+	redisHub.Config.Sharded = true
+
+	_, err := redisHub.Subscribe(context.Background(), "orders.*")
+	assert.Error(t, err)
+}
+
+func TestRedisHubQueueSubscribe(t *testing.T) {
+	redisHub, stop := mockRedisHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+		cancel()
+	}()
+	_, err := redisHub.QueueSubscribe(ctx, "workers", "commands")
+	assert.Equal(t, ErrNotDurable, err)
+
+	redisHub.Config.StreamMaxLen = 100
+	testHubQueueSubscribe(ctx, t, redisHub)
+}
+
+func TestRedisHubRequest(t *testing.T) {
+	redisHub, stop := mockRedisHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+		cancel()
+	}()
+	// Replies are not persisted in streams.
+	redisHub.Config.StreamMaxLen = 100
+	testHubRequest(ctx, t, redisHub)
+	keys, _ := redisHub.Client.Keys(ctx, InboxPrefix+"*").Result()
+	assert.Empty(t, keys)
+}
+
+func TestRedisHubRetain(t *testing.T) {
+	redisHub, stop := mockRedisHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+		cancel()
+	}()
+	assert.Equal(t, "retained:", redisHub.Config.RetainPrefix)
+	testHubRetain(ctx, t, redisHub)
+}
@@ -0,0 +1,53 @@
+package hub
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// InboxPrefix is prefix of reply topics of requests, it's the same as nats inboxes.
+const InboxPrefix = "_INBOX."
+
+// requestPrefix marks payloads of requests in drivers that don't carry reply topics(e.g. redis pub/sub),
+// such payloads are framed as requestPrefix + inbox + "\n" + encoded data.
+var requestPrefix = []byte("\x00websub-request:")
+
+// NewInbox returns a unique reply topic.
+func NewInbox() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error while generating inbox, error: %s", err.Error())
+	}
+	return InboxPrefix + hex.EncodeToString(b), nil
+}
+
+// IsInbox reports whether topic is a reply topic, messages of inboxes are not persisted.
+func IsInbox(topic string) bool {
+	return strings.HasPrefix(topic, InboxPrefix)
+}
+
+// frameRequest prepends the inbox to an encoded request.
+func frameRequest(inbox string, b []byte) []byte {
+	f := make([]byte, 0, len(requestPrefix)+len(inbox)+1+len(b))
+	f = append(f, requestPrefix...)
+	f = append(f, inbox...)
+	f = append(f, '\n')
+	return append(f, b...)
+}
+
+// unframeRequest returns the inbox and encoded data of a payload, inbox is empty when the payload is
+// not a request.
+func unframeRequest(p []byte) (string, []byte) {
+	if !bytes.HasPrefix(p, requestPrefix) {
+		return "", p
+	}
+	rest := p[len(requestPrefix):]
+	i := bytes.IndexByte(rest, '\n')
+	if i == -1 {
+		return "", p
+	}
+	return string(rest[:i]), rest[i+1:]
+}
@@ -0,0 +1,20 @@
+package hub
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFrameRequest(t *testing.T) {
+	inbox, err := NewInbox()
+	assert.NoError(t, err)
+	assert.True(t, IsInbox(inbox))
+
+	reply, p := unframeRequest(frameRequest(inbox, []byte(`{"key":"value"}`)))
+	assert.Equal(t, inbox, reply)
+	assert.Equal(t, `{"key":"value"}`, string(p))
+
+	reply, p = unframeRequest([]byte(`"plain"`))
+	assert.Empty(t, reply)
+	assert.Equal(t, `"plain"`, string(p))
+}
@@ -2,9 +2,12 @@ package hub
 
 import (
 	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"sort"
 	"sync"
 	"testing"
+	"time"
 )
 
 func testHubPubSub(ctx context.Context, t *testing.T, hub Hub) {
@@ -51,3 +54,141 @@ func testHubPubSub(ctx context.Context, t *testing.T, hub Hub) {
 
 	assert.EqualValues(t, publishingMessages, receivedMessages)
 }
+
+func testHubPatternSubscribe(ctx context.Context, t *testing.T, hub Hub) {
+	sub, err := hub.Subscribe(ctx, "orders.*", "payments.>")
+	assert.NoError(t, err)
+
+	// Messages of topics that don't match patterns must not be delivered.
+	for _, topic := range []string{"orders.created.eu", "orders", "orders.created", "payments.paid.eu"} {
+		err := hub.Publish(ctx, topic, topic)
+		assert.NoError(t, err)
+	}
+
+	// Messages of different patterns may be received in any order.
+	received := []string{(<-sub.MessageChannel).Topic, (<-sub.MessageChannel).Topic}
+	assert.ElementsMatch(t, []string{"orders.created", "payments.paid.eu"}, received)
+	select {
+	case msg := <-sub.MessageChannel:
+		t.Errorf("message of topic %s must not be delivered", msg.Topic)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func testHubQueueSubscribe(ctx context.Context, t *testing.T, hub QueueHub) {
+	// Members of a group share messages, other groups receive all messages.
+	workers := make([]*Subscription, 2)
+	for i := range workers {
+		sub, err := hub.QueueSubscribe(ctx, "workers", "commands")
+		assert.NoError(t, err)
+		workers[i] = sub
+	}
+	audit, err := hub.QueueSubscribe(ctx, "audit", "commands")
+	assert.NoError(t, err)
+
+	const count = 10
+	for i := 0; i < count; i++ {
+		assert.NoError(t, hub.Publish(ctx, "commands", fmt.Sprintf("command-%d", i)))
+	}
+
+	var mu sync.Mutex
+	received := map[interface{}]int{}
+	var wg sync.WaitGroup
+	wg.Add(count)
+	for _, sub := range workers {
+		go func(sub *Subscription) {
+			for {
+				select {
+				case msg := <-sub.MessageChannel:
+					mu.Lock()
+					received[msg.Data]++
+					mu.Unlock()
+					wg.Done()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(sub)
+	}
+	for i := 0; i < count; i++ {
+		assert.Equal(t, "commands", (<-audit.MessageChannel).Topic)
+	}
+	wg.Wait()
+	assert.Len(t, received, count)
+	for data, n := range received {
+		assert.Equal(t, 1, n, data)
+	}
+
+	_, err = hub.QueueSubscribe(ctx, "", "commands")
+	assert.Error(t, err)
+}
+
+func testHubRequest(ctx context.Context, t *testing.T, hub RequestHub) {
+	sub, err := hub.Subscribe(ctx, "rpc.unread")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			select {
+			case msg := <-sub.MessageChannel:
+				_ = hub.Publish(ctx, msg.Reply, fmt.Sprintf("%v has 3 unread messages", msg.Data))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	rctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	reply, err := hub.Request(rctx, "rpc.unread", "john")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "john has 3 unread messages", reply.Data)
+		assert.Equal(t, "rpc.unread", reply.Topic)
+	}
+
+	// Requests without responders fail when ctx is done.
+	rctx, cancel = context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = hub.Request(rctx, "rpc.missing", "john")
+	assert.Error(t, err)
+}
+
+func testHubRetain(ctx context.Context, t *testing.T, hub RetainHub) {
+	msgs, err := hub.Retained(ctx, "status.device1")
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	// Retained messages are published to current subscribers too.
+	sub, err := hub.Subscribe(ctx, "status.device1")
+	assert.NoError(t, err)
+	assert.NoError(t, hub.PublishRetained(ctx, "status.device1", "offline"))
+	select {
+	case msg := <-sub.MessageChannel:
+		assert.Equal(t, "offline", msg.Data)
+		assert.False(t, msg.Retained)
+	case <-time.After(time.Second):
+		t.Fatal("retained message is not published")
+	}
+	assert.NoError(t, hub.PublishRetained(ctx, "status.device1", "online"))
+	assert.NoError(t, hub.PublishRetained(ctx, "status.device2", map[string]interface{}{"battery": 0.5}))
+	assert.NoError(t, hub.PublishRetained(ctx, "alerts.device1", "low battery"))
+	// Messages that are not retained don't replace retained messages.
+	assert.NoError(t, hub.Publish(ctx, "status.device1", "rebooting"))
+
+	msgs, err = hub.Retained(ctx, "status.device1")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Message{{Topic: "status.device1", Data: "online", Retained: true}}, msgs)
+
+	msgs, err = hub.Retained(ctx, "status.*", "status.device1")
+	assert.NoError(t, err)
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Topic < msgs[j].Topic })
+	assert.Equal(t, []*Message{
+		{Topic: "status.device1", Data: "online", Retained: true},
+		{Topic: "status.device2", Data: map[string]interface{}{"battery": 0.5}, Retained: true},
+	}, msgs)
+
+	// Retained messages are removed with nil data.
+	assert.NoError(t, hub.PublishRetained(ctx, "status.device1", nil))
+	msgs, err = hub.Retained(ctx, "status.>")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Message{{Topic: "status.device2", Data: map[string]interface{}{"battery": 0.5}, Retained: true}}, msgs)
+}
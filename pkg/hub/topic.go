@@ -0,0 +1,64 @@
+package hub
+
+import "strings"
+
+// Topics are made of tokens which are separated by TokenSeparator, a topic pattern can contain wildcard
+// tokens that are the same as nats wildcards.
+const (
+	TokenSeparator = "."
+	// SingleWildcard matches exactly one token.
+	SingleWildcard = "*"
+	// MultiWildcard matches one or more tokens, it must be the last token of a pattern.
+	MultiWildcard = ">"
+)
+
+// IsPattern reports whether topic contains wildcard tokens.
+func IsPattern(topic string) bool {
+	for _, t := range strings.Split(topic, TokenSeparator) {
+		if t == SingleWildcard || t == MultiWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTopic reports whether topic matches pattern.
+func MatchTopic(pattern, topic string) bool {
+	pts := strings.Split(pattern, TokenSeparator)
+	tts := strings.Split(topic, TokenSeparator)
+	for i, pt := range pts {
+		if pt == MultiWildcard {
+			return i == len(pts)-1 && len(tts) > i
+		}
+		if i >= len(tts) || (pt != SingleWildcard && pt != tts[i]) {
+			return false
+		}
+	}
+	return len(pts) == len(tts)
+}
+
+// matchAny reports whether topic matches any of patterns.
+func matchAny(patterns []string, topic string) bool {
+	for _, p := range patterns {
+		if MatchTopic(p, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// redisGlob converts a topic pattern to a redis glob pattern which matches a superset of the topics
+// that are matched by the pattern.
+func redisGlob(pattern string) string {
+	tokens := strings.Split(pattern, TokenSeparator)
+	for i, t := range tokens {
+		if t == SingleWildcard || t == MultiWildcard {
+			tokens[i] = "*"
+			continue
+		}
+		tokens[i] = globEscaper.Replace(t)
+	}
+	return strings.Join(tokens, TokenSeparator)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
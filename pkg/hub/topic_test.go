@@ -0,0 +1,41 @@
+package hub
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIsPattern(t *testing.T) {
+	assert.True(t, IsPattern("orders.*"))
+	assert.True(t, IsPattern("orders.>"))
+	assert.False(t, IsPattern("orders"))
+	assert.False(t, IsPattern("orders*.created"))
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "orders.created", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.created.eu", false},
+		{"*.created", "orders.created", true},
+		{"orders.>", "orders.created.eu", true},
+		{"orders.>", "orders", false},
+		{">", "orders", true},
+		{"orders.>.eu", "orders.created.eu", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, MatchTopic(tt.pattern, tt.topic), "pattern %s and topic %s", tt.pattern, tt.topic)
+	}
+}
+
+func TestRedisGlob(t *testing.T) {
+	assert.Equal(t, "orders.*", redisGlob("orders.*"))
+	assert.Equal(t, "orders.*", redisGlob("orders.>"))
+	assert.Equal(t, `orders\*.\[eu\]`, redisGlob("orders*.[eu]"))
+}
@@ -0,0 +1,463 @@
+// Package mqtt encodes and decodes MQTT 3.1.1 control packets and translates MQTT topics to websub topics.
+// Packets that websub doesn't use(PUBREC, PUBREL and PUBCOMP of QoS 2) are not supported.
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Types of control packets.
+const (
+	CONNECT     byte = 1
+	CONNACK     byte = 2
+	PUBLISH     byte = 3
+	PUBACK      byte = 4
+	SUBSCRIBE   byte = 8
+	SUBACK      byte = 9
+	UNSUBSCRIBE byte = 10
+	UNSUBACK    byte = 11
+	PINGREQ     byte = 12
+	PINGRESP    byte = 13
+	DISCONNECT  byte = 14
+)
+
+// Return codes of CONNACK packets.
+const (
+	Accepted                    byte = 0
+	UnacceptableProtocolVersion byte = 1
+	IdentifierRejected          byte = 2
+	ServerUnavailable           byte = 3
+	BadUsernameOrPassword       byte = 4
+	NotAuthorized               byte = 5
+)
+
+// SubscribeFailure is the return code of a SUBACK packet for a rejected topic filter.
+const SubscribeFailure byte = 0x80
+
+// ProtocolLevel is the protocol level of MQTT 3.1.1.
+const ProtocolLevel byte = 4
+
+// maxRemainingLength is the largest remaining length that fits in four bytes.
+const maxRemainingLength = 268435455
+
+// ErrMalformed is returned when a packet violates the protocol.
+var ErrMalformed = errors.New("malformed mqtt packet")
+
+// Packet is a control packet.
+type Packet interface {
+	// Encode returns the packet on the wire.
+	Encode() []byte
+}
+
+// Will is the message that is published by the server when a client disconnects without a DISCONNECT.
+type Will struct {
+	Topic   string
+	Message []byte
+	QoS     byte
+	Retain  bool
+}
+
+// ConnectPacket is the first packet of a client.
+type ConnectPacket struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanSession  bool
+	KeepAlive     uint16
+	ClientID      string
+	Will          *Will
+	Username      *string
+	Password      []byte
+}
+
+// ConnackPacket acknowledges a CONNECT.
+type ConnackPacket struct {
+	SessionPresent bool
+	ReturnCode     byte
+}
+
+// PublishPacket carries a message, PacketID is only set for QoS 1 and 2.
+type PublishPacket struct {
+	Dup      bool
+	QoS      byte
+	Retain   bool
+	Topic    string
+	PacketID uint16
+	Payload  []byte
+}
+
+// PubackPacket acknowledges a QoS 1 PUBLISH.
+type PubackPacket struct {
+	PacketID uint16
+}
+
+// Subscription is a topic filter of a SUBSCRIBE with its requested QoS.
+type Subscription struct {
+	Filter string
+	QoS    byte
+}
+
+// SubscribePacket subscribes to topic filters.
+type SubscribePacket struct {
+	PacketID      uint16
+	Subscriptions []Subscription
+}
+
+// SubackPacket acknowledges a SUBSCRIBE with the granted QoS or SubscribeFailure of each filter.
+type SubackPacket struct {
+	PacketID    uint16
+	ReturnCodes []byte
+}
+
+// UnsubscribePacket unsubscribes from topic filters.
+type UnsubscribePacket struct {
+	PacketID uint16
+	Filters  []string
+}
+
+// UnsubackPacket acknowledges an UNSUBSCRIBE.
+type UnsubackPacket struct {
+	PacketID uint16
+}
+
+// PingreqPacket is a keep alive of a client.
+type PingreqPacket struct{}
+
+// PingrespPacket answers a PINGREQ.
+type PingrespPacket struct{}
+
+// DisconnectPacket is the last packet of a client that disconnects cleanly.
+type DisconnectPacket struct{}
+
+// ReadPacket reads a control packet, packets larger than maxSize are rejected when it's positive.
+func ReadPacket(r io.ByteReader, maxSize int) (Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readRemainingLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && length > maxSize {
+		return nil, fmt.Errorf("mqtt packet of %d bytes is larger than %d bytes", length, maxSize)
+	}
+	body := make([]byte, length)
+	for i := range body {
+		if body[i], err = r.ReadByte(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return decode(header, body)
+}
+
+func readRemainingLength(r io.ByteReader) (int, error) {
+	length, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length += int(b&127) * multiplier
+		if b&128 == 0 {
+			return length, nil
+		}
+		multiplier *= 128
+	}
+	return 0, ErrMalformed
+}
+
+func decode(header byte, body []byte) (Packet, error) {
+	flags := header & 0x0f
+	d := &decoder{b: body}
+	var p Packet
+	switch header >> 4 {
+	case CONNECT:
+		p = decodeConnect(d)
+	case CONNACK:
+		ack := &ConnackPacket{SessionPresent: d.byte()&1 == 1}
+		ack.ReturnCode = d.byte()
+		p = ack
+	case PUBLISH:
+		pp := &PublishPacket{Dup: flags&8 != 0, QoS: flags >> 1 & 3, Retain: flags&1 != 0}
+		if pp.QoS > 2 {
+			return nil, ErrMalformed
+		}
+		pp.Topic = d.string()
+		if pp.QoS > 0 {
+			pp.PacketID = d.uint16()
+		}
+		pp.Payload = d.rest()
+		p = pp
+	case PUBACK:
+		p = &PubackPacket{PacketID: d.uint16()}
+	case SUBSCRIBE:
+		if flags != 2 {
+			return nil, ErrMalformed
+		}
+		sp := &SubscribePacket{PacketID: d.uint16()}
+		for d.err == nil && len(d.b) > 0 {
+			sp.Subscriptions = append(sp.Subscriptions, Subscription{Filter: d.string(), QoS: d.byte()})
+		}
+		if len(sp.Subscriptions) == 0 {
+			return nil, ErrMalformed
+		}
+		p = sp
+	case SUBACK:
+		sa := &SubackPacket{PacketID: d.uint16()}
+		sa.ReturnCodes = d.rest()
+		p = sa
+	case UNSUBSCRIBE:
+		if flags != 2 {
+			return nil, ErrMalformed
+		}
+		up := &UnsubscribePacket{PacketID: d.uint16()}
+		for d.err == nil && len(d.b) > 0 {
+			up.Filters = append(up.Filters, d.string())
+		}
+		if len(up.Filters) == 0 {
+			return nil, ErrMalformed
+		}
+		p = up
+	case UNSUBACK:
+		p = &UnsubackPacket{PacketID: d.uint16()}
+	case PINGREQ:
+		p = &PingreqPacket{}
+	case PINGRESP:
+		p = &PingrespPacket{}
+	case DISCONNECT:
+		p = &DisconnectPacket{}
+	default:
+		return nil, fmt.Errorf("mqtt packet type %d is not supported", header>>4)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return p, nil
+}
+
+func decodeConnect(d *decoder) Packet {
+	cp := &ConnectPacket{ProtocolName: d.string(), ProtocolLevel: d.byte()}
+	flags := d.byte()
+	cp.CleanSession = flags&2 != 0
+	cp.KeepAlive = d.uint16()
+	cp.ClientID = d.string()
+	if flags&4 != 0 {
+		cp.Will = &Will{Topic: d.string(), Message: d.bytes(), QoS: flags >> 3 & 3, Retain: flags&32 != 0}
+	}
+	if flags&128 != 0 {
+		u := d.string()
+		cp.Username = &u
+	}
+	if flags&64 != 0 {
+		cp.Password = d.bytes()
+	}
+	// The reserved flag must be zero.
+	if flags&1 != 0 {
+		d.fail()
+	}
+	return cp
+}
+
+// decoder reads fields of a packet body, the first error is kept and later reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ErrMalformed
+	}
+	d.b = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	if len(d.b) < 2 {
+		d.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if len(d.b) < n {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) rest() []byte {
+	v := d.b
+	d.b = nil
+	return v
+}
+
+// encoder builds packet bodies.
+type encoder []byte
+
+func (e encoder) byte(b byte) encoder {
+	return append(e, b)
+}
+
+func (e encoder) uint16(v uint16) encoder {
+	return append(e, byte(v>>8), byte(v))
+}
+
+func (e encoder) bytes(b []byte) encoder {
+	return append(e.uint16(uint16(len(b))), b...)
+}
+
+func (e encoder) string(s string) encoder {
+	return e.bytes([]byte(s))
+}
+
+// packet returns the packet with its fixed header.
+func (e encoder) packet(header byte) []byte {
+	out := []byte{header}
+	length := len(e)
+	if length > maxRemainingLength {
+		length = maxRemainingLength
+	}
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 128
+		}
+		out = append(out, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(out, e...)
+}
+
+// Encode returns the packet on the wire.
+func (p *ConnectPacket) Encode() []byte {
+	var flags byte
+	if p.CleanSession {
+		flags |= 2
+	}
+	if p.Will != nil {
+		flags |= 4 | p.Will.QoS<<3
+		if p.Will.Retain {
+			flags |= 32
+		}
+	}
+	if p.Password != nil {
+		flags |= 64
+	}
+	if p.Username != nil {
+		flags |= 128
+	}
+	e := encoder{}.string(p.ProtocolName).byte(p.ProtocolLevel).byte(flags).uint16(p.KeepAlive).string(p.ClientID)
+	if p.Will != nil {
+		e = e.string(p.Will.Topic).bytes(p.Will.Message)
+	}
+	if p.Username != nil {
+		e = e.string(*p.Username)
+	}
+	if p.Password != nil {
+		e = e.bytes(p.Password)
+	}
+	return e.packet(CONNECT << 4)
+}
+
+// Encode returns the packet on the wire.
+func (p *ConnackPacket) Encode() []byte {
+	var sp byte
+	if p.SessionPresent {
+		sp = 1
+	}
+	return encoder{sp, p.ReturnCode}.packet(CONNACK << 4)
+}
+
+// Encode returns the packet on the wire.
+func (p *PublishPacket) Encode() []byte {
+	header := PUBLISH<<4 | p.QoS<<1
+	if p.Dup {
+		header |= 8
+	}
+	if p.Retain {
+		header |= 1
+	}
+	e := encoder{}.string(p.Topic)
+	if p.QoS > 0 {
+		e = e.uint16(p.PacketID)
+	}
+	return append(e, p.Payload...).packet(header)
+}
+
+// Encode returns the packet on the wire.
+func (p *PubackPacket) Encode() []byte {
+	return encoder{}.uint16(p.PacketID).packet(PUBACK << 4)
+}
+
+// Encode returns the packet on the wire.
+func (p *SubscribePacket) Encode() []byte {
+	e := encoder{}.uint16(p.PacketID)
+	for _, s := range p.Subscriptions {
+		e = e.string(s.Filter).byte(s.QoS)
+	}
+	return e.packet(SUBSCRIBE<<4 | 2)
+}
+
+// Encode returns the packet on the wire.
+func (p *SubackPacket) Encode() []byte {
+	return append(encoder{}.uint16(p.PacketID), p.ReturnCodes...).packet(SUBACK << 4)
+}
+
+// Encode returns the packet on the wire.
+func (p *UnsubscribePacket) Encode() []byte {
+	e := encoder{}.uint16(p.PacketID)
+	for _, f := range p.Filters {
+		e = e.string(f)
+	}
+	return e.packet(UNSUBSCRIBE<<4 | 2)
+}
+
+// Encode returns the packet on the wire.
+func (p *UnsubackPacket) Encode() []byte {
+	return encoder{}.uint16(p.PacketID).packet(UNSUBACK << 4)
+}
+
+// Encode returns the packet on the wire.
+func (p *PingreqPacket) Encode() []byte {
+	return encoder{}.packet(PINGREQ << 4)
+}
+
+// Encode returns the packet on the wire.
+func (p *PingrespPacket) Encode() []byte {
+	return encoder{}.packet(PINGRESP << 4)
+}
+
+// Encode returns the packet on the wire.
+func (p *DisconnectPacket) Encode() []byte {
+	return encoder{}.packet(DISCONNECT << 4)
+}
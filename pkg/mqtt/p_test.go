@@ -0,0 +1,69 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadPacket(t *testing.T) {
+	username := "device1"
+	packets := []Packet{
+		&ConnectPacket{
+			ProtocolName:  "MQTT",
+			ProtocolLevel: ProtocolLevel,
+			CleanSession:  true,
+			KeepAlive:     30,
+			ClientID:      "c1",
+			Will:          &Will{Topic: "status/device1", Message: []byte("offline"), Retain: true},
+			Username:      &username,
+			Password:      []byte("secret"),
+		},
+		&ConnackPacket{SessionPresent: true, ReturnCode: NotAuthorized},
+		&PublishPacket{Topic: "a/b", Payload: []byte("hello"), Retain: true},
+		&PublishPacket{Topic: "a/b", QoS: 1, PacketID: 7, Dup: true, Payload: bytes.Repeat([]byte("x"), 300)},
+		&PubackPacket{PacketID: 7},
+		&SubscribePacket{PacketID: 1, Subscriptions: []Subscription{{Filter: "a/+", QoS: 1}, {Filter: "#"}}},
+		&SubackPacket{PacketID: 1, ReturnCodes: []byte{0, SubscribeFailure}},
+		&UnsubscribePacket{PacketID: 2, Filters: []string{"a/+", "#"}},
+		&UnsubackPacket{PacketID: 2},
+		&PingreqPacket{},
+		&PingrespPacket{},
+		&DisconnectPacket{},
+	}
+	buf := &bytes.Buffer{}
+	for _, p := range packets {
+		buf.Write(p.Encode())
+	}
+	for _, want := range packets {
+		p, err := ReadPacket(buf, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, want, p)
+	}
+}
+
+func TestReadPacket_Invalid(t *testing.T) {
+	big := (&PublishPacket{Topic: "a", Payload: make([]byte, 200)}).Encode()
+	_, err := ReadPacket(bytes.NewBuffer(big), 100)
+	assert.Error(t, err)
+
+	// Subscribe packets without filters and with invalid flags are malformed.
+	_, err = ReadPacket(bytes.NewBuffer([]byte{SUBSCRIBE<<4 | 2, 2, 0, 1}), 0)
+	assert.Equal(t, ErrMalformed, err)
+	_, err = ReadPacket(bytes.NewBuffer((&SubscribePacket{PacketID: 1, Subscriptions: []Subscription{{Filter: "a"}}}).Encode()[1:]), 0)
+	assert.Error(t, err)
+	b := (&SubscribePacket{PacketID: 1, Subscriptions: []Subscription{{Filter: "a"}}}).Encode()
+	b[0] = SUBSCRIBE << 4
+	_, err = ReadPacket(bytes.NewBuffer(b), 0)
+	assert.Equal(t, ErrMalformed, err)
+
+	// A string that is longer than the packet.
+	_, err = ReadPacket(bytes.NewBuffer([]byte{PUBLISH << 4, 3, 0, 9, 'a'}), 0)
+	assert.Equal(t, ErrMalformed, err)
+
+	_, err = ReadPacket(bytes.NewBuffer([]byte{PUBLISH << 4, 10, 0}), 0)
+	assert.Error(t, err)
+	_, err = ReadPacket(bytes.NewBuffer([]byte{5 << 4, 0}), 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mammadmodi/websub/pkg/hub"
+)
+
+// Levels of mqtt topics are separated by LevelSeparator, filters can contain SingleLevelWildcard and
+// MultiLevelWildcard which are translated to wildcard tokens of websub topics.
+const (
+	LevelSeparator      = "/"
+	SingleLevelWildcard = "+"
+	MultiLevelWildcard  = "#"
+)
+
+// ToTopic translates an mqtt topic name to a websub topic, levels can't be empty or contain the websub
+// token separator and wildcards.
+func ToTopic(name string) (string, error) {
+	levels := strings.Split(name, LevelSeparator)
+	for _, l := range levels {
+		if err := validLevel(name, l); err != nil {
+			return "", err
+		}
+		if l == SingleLevelWildcard || l == MultiLevelWildcard || strings.ContainsAny(l, "+#") {
+			return "", fmt.Errorf("mqtt topic name '%s' contains wildcards", name)
+		}
+	}
+	return strings.Join(levels, hub.TokenSeparator), nil
+}
+
+// ToPatterns translates an mqtt topic filter to websub topic patterns, a filter that ends with the multi
+// level wildcard also matches its parent level so it's translated to two patterns.
+func ToPatterns(filter string) ([]string, error) {
+	levels := strings.Split(filter, LevelSeparator)
+	tokens := make([]string, len(levels))
+	for i, l := range levels {
+		switch l {
+		case SingleLevelWildcard:
+			tokens[i] = hub.SingleWildcard
+		case MultiLevelWildcard:
+			if i != len(levels)-1 {
+				return nil, fmt.Errorf("multi level wildcard of mqtt topic filter '%s' is not the last level", filter)
+			}
+			tokens[i] = hub.MultiWildcard
+		default:
+			if err := validLevel(filter, l); err != nil {
+				return nil, err
+			}
+			if strings.ContainsAny(l, "+#") {
+				return nil, fmt.Errorf("wildcards of mqtt topic filter '%s' are not whole levels", filter)
+			}
+			tokens[i] = l
+		}
+	}
+	patterns := []string{strings.Join(tokens, hub.TokenSeparator)}
+	if len(tokens) > 1 && tokens[len(tokens)-1] == hub.MultiWildcard {
+		patterns = append(patterns, strings.Join(tokens[:len(tokens)-1], hub.TokenSeparator))
+	}
+	return patterns, nil
+}
+
+// FromTopic translates a websub topic to an mqtt topic name.
+func FromTopic(topic string) string {
+	return strings.ReplaceAll(topic, hub.TokenSeparator, LevelSeparator)
+}
+
+func validLevel(name, level string) error {
+	if level == "" {
+		return fmt.Errorf("mqtt topic '%s' has an empty level", name)
+	}
+	if strings.Contains(level, hub.TokenSeparator) || level == hub.SingleWildcard || level == hub.MultiWildcard {
+		return fmt.Errorf("level '%s' of mqtt topic '%s' is not a valid websub token", level, name)
+	}
+	return nil
+}
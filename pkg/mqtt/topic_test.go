@@ -0,0 +1,40 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToTopic(t *testing.T) {
+	topic, err := ToTopic("devices/device1/alerts")
+	assert.NoError(t, err)
+	assert.Equal(t, "devices.device1.alerts", topic)
+	assert.Equal(t, "devices/device1/alerts", FromTopic(topic))
+
+	for _, name := range []string{"devices/+/alerts", "devices/#", "a+b", "a.b", "a/*", "a/>", "/a", "a//b", ""} {
+		_, err := ToTopic(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestToPatterns(t *testing.T) {
+	cases := map[string][]string{
+		"devices/device1/alerts": {"devices.device1.alerts"},
+		"devices/+/alerts":       {"devices.*.alerts"},
+		"devices/#":              {"devices.>", "devices"},
+		"devices/+/#":            {"devices.*.>", "devices.*"},
+		"#":                      {">"},
+		"+":                      {"*"},
+	}
+	for filter, want := range cases {
+		patterns, err := ToPatterns(filter)
+		assert.NoError(t, err, filter)
+		assert.Equal(t, want, patterns, filter)
+	}
+
+	for _, filter := range []string{"devices/#/alerts", "devices/a#", "a+/b", "a.b/c", "a/*", "", "a//b"} {
+		_, err := ToPatterns(filter)
+		assert.Error(t, err, filter)
+	}
+}
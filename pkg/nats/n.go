@@ -2,30 +2,163 @@ package nats
 
 import (
 	"fmt"
+	"github.com/mammadmodi/websub/pkg/tlsconfig"
 	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
 	"time"
 )
 
+var (
+	// connectionEvents counts disconnects, reconnects and closes of nats connections.
+	connectionEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "nats",
+		Name:      "connection_events_total",
+		Help:      "Number of state changes of nats connections.",
+	}, []string{"event"})
+	// connected is 1 when the nats connection is connected.
+	connected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "websub",
+		Subsystem: "nats",
+		Name:      "connected",
+		Help:      "Whether the nats connection is connected.",
+	})
+)
+
 type Configs struct {
+	// Address is comma separated list of server urls, e.g. nats://n1:4222,nats://n2:4222.
 	Address             string        `default:"127.0.0.1:4222"`
 	ConnectTimeout      time.Duration `split_words:"true" default:"20s"`
 	ReconnectWait       time.Duration `split_words:"true" default:"5s"`
 	PingInterval        time.Duration `split_words:"true" default:"30s"`
 	MaxPingsOutstanding int           `split_words:"true" default:"5"`
+	// MaxReconnects is number of reconnect attempts before closing the connection, -1 retries forever.
+	MaxReconnects int `split_words:"true" default:"-1"`
+	// Name is prefix of connection name, it's followed by InstanceID(hostname by default) so connections
+	// of each websub instance can be identified in nats monitoring.
+	Name       string `default:"websub"`
+	InstanceID string `split_words:"true"`
+	// Only one of Token, User and Password, NKeyFile(a seed file) or CredsFile(a jwt credentials file)
+	// is used to authenticate.
+	Token     string
+	User      string
+	Password  string
+	NKeyFile  string `envconfig:"nkey_file"`
+	CredsFile string `split_words:"true"`
+	TLS       tlsconfig.Configs
 }
 
+// NewClient connects to nats servers, state changes of the connection are counted by metrics.
 func NewClient(configs Configs) (natsClient *nats.Conn, err error) {
-	conn, err := nats.Connect(configs.Address,
+	return NewClientWithLogger(configs, nil)
+}
+
+// NewClientWithLogger connects to nats servers like NewClient and logs state changes of the connection.
+func NewClientWithLogger(configs Configs, logger *logrus.Logger) (natsClient *nats.Conn, err error) {
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(ioutil.Discard)
+	}
+	name, err := connectionName(configs)
+	if err != nil {
+		return nil, err
+	}
+	opts := []nats.Option{
+		nats.Name(name),
 		nats.Timeout(configs.ConnectTimeout),
 		nats.PingInterval(configs.PingInterval),
 		nats.RetryOnFailedConnect(true),
 		nats.ReconnectWait(configs.ReconnectWait),
+		nats.MaxReconnects(configs.MaxReconnects),
 		nats.MaxPingsOutstanding(configs.MaxPingsOutstanding),
-	)
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			connectionEvents.WithLabelValues("disconnected").Inc()
+			connected.Set(0)
+			logger.WithField("name", name).WithError(err).Warn("disconnected from nats server")
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			connectionEvents.WithLabelValues("reconnected").Inc()
+			connected.Set(1)
+			logger.WithField("name", name).WithField("server", nc.ConnectedUrl()).Info("reconnected to nats server")
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			connectionEvents.WithLabelValues("closed").Inc()
+			connected.Set(0)
+			logger.WithField("name", name).WithError(nc.LastError()).Warn("nats connection is closed")
+		}),
+		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+			connectionEvents.WithLabelValues("error").Inc()
+			l := logger.WithField("name", name).WithError(err)
+			if sub != nil {
+				l = l.WithField("subject", sub.Subject)
+			}
+			l.Error("nats connection error")
+		}),
+	}
+	authOpts, err := authOptions(configs)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, authOpts...)
+	if configs.TLS.Enabled {
+		tc, err := tlsconfig.NewClientConfig(configs.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("error while creating nats tls config, error: %s", err.Error())
+		}
+		opts = append(opts, nats.Secure(tc))
+	}
+	conn, err := nats.Connect(configs.Address, opts...)
 
 	if err != nil {
 		return nil, fmt.Errorf("error while connecting to nats server, error: %s", err.Error())
 	}
+	if conn.IsConnected() {
+		connected.Set(1)
+	}
 
 	return conn, nil
 }
+
+// connectionName returns name of the connection followed by the instance id.
+func connectionName(configs Configs) (string, error) {
+	id := configs.InstanceID
+	if id == "" {
+		var err error
+		if id, err = os.Hostname(); err != nil {
+			return "", fmt.Errorf("error while getting hostname as instance id, error: %s", err.Error())
+		}
+	}
+	if configs.Name == "" {
+		return id, nil
+	}
+	return fmt.Sprintf("%s-%s", configs.Name, id), nil
+}
+
+// authOptions returns the option of the configured authentication method.
+func authOptions(configs Configs) ([]nats.Option, error) {
+	var opts []nats.Option
+	if configs.Token != "" {
+		opts = append(opts, nats.Token(configs.Token))
+	}
+	if configs.User != "" {
+		opts = append(opts, nats.UserInfo(configs.User, configs.Password))
+	}
+	if configs.NKeyFile != "" {
+		o, err := nats.NkeyOptionFromSeed(configs.NKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading nkey seed file, error: %s", err.Error())
+		}
+		opts = append(opts, o)
+	}
+	if configs.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(configs.CredsFile))
+	}
+	if len(opts) > 1 {
+		return nil, fmt.Errorf("only one nats authentication method can be configured")
+	}
+	return opts, nil
+}
@@ -2,6 +2,7 @@ package nats
 
 import (
 	natsserver "github.com/nats-io/nats-server/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -14,6 +15,9 @@ func TestNewClient(t *testing.T) {
 		ReconnectWait:       10 * time.Second,
 		PingInterval:        10 * time.Second,
 		MaxPingsOutstanding: 5,
+		MaxReconnects:       -1,
+		Name:                "websub",
+		InstanceID:          "pod-1",
 	}
 
 	// Setup nats test server.
@@ -32,6 +36,71 @@ func TestNewClient(t *testing.T) {
 		assert.Equal(t, configs.ReconnectWait, nc.Opts.ReconnectWait)
 		assert.Equal(t, configs.PingInterval, nc.Opts.PingInterval)
 		assert.Equal(t, configs.MaxPingsOutstanding, nc.Opts.MaxPingsOut)
-		assert.Equal(t, configs.MaxPingsOutstanding, nc.Opts.MaxPingsOut)
+		assert.Equal(t, configs.MaxReconnects, nc.Opts.MaxReconnect)
+		assert.Equal(t, "websub-pod-1", nc.Opts.Name)
+		assert.Equal(t, float64(1), testutil.ToFloat64(connected))
+	}
+}
+
+func TestNewClientAuth(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = 8367
+	opts.Username = "websub"
+	opts.Password = "secret"
+	ns := natsserver.RunServer(&opts)
+	defer ns.Shutdown()
+
+	configs := Configs{Address: "nats://127.0.0.1:8367", ConnectTimeout: time.Second, User: "websub", Password: "secret"}
+	nc, err := NewClient(configs)
+	if assert.NoError(t, err) {
+		assert.True(t, nc.IsConnected())
+		nc.Close()
+	}
+
+	configs.Token = "token"
+	_, err = NewClient(configs)
+	assert.Error(t, err)
+
+	_, err = NewClient(Configs{Address: "nats://127.0.0.1:8367", NKeyFile: "not-exists.nk"})
+	assert.Error(t, err)
+}
+
+func TestNewClientReconnect(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = 8368
+	ns := natsserver.RunServer(&opts)
+
+	configs := Configs{
+		Address:        "nats://127.0.0.1:8368",
+		ConnectTimeout: time.Second,
+		ReconnectWait:  50 * time.Millisecond,
+		MaxReconnects:  -1,
+	}
+	disconnects := testutil.ToFloat64(connectionEvents.WithLabelValues("disconnected"))
+	reconnects := testutil.ToFloat64(connectionEvents.WithLabelValues("reconnected"))
+	nc, err := NewClient(configs)
+	if !assert.NoError(t, err) {
+		ns.Shutdown()
+		return
+	}
+	defer nc.Close()
+
+	ns.Shutdown()
+	waitFor(t, func() bool { return testutil.ToFloat64(connectionEvents.WithLabelValues("disconnected")) > disconnects })
+	assert.Equal(t, float64(0), testutil.ToFloat64(connected))
+
+	ns = natsserver.RunServer(&opts)
+	defer ns.Shutdown()
+	waitFor(t, func() bool { return testutil.ToFloat64(connectionEvents.WithLabelValues("reconnected")) > reconnects })
+	assert.Equal(t, float64(1), testutil.ToFloat64(connected))
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+	t.Fatal("condition is not satisfied")
 }
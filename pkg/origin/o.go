@@ -0,0 +1,135 @@
+// Package origin checks Origin header of browser requests against a list of allowed origins.
+package origin
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Special patterns of policies.
+const (
+	// Any allows all origins.
+	Any = "*"
+	// SameHost allows origins whose host is the same as host of the request.
+	SameHost = "same-host"
+)
+
+// RejectedOrigins counts requests that are rejected because of their origin.
+var RejectedOrigins = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "websub",
+	Name:      "rejected_origins_total",
+	Help:      "Number of requests that are rejected because of their origin.",
+}, []string{"endpoint"})
+
+// Policy is a list of allowed origin patterns, a pattern can be an exact origin(https://example.com),
+// a wildcard subdomain(https://*.example.com), a regex between slashes(/^https://.+\.example\.com$/),
+// SameHost or Any. The zero Policy allows only origins of the same host, all origins are allowed only with Any.
+type Policy struct {
+	patterns []string
+	any      bool
+	sameHost bool
+	exact    map[string]bool
+	suffixes []wildcard
+	regexps  []*regexp.Regexp
+}
+
+type wildcard struct {
+	scheme string
+	suffix string
+}
+
+// NewPolicy compiles patterns to a Policy.
+func NewPolicy(patterns ...string) (*Policy, error) {
+	p := &Policy{}
+	if err := p.compile(patterns); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Decode compiles comma separated patterns, it's used by envconfig to load policies.
+func (p *Policy) Decode(value string) error {
+	var patterns []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			patterns = append(patterns, v)
+		}
+	}
+	return p.compile(patterns)
+}
+
+func (p *Policy) compile(patterns []string) error {
+	*p = Policy{patterns: patterns, exact: make(map[string]bool)}
+	for _, pt := range patterns {
+		switch {
+		case pt == Any:
+			p.any = true
+		case pt == SameHost:
+			p.sameHost = true
+		case len(pt) > 1 && strings.HasPrefix(pt, "/") && strings.HasSuffix(pt, "/"):
+			re, err := regexp.Compile(pt[1 : len(pt)-1])
+			if err != nil {
+				return fmt.Errorf("invalid origin regex %s, error: %s", pt, err.Error())
+			}
+			p.regexps = append(p.regexps, re)
+		case strings.Contains(pt, "://*."):
+			parts := strings.SplitN(pt, "://*.", 2)
+			p.suffixes = append(p.suffixes, wildcard{scheme: strings.ToLower(parts[0]), suffix: "." + strings.ToLower(parts[1])})
+		default:
+			u, err := url.Parse(pt)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("'%s' is not a valid origin", pt)
+			}
+			p.exact[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+		}
+	}
+	return nil
+}
+
+// String returns patterns of the policy.
+func (p *Policy) String() string {
+	return strings.Join(p.patterns, ",")
+}
+
+// Allowed reports whether origin of the request is allowed, requests without Origin header are not sent
+// by browsers and are allowed.
+func (p *Policy) Allowed(r *http.Request) bool {
+	o := r.Header.Get("Origin")
+	if o == "" {
+		return true
+	}
+	return p.AllowedOrigin(o, r.Host)
+}
+
+// AllowedOrigin reports whether origin is allowed for a request to host.
+func (p *Policy) AllowedOrigin(origin, host string) bool {
+	if p.any {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if (p.sameHost || len(p.patterns) == 0) && strings.EqualFold(u.Host, host) {
+		return true
+	}
+	if p.exact[strings.ToLower(u.Scheme+"://"+u.Host)] {
+		return true
+	}
+	for _, w := range p.suffixes {
+		if strings.EqualFold(u.Scheme, w.scheme) && strings.HasSuffix(strings.ToLower(u.Host), w.suffix) {
+			return true
+		}
+	}
+	for _, re := range p.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package origin
+
+import (
+	"github.com/kelseyhightower/envconfig"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestPolicy_AllowedOrigin(t *testing.T) {
+	p, err := NewPolicy("https://example.com", "https://*.example.org", `/^http://localhost:\d+$/`, SameHost)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://example.com", true},
+		{"HTTPS://EXAMPLE.COM", true},
+		{"http://example.com", false},
+		{"https://sub.example.com", false},
+		{"https://app.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"http://app.example.org", false},
+		{"https://evilexample.org", false},
+		{"http://localhost:3000", true},
+		{"http://localhost", false},
+		{"https://websub.io:8379", true},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.allowed, p.AllowedOrigin(tt.origin, "websub.io:8379"), tt.origin)
+	}
+}
+
+func TestPolicy_Allowed(t *testing.T) {
+	p, _ := NewPolicy("https://example.com")
+	r := httptest.NewRequest("GET", "/socket/connect", nil)
+	assert.True(t, p.Allowed(r), "requests without origin must be allowed")
+	r.Header.Set("Origin", "https://evil.com")
+	assert.False(t, p.Allowed(r))
+
+	// The zero policy allows only origins of the same host, all origins are allowed only with Any.
+	assert.False(t, (&Policy{}).Allowed(r))
+	assert.True(t, (&Policy{}).AllowedOrigin("https://websub.io", "websub.io"))
+	p, _ = NewPolicy(Any)
+	assert.True(t, p.Allowed(r))
+}
+
+func TestNewPolicy(t *testing.T) {
+	_, err := NewPolicy("/[/")
+	assert.Error(t, err)
+	_, err = NewPolicy("example.com")
+	assert.Error(t, err)
+}
+
+func TestPolicy_Decode(t *testing.T) {
+	var c struct {
+		AllowedOrigins Policy `default:"*" split_words:"true"`
+	}
+	_ = os.Setenv("ORIGIN_TEST_ALLOWED_ORIGINS", "https://example.com, same-host")
+	defer os.Unsetenv("ORIGIN_TEST_ALLOWED_ORIGINS")
+	err := envconfig.Process("origin_test", &c)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://example.com,same-host", c.AllowedOrigins.String())
+		assert.True(t, c.AllowedOrigins.AllowedOrigin("https://example.com", "websub.io"))
+		assert.False(t, c.AllowedOrigins.AllowedOrigin("https://evil.com", "websub.io"))
+	}
+
+	_ = os.Setenv("ORIGIN_TEST_ALLOWED_ORIGINS", "/[/")
+	assert.Error(t, envconfig.Process("origin_test", &c))
+}
@@ -1,9 +1,11 @@
-// Package redis is an abstraction layer in top of "github.com/go-redis/redis" package.
+// Package redis is an abstraction layer in top of "github.com/redis/go-redis/v9" package.
 package redis
 
 import (
+	"crypto/tls"
 	"fmt"
-	"github.com/go-redis/redis"
+	"github.com/mammadmodi/websub/pkg/tlsconfig"
+	"github.com/redis/go-redis/v9"
 	"time"
 )
 
@@ -13,69 +15,121 @@ type Mode string
 const (
 	Cluster    Mode = "cluster"
 	SingleNode Mode = "single_node"
+	Sentinel   Mode = "sentinel"
 )
 
 type Configs struct {
-	Mode               Mode          `split_words:"true" default:"single_node"`
-	Address            string        `split_words:"true" default:"127.0.0.1:6379"`
-	MasterAddress      string        `split_words:"true" default:"127.0.0.1:6379"`
-	SlaveAddress       string        `split_words:"true" default:"127.0.0.1:6379"`
-	DB                 int           `split_words:"true" default:"0"`
-	Password           string        `split_words:"true" default:""`
-	PoolSize           int           `split_words:"true" default:"10"`
-	MaxRetries         int           `split_words:"true" default:"1"`
-	DialTimeout        time.Duration `split_words:"true" default:"5s"`
-	ReadTimeout        time.Duration `split_words:"true" default:"250ms"`
-	WriteTimeout       time.Duration `split_words:"true" default:"400ms"`
-	PoolTimeout        time.Duration `split_words:"true" default:"4s"`
-	MinRetryBackoff    time.Duration `split_words:"true" default:"20ms"`
-	MaxRetryBackoff    time.Duration `split_words:"true" default:"80ms"`
-	IdleTimeout        time.Duration `split_words:"true" default:"60s"`
-	IdleCheckFrequency time.Duration `split_words:"true" default:"60s"`
-	ReadOnly           bool          `split_words:"true" default:"true"`
-	RouteRandomly      bool          `split_words:"true" default:"false"`
+	Mode    Mode   `split_words:"true" default:"single_node"`
+	Address string `split_words:"true" default:"127.0.0.1:6379"`
+	// Addresses is the comma separated seed list of cluster nodes, other nodes are discovered from them.
+	Addresses []string `split_words:"true" default:"127.0.0.1:6379"`
+	DB        int      `split_words:"true" default:"0"`
+	// Username is the ACL user(Redis 6+), the password authenticates the default user when it's empty.
+	Username          string        `split_words:"true" default:""`
+	Password          string        `split_words:"true" default:""`
+	PoolSize          int           `split_words:"true" default:"10"`
+	MaxRetries        int           `split_words:"true" default:"1"`
+	DialTimeout       time.Duration `split_words:"true" default:"5s"`
+	ReadTimeout       time.Duration `split_words:"true" default:"250ms"`
+	WriteTimeout      time.Duration `split_words:"true" default:"400ms"`
+	PoolTimeout       time.Duration `split_words:"true" default:"4s"`
+	MinRetryBackoff   time.Duration `split_words:"true" default:"20ms"`
+	MaxRetryBackoff   time.Duration `split_words:"true" default:"80ms"`
+	IdleTimeout       time.Duration `split_words:"true" default:"60s"`
+	ReadOnly          bool          `split_words:"true" default:"true"`
+	RouteRandomly     bool          `split_words:"true" default:"false"`
+	MasterName        string        `split_words:"true" default:"mymaster"`
+	SentinelAddresses []string      `split_words:"true" default:"127.0.0.1:26379"`
+	// SentinelPassword authenticates connections to sentinels, they're not authenticated when it's empty.
+	SentinelPassword string `split_words:"true" default:""`
+	// ShardedPubSub uses SPUBLISH and SSUBSCRIBE(Redis 7+) so cluster nodes don't broadcast messages to
+	// each other, each topic is served by the shard of its slot.
+	ShardedPubSub bool `split_words:"true" default:"false"`
+	TLS           tlsconfig.Configs
 }
 
 // NewClient is a factory function that creates and initializes a proper redis client.
 func NewClient(configs Configs) (redisClient redis.UniversalClient, err error) {
+	tlsConfig, err := newTLSConfig(configs)
+	if err != nil {
+		return nil, err
+	}
+
 	switch configs.Mode {
 	case SingleNode:
 		opts := &redis.Options{
-			Addr:               configs.Address,
-			Password:           configs.Password,
-			DB:                 configs.DB,
-			MaxRetries:         configs.MaxRetries,
-			MinRetryBackoff:    configs.MinRetryBackoff,
-			MaxRetryBackoff:    configs.MaxRetryBackoff,
-			DialTimeout:        configs.DialTimeout,
-			ReadTimeout:        configs.ReadTimeout,
-			WriteTimeout:       configs.WriteTimeout,
-			PoolSize:           configs.PoolSize,
-			PoolTimeout:        configs.PoolTimeout,
-			IdleTimeout:        configs.IdleTimeout,
-			IdleCheckFrequency: configs.IdleCheckFrequency,
+			Addr:            configs.Address,
+			Username:        configs.Username,
+			Password:        configs.Password,
+			DB:              configs.DB,
+			MaxRetries:      configs.MaxRetries,
+			MinRetryBackoff: configs.MinRetryBackoff,
+			MaxRetryBackoff: configs.MaxRetryBackoff,
+			DialTimeout:     configs.DialTimeout,
+			ReadTimeout:     configs.ReadTimeout,
+			WriteTimeout:    configs.WriteTimeout,
+			PoolSize:        configs.PoolSize,
+			PoolTimeout:     configs.PoolTimeout,
+			ConnMaxIdleTime: configs.IdleTimeout,
+			TLSConfig:       tlsConfig,
 		}
 		redisClient = redis.NewClient(opts)
 	case Cluster:
+		if len(configs.Addresses) == 0 {
+			return nil, fmt.Errorf("addresses of cluster cannot be empty")
+		}
 		opts := &redis.ClusterOptions{
-			Addrs:              []string{configs.MasterAddress, configs.SlaveAddress},
-			Password:           configs.Password,
-			MaxRetries:         configs.MaxRetries,
-			MinRetryBackoff:    configs.MinRetryBackoff,
-			MaxRetryBackoff:    configs.MaxRetryBackoff,
-			DialTimeout:        configs.DialTimeout,
-			ReadTimeout:        configs.ReadTimeout,
-			WriteTimeout:       configs.WriteTimeout,
-			PoolSize:           configs.PoolSize,
-			PoolTimeout:        configs.PoolTimeout,
-			IdleTimeout:        configs.IdleTimeout,
-			IdleCheckFrequency: configs.IdleCheckFrequency,
-			ReadOnly:           configs.ReadOnly,
-			RouteRandomly:      configs.RouteRandomly,
+			Addrs:           configs.Addresses,
+			Username:        configs.Username,
+			Password:        configs.Password,
+			MaxRetries:      configs.MaxRetries,
+			MinRetryBackoff: configs.MinRetryBackoff,
+			MaxRetryBackoff: configs.MaxRetryBackoff,
+			DialTimeout:     configs.DialTimeout,
+			ReadTimeout:     configs.ReadTimeout,
+			WriteTimeout:    configs.WriteTimeout,
+			PoolSize:        configs.PoolSize,
+			PoolTimeout:     configs.PoolTimeout,
+			ConnMaxIdleTime: configs.IdleTimeout,
+			ReadOnly:        configs.ReadOnly,
+			RouteRandomly:   configs.RouteRandomly,
+			TLSConfig:       tlsConfig,
 		}
 		redisClient = redis.NewClusterClient(opts)
+	case Sentinel:
+		opts := &redis.FailoverOptions{
+			MasterName:       configs.MasterName,
+			SentinelAddrs:    configs.SentinelAddresses,
+			SentinelPassword: configs.SentinelPassword,
+			Username:         configs.Username,
+			Password:         configs.Password,
+			DB:               configs.DB,
+			MaxRetries:       configs.MaxRetries,
+			MinRetryBackoff:  configs.MinRetryBackoff,
+			MaxRetryBackoff:  configs.MaxRetryBackoff,
+			DialTimeout:      configs.DialTimeout,
+			ReadTimeout:      configs.ReadTimeout,
+			WriteTimeout:     configs.WriteTimeout,
+			PoolSize:         configs.PoolSize,
+			PoolTimeout:      configs.PoolTimeout,
+			ConnMaxIdleTime:  configs.IdleTimeout,
+			TLSConfig:        tlsConfig,
+		}
+		redisClient = redis.NewFailoverClient(opts)
 	default:
 		return nil, fmt.Errorf("'%s' is not a valid mode", configs.Mode)
 	}
 	return
 }
+
+// newTLSConfig returns tls config of the client, it's nil when tls is not enabled.
+func newTLSConfig(configs Configs) (*tls.Config, error) {
+	if !configs.TLS.Enabled {
+		return nil, nil
+	}
+	tc, err := tlsconfig.NewClientConfig(configs.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating redis tls config, error: %s", err.Error())
+	}
+	return tc, nil
+}
@@ -1,8 +1,11 @@
 package redis_test
 
 import (
-	"github.com/go-redis/redis"
+	"context"
+	"github.com/alicebob/miniredis/v2"
 	. "github.com/mammadmodi/websub/pkg/redis"
+	"github.com/mammadmodi/websub/pkg/tlsconfig"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -10,23 +13,22 @@ import (
 
 func TestNewClient(t *testing.T) {
 	configs := Configs{
-		Address:            "127.0.0.1:6379",
-		MasterAddress:      "127.0.0.2:6379",
-		SlaveAddress:       "127.0.0.3:6379",
-		DB:                 5,
-		Password:           "12345678",
-		PoolSize:           10,
-		MaxRetries:         2,
-		DialTimeout:        15 * time.Second,
-		ReadTimeout:        4 * time.Second,
-		WriteTimeout:       4 * time.Second,
-		PoolTimeout:        60 * time.Second,
-		MinRetryBackoff:    15 * time.Millisecond,
-		MaxRetryBackoff:    512 * time.Millisecond,
-		IdleTimeout:        60 * time.Second,
-		IdleCheckFrequency: 30,
-		ReadOnly:           true,
-		RouteRandomly:      true,
+		Address:         "127.0.0.1:6379",
+		Addresses:       []string{"127.0.0.2:6379", "127.0.0.3:6379", "127.0.0.4:6379"},
+		Username:        "websub",
+		DB:              5,
+		Password:        "12345678",
+		PoolSize:        10,
+		MaxRetries:      2,
+		DialTimeout:     15 * time.Second,
+		ReadTimeout:     4 * time.Second,
+		WriteTimeout:    4 * time.Second,
+		PoolTimeout:     60 * time.Second,
+		MinRetryBackoff: 15 * time.Millisecond,
+		MaxRetryBackoff: 512 * time.Millisecond,
+		IdleTimeout:     60 * time.Second,
+		ReadOnly:        true,
+		RouteRandomly:   true,
 	}
 
 	t.Run("testing new client for single node redis", func(t *testing.T) {
@@ -41,6 +43,7 @@ func TestNewClient(t *testing.T) {
 		}
 		assert.Equal(t, configs.Address, sc.Options().Addr)
 		assert.Equal(t, configs.DB, sc.Options().DB)
+		assert.Equal(t, configs.Username, sc.Options().Username)
 		assert.Equal(t, configs.Password, sc.Options().Password)
 		assert.Equal(t, configs.PoolSize, sc.Options().PoolSize)
 		assert.Equal(t, configs.MaxRetries, sc.Options().MaxRetries)
@@ -50,8 +53,7 @@ func TestNewClient(t *testing.T) {
 		assert.Equal(t, configs.PoolTimeout, sc.Options().PoolTimeout)
 		assert.Equal(t, configs.MinRetryBackoff, sc.Options().MinRetryBackoff)
 		assert.Equal(t, configs.MaxRetryBackoff, sc.Options().MaxRetryBackoff)
-		assert.Equal(t, configs.IdleTimeout, sc.Options().IdleTimeout)
-		assert.Equal(t, configs.IdleCheckFrequency, sc.Options().IdleCheckFrequency)
+		assert.Equal(t, configs.IdleTimeout, sc.Options().ConnMaxIdleTime)
 	})
 
 	t.Run("testing new client for cluster redis", func(t *testing.T) {
@@ -64,7 +66,8 @@ func TestNewClient(t *testing.T) {
 			t.Error("redis client's type must be *redis.ClusterClient")
 			return
 		}
-		assert.Equal(t, []string{configs.MasterAddress, configs.SlaveAddress}, cc.Options().Addrs)
+		assert.Equal(t, configs.Addresses, cc.Options().Addrs)
+		assert.Equal(t, configs.Username, cc.Options().Username)
 		assert.Equal(t, configs.Password, cc.Options().Password)
 		assert.Equal(t, configs.PoolSize, cc.Options().PoolSize)
 		assert.Equal(t, configs.MaxRetries, cc.Options().MaxRetries)
@@ -74,12 +77,67 @@ func TestNewClient(t *testing.T) {
 		assert.Equal(t, configs.PoolTimeout, cc.Options().PoolTimeout)
 		assert.Equal(t, configs.MinRetryBackoff, cc.Options().MinRetryBackoff)
 		assert.Equal(t, configs.MaxRetryBackoff, cc.Options().MaxRetryBackoff)
-		assert.Equal(t, configs.IdleTimeout, cc.Options().IdleTimeout)
-		assert.Equal(t, configs.IdleCheckFrequency, cc.Options().IdleCheckFrequency)
+		assert.Equal(t, configs.IdleTimeout, cc.Options().ConnMaxIdleTime)
 		assert.Equal(t, configs.ReadOnly, cc.Options().ReadOnly)
 		assert.Equal(t, configs.RouteRandomly, cc.Options().RouteRandomly)
 	})
 
+	t.Run("testing new client for sentinel redis", func(t *testing.T) {
+		configs.Mode = Sentinel
+		configs.MasterName = "mymaster"
+		configs.SentinelAddresses = []string{"127.0.0.4:26379", "127.0.0.5:26379"}
+		c, err := NewClient(configs)
+		assert.NoError(t, err)
+		fc, ok := c.(*redis.Client)
+		if !assert.True(t, ok) {
+			t.Error("redis client's type must be *redis.Client")
+			return
+		}
+		assert.Equal(t, "FailoverClient", fc.Options().Addr)
+		assert.Equal(t, configs.DB, fc.Options().DB)
+		assert.Equal(t, configs.Password, fc.Options().Password)
+		assert.Equal(t, configs.PoolSize, fc.Options().PoolSize)
+		assert.Equal(t, configs.MaxRetries, fc.Options().MaxRetries)
+		assert.Equal(t, configs.DialTimeout, fc.Options().DialTimeout)
+	})
+
+	t.Run("testing new client with tls", func(t *testing.T) {
+		configs.Mode = SingleNode
+		configs.TLS = tlsconfig.Configs{Enabled: true, MinVersion: "1.2", ServerName: "redis"}
+		defer func() { configs.TLS = tlsconfig.Configs{} }()
+		c, err := NewClient(configs)
+		assert.NoError(t, err)
+		sc := c.(*redis.Client)
+		if assert.NotNil(t, sc.Options().TLSConfig) {
+			assert.Equal(t, "redis", sc.Options().TLSConfig.ServerName)
+		}
+
+		configs.TLS.MinVersion = "invalid"
+		_, err = NewClient(configs)
+		assert.Error(t, err)
+	})
+
+	t.Run("testing new client with acl user", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		s.RequireUserAuth("websub", "secret")
+		c, err := NewClient(Configs{Mode: SingleNode, Address: s.Addr(), Username: "websub", Password: "secret"})
+		assert.NoError(t, err)
+		assert.NoError(t, c.Ping(context.Background()).Err())
+
+		c, err = NewClient(Configs{Mode: SingleNode, Address: s.Addr(), Username: "websub", Password: "wrong"})
+		assert.NoError(t, err)
+		assert.Error(t, c.Ping(context.Background()).Err())
+	})
+
+	t.Run("testing new client for cluster redis without addresses", func(t *testing.T) {
+		configs.Mode = Cluster
+		addrs := configs.Addresses
+		configs.Addresses = nil
+		defer func() { configs.Addresses = addrs }()
+		_, err := NewClient(configs)
+		assert.Error(t, err)
+	})
+
 	t.Run("testing new client for invalid redis mode", func(t *testing.T) {
 		configs.Mode = "invalid_mode"
 		c, err := NewClient(configs)
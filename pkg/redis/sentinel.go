@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"net"
+	"strings"
+)
+
+// switchMasterChannel is the channel that sentinels publish master switches to.
+const switchMasterChannel = "+switch-master"
+
+// WatchFailover subscribes to master switches of all sentinels and calls onSwitch with address of the
+// new master each time the master of MasterName is switched, until ctx is done. Sentinel clients
+// reconnect to the new master by themselves, WatchFailover lets long lived subscriptions follow
+// the switch without waiting for their connections to fail.
+func WatchFailover(ctx context.Context, configs Configs, onSwitch func(addr string)) error {
+	if configs.Mode != Sentinel {
+		return fmt.Errorf("failover can only be watched in %s mode", Sentinel)
+	}
+	if len(configs.SentinelAddresses) == 0 {
+		return fmt.Errorf("sentinel addresses cannot be empty")
+	}
+
+	// sentinels are connected with the password and tls config of sentinel connections of the client.
+	tlsConfig, err := newTLSConfig(configs)
+	if err != nil {
+		return err
+	}
+
+	switches := make(chan string)
+	for _, addr := range configs.SentinelAddresses {
+		sc := redis.NewSentinelClient(&redis.Options{
+			Addr:         addr,
+			Password:     configs.SentinelPassword,
+			MaxRetries:   configs.MaxRetries,
+			DialTimeout:  configs.DialTimeout,
+			ReadTimeout:  configs.ReadTimeout,
+			WriteTimeout: configs.WriteTimeout,
+			TLSConfig:    tlsConfig,
+		})
+		ps := sc.Subscribe(ctx, switchMasterChannel)
+		defer func() {
+			_ = ps.Close()
+			_ = sc.Close()
+		}()
+		go func(ch <-chan *redis.Message) {
+			for m := range ch {
+				master, ok := parseSwitch(configs.MasterName, m.Payload)
+				if !ok {
+					continue
+				}
+				select {
+				case switches <- master:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ps.Channel())
+	}
+
+	// Each sentinel publishes the switch, so duplicates are dropped.
+	var master string
+	for {
+		select {
+		case addr := <-switches:
+			if addr != master {
+				master = addr
+				onSwitch(addr)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// parseSwitch parses a +switch-master payload like "<master name> <old ip> <old port> <new ip> <new port>"
+// and returns address of the new master if it belongs to masterName.
+func parseSwitch(masterName, payload string) (string, bool) {
+	parts := strings.Split(payload, " ")
+	if len(parts) != 5 || parts[0] != masterName {
+		return "", false
+	}
+	return net.JoinHostPort(parts[3], parts[4]), true
+}
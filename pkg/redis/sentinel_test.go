@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWatchFailover(t *testing.T) {
+	// Sentinels publish switches with PUBLISH, so miniredis acts as a sentinel.
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	assert.Error(t, WatchFailover(context.Background(), Configs{Mode: SingleNode}, nil))
+	assert.Error(t, WatchFailover(context.Background(), Configs{Mode: Sentinel}, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	switched := make(chan string, 10)
+	c := Configs{Mode: Sentinel, MasterName: "mymaster", SentinelAddresses: []string{s.Addr()}, DialTimeout: time.Second}
+	go func() {
+		_ = WatchFailover(ctx, c, func(addr string) { switched <- addr })
+	}()
+	for i := 0; i < 100 && s.PubSubNumSub(switchMasterChannel)[switchMasterChannel] == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Publish(switchMasterChannel, "othermaster 10.0.0.1 6379 10.0.0.2 6379")
+	s.Publish(switchMasterChannel, "mymaster 10.0.0.1 6379 10.0.0.3 6379")
+	s.Publish(switchMasterChannel, "mymaster 10.0.0.1 6379 10.0.0.3 6379")
+	s.Publish(switchMasterChannel, "mymaster 10.0.0.3 6379 10.0.0.1 6379")
+	assert.Equal(t, "10.0.0.3:6379", <-switched)
+	assert.Equal(t, "10.0.0.1:6379", <-switched)
+	select {
+	case addr := <-switched:
+		t.Errorf("unexpected switch to %s", addr)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatchFailover_Auth(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.RequireAuth("secret")
+
+	// Sentinels that require a password are watched with SentinelPassword.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	switched := make(chan string, 1)
+	c := Configs{Mode: Sentinel, MasterName: "mymaster", SentinelAddresses: []string{s.Addr()}, SentinelPassword: "secret", DialTimeout: time.Second}
+	go func() {
+		_ = WatchFailover(ctx, c, func(addr string) { switched <- addr })
+	}()
+	for i := 0; i < 100 && s.PubSubNumSub(switchMasterChannel)[switchMasterChannel] == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Publish(switchMasterChannel, "mymaster 10.0.0.1 6379 10.0.0.2 6379")
+	select {
+	case addr := <-switched:
+		assert.Equal(t, "10.0.0.2:6379", addr)
+	case <-time.After(time.Second):
+		t.Fatal("switch of authenticated sentinel is not received")
+	}
+
+	c.TLS.Enabled, c.TLS.CAFile = true, "/not/found.pem"
+	assert.Error(t, WatchFailover(ctx, c, nil))
+}
+
+func TestParseSwitch(t *testing.T) {
+	addr, ok := parseSwitch("mymaster", "mymaster 10.0.0.1 6379 ::1 6380")
+	assert.True(t, ok)
+	assert.Equal(t, "[::1]:6380", addr)
+
+	_, ok = parseSwitch("mymaster", "mymaster 10.0.0.1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,218 @@
+// Package stomp encodes and decodes STOMP 1.2 frames.
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Commands of client frames.
+const (
+	CONNECT     = "CONNECT"
+	STOMP       = "STOMP"
+	SEND        = "SEND"
+	SUBSCRIBE   = "SUBSCRIBE"
+	UNSUBSCRIBE = "UNSUBSCRIBE"
+	ACK         = "ACK"
+	NACK        = "NACK"
+	BEGIN       = "BEGIN"
+	COMMIT      = "COMMIT"
+	ABORT       = "ABORT"
+	DISCONNECT  = "DISCONNECT"
+)
+
+// Commands of server frames.
+const (
+	CONNECTED = "CONNECTED"
+	MESSAGE   = "MESSAGE"
+	RECEIPT   = "RECEIPT"
+	ERROR     = "ERROR"
+)
+
+// Version is the supported version of the protocol.
+const Version = "1.2"
+
+// ErrMalformed is returned when a frame violates the protocol.
+var ErrMalformed = errors.New("malformed stomp frame")
+
+// Frame is a STOMP frame, a frame without command is a heart-beat.
+type Frame struct {
+	Command string
+	// Header holds headers of the frame, the first value of a repeated header is kept.
+	Header map[string]string
+	Body   []byte
+}
+
+// NewFrame creates a frame of command with headers as key value pairs.
+func NewFrame(command string, headers ...string) *Frame {
+	f := &Frame{Command: command, Header: make(map[string]string, len(headers)/2)}
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.Header[headers[i]] = headers[i+1]
+	}
+	return f
+}
+
+// Get returns the value of a header.
+func (f *Frame) Get(key string) string {
+	return f.Header[key]
+}
+
+// Encode returns the frame on the wire, content-length is set when the frame has a body and headers are
+// sorted so frames are encoded the same way.
+func (f *Frame) Encode() []byte {
+	if f.Command == "" {
+		return []byte("\n")
+	}
+	b := &bytes.Buffer{}
+	b.WriteString(f.Command)
+	b.WriteByte('\n')
+	keys := make([]string, 0, len(f.Header))
+	for k := range f.Header {
+		if k != "content-length" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	// Headers of CONNECT and CONNECTED frames are not escaped for compatibility with STOMP 1.0.
+	escape := f.Command != CONNECT && f.Command != CONNECTED
+	for _, k := range keys {
+		v := f.Header[k]
+		if escape {
+			k, v = escaper.Replace(k), escaper.Replace(v)
+		}
+		b.WriteString(k + ":" + v + "\n")
+	}
+	if len(f.Body) > 0 {
+		b.WriteString("content-length:" + strconv.Itoa(len(f.Body)) + "\n")
+	}
+	b.WriteByte('\n')
+	b.Write(f.Body)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\r", `\r`, "\n", `\n`, ":", `\c`)
+
+// ReadFrame reads a frame, frames larger than maxSize are rejected when it's positive. An end of line
+// between frames is a heart-beat and is returned as a frame without command.
+func ReadFrame(r *bufio.Reader, maxSize int) (*Frame, error) {
+	size := 0
+	line := func() (string, error) {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && l != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if size += len(l); maxSize > 0 && size > maxSize {
+			return "", fmt.Errorf("stomp frame is larger than %d bytes", maxSize)
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(l, "\n"), "\r"), nil
+	}
+
+	command, err := line()
+	if err != nil {
+		return nil, err
+	}
+	f := &Frame{Command: command, Header: make(map[string]string)}
+	if command == "" {
+		return f, nil
+	}
+	escaped := command != CONNECT && command != CONNECTED
+	for {
+		l, err := line()
+		if err != nil {
+			return nil, err
+		}
+		if l == "" {
+			break
+		}
+		i := strings.IndexByte(l, ':')
+		if i < 0 {
+			return nil, ErrMalformed
+		}
+		k, v := l[:i], l[i+1:]
+		if escaped {
+			if k, err = unescape(k); err != nil {
+				return nil, err
+			}
+			if v, err = unescape(v); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := f.Header[k]; !ok {
+			f.Header[k] = v
+		}
+	}
+
+	if cl, ok := f.Header["content-length"]; ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 {
+			return nil, ErrMalformed
+		}
+		if maxSize > 0 && size+n > maxSize {
+			return nil, fmt.Errorf("stomp frame is larger than %d bytes", maxSize)
+		}
+		f.Body = make([]byte, n+1)
+		if _, err := io.ReadFull(r, f.Body); err != nil {
+			return nil, err
+		}
+		if f.Body[n] != 0 {
+			return nil, ErrMalformed
+		}
+		f.Body = f.Body[:n]
+		return f, nil
+	}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if c == 0 {
+			return f, nil
+		}
+		if size++; maxSize > 0 && size > maxSize {
+			return nil, fmt.Errorf("stomp frame is larger than %d bytes", maxSize)
+		}
+		f.Body = append(f.Body, c)
+	}
+}
+
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return "", ErrMalformed
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		default:
+			return "", ErrMalformed
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,72 @@
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFrame(t *testing.T) {
+	frames := []*Frame{
+		NewFrame(CONNECT, "accept-version", "1.2", "host", "websub", "login", "a:b"),
+		NewFrame(SEND, "destination", "/topic/a:b\n", "receipt", `r\1`),
+		{Command: ""},
+		NewFrame(MESSAGE, "subscription", "0", "message-id", "1"),
+	}
+	frames[1].Body = []byte("body\x00with nul")
+	frames[3].Body = []byte(`{"a":1}`)
+	buf := &bytes.Buffer{}
+	for _, f := range frames {
+		buf.Write(f.Encode())
+	}
+	r := bufio.NewReader(buf)
+	for _, want := range frames {
+		f, err := ReadFrame(r, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, want.Command, f.Command)
+		for k, v := range want.Header {
+			assert.Equal(t, v, f.Get(k))
+		}
+		assert.Equal(t, string(want.Body), string(f.Body))
+	}
+}
+
+func TestReadFrame_Body(t *testing.T) {
+	// Frames without content-length end with the first NUL, the first value of a repeated header is kept and
+	// CONNECT headers are not unescaped.
+	r := bufio.NewReader(strings.NewReader("SEND\r\ndestination:a\ndestination:b\n\nhello\x00\r\n\nCONNECT\nlogin:a\\c\n\n\x00"))
+	f, err := ReadFrame(r, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{Command: SEND, Header: map[string]string{"destination": "a"}, Body: []byte("hello")}, f)
+	for i := 0; i < 2; i++ {
+		f, err = ReadFrame(r, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, "", f.Command)
+	}
+	f, err = ReadFrame(r, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `a\c`, f.Get("login"))
+}
+
+func TestReadFrame_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"invalid escape":       "SEND\ndestination:a\\t\n\n\x00",
+		"header without colon": "SEND\ndestination\n\n\x00",
+		"invalid length":       "SEND\ncontent-length:x\n\n\x00",
+		"missing nul":          "SEND\ncontent-length:1\n\nab",
+	}
+	for name, c := range cases {
+		_, err := ReadFrame(bufio.NewReader(strings.NewReader(c)), 0)
+		assert.Equal(t, ErrMalformed, err, name)
+	}
+
+	_, err := ReadFrame(bufio.NewReader(strings.NewReader("SEND\n\nhello")), 0)
+	assert.Error(t, err)
+	_, err = ReadFrame(bufio.NewReader(strings.NewReader("SEND\n\n"+strings.Repeat("a", 100)+"\x00")), 50)
+	assert.Error(t, err)
+	_, err = ReadFrame(bufio.NewReader(strings.NewReader("SEND\ncontent-length:100\n\n")), 50)
+	assert.Error(t, err)
+}
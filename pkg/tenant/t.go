@@ -0,0 +1,252 @@
+// Package tenant isolates products that share a websub instance, topics of a tenant are prefixed with its
+// name and connections and messages of it are limited.
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Limits of tenants, they are labels of Limited.
+const (
+	ConnectionsLimit = "connections"
+	RateLimit        = "rate"
+	SizeLimit        = "size"
+)
+
+var (
+	// Limited counts connections and messages of tenants that are rejected by their limits.
+	Limited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "tenant",
+		Name:      "limited_total",
+		Help:      "Number of connections and messages of tenants that are rejected by their limits.",
+	}, []string{"tenant", "limit"})
+	// published counts messages that tenants publish.
+	published = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "websub",
+		Subsystem: "tenant",
+		Name:      "published_messages_total",
+		Help:      "Number of messages that tenants publish.",
+	}, []string{"tenant"})
+)
+
+// Errors of requests and messages that are not admitted.
+var (
+	ErrUnknown     = errors.New("tenant of the request is unknown")
+	ErrConnections = errors.New("connection limit of the tenant is reached")
+	ErrRate        = errors.New("message rate limit of the tenant is exceeded")
+	ErrSize        = errors.New("message is larger than the message size limit of the tenant")
+)
+
+// Configuration is used to load tenants.
+type Configuration struct {
+	// Enabled enables tenants, requests that are not resolved to a tenant are rejected when it's true.
+	Enabled bool `default:"false"`
+	// File is path of a json file that contains list of tenants.
+	File string `default:"./tenants.json"`
+}
+
+// Tenant is a product that has its own topics and limits, zero limits are unlimited.
+type Tenant struct {
+	// Name is the prefix of topics of the tenant, it must be one topic level, e.g. orders of tenant acme is
+	// acme.orders on the hub.
+	Name string `json:"name"`
+	// Tokens are auth tokens of the tenant, they are sent as bearer tokens or token query parameter.
+	Tokens []string `json:"tokens"`
+	// Hosts are hostnames of the tenant, requests without a token of a tenant are resolved by their host.
+	Hosts []string `json:"hosts"`
+	// MaxConnections is maximum number of connections of the tenant on each instance.
+	MaxConnections int `json:"max_connections"`
+	// MaxRate is maximum number of messages per second that the tenant publishes on each instance, Burst is
+	// number of messages that are published at once and it's MaxRate when it's zero.
+	MaxRate float64 `json:"max_rate"`
+	Burst   int     `json:"burst"`
+	// MaxMessageSize is maximum size of messages(in Bytes) that the tenant publishes.
+	MaxMessageSize int `json:"max_message_size"`
+
+	limiter *rate.Limiter
+	mu      sync.Mutex
+	conns   int
+}
+
+// Topic returns the hub topic of a topic of tenant, topics are not changed for the nil tenant.
+func (t *Tenant) Topic(topic string) string {
+	if t == nil {
+		return topic
+	}
+	return Topic(t.Name, topic)
+}
+
+// Topics returns hub topics of topics of tenant.
+func (t *Tenant) Topics(topics []string) []string {
+	if t == nil {
+		return topics
+	}
+	ts := make([]string, len(topics))
+	for i, topic := range topics {
+		ts[i] = t.Topic(topic)
+	}
+	return ts
+}
+
+// Strip returns the topic of tenant of a hub topic.
+func (t *Tenant) Strip(topic string) string {
+	if t == nil {
+		return topic
+	}
+	return strings.TrimPrefix(topic, t.Name+".")
+}
+
+// Label returns name of tenant for labels of metrics, it's empty for the nil tenant.
+func (t *Tenant) Label() string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+// Acquire reserves a connection of tenant, it returns ErrConnections when connections of tenant are at
+// their limit. Reserved connections are released with Release.
+func (t *Tenant) Acquire() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.MaxConnections > 0 && t.conns >= t.MaxConnections {
+		Limited.WithLabelValues(t.Name, ConnectionsLimit).Inc()
+		return ErrConnections
+	}
+	t.conns++
+	return nil
+}
+
+// Release releases a connection that is reserved by Acquire.
+func (t *Tenant) Release() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.conns--
+	t.mu.Unlock()
+}
+
+// Allow checks a message of size bytes that tenant publishes against its size and rate limits.
+func (t *Tenant) Allow(size int) error {
+	if t == nil {
+		return nil
+	}
+	if t.MaxMessageSize > 0 && size > t.MaxMessageSize {
+		Limited.WithLabelValues(t.Name, SizeLimit).Inc()
+		return ErrSize
+	}
+	if t.limiter != nil && !t.limiter.Allow() {
+		Limited.WithLabelValues(t.Name, RateLimit).Inc()
+		return ErrRate
+	}
+	published.WithLabelValues(t.Name).Inc()
+	return nil
+}
+
+// Topic returns the hub topic of a topic of the tenant with name.
+func Topic(name, topic string) string {
+	return name + "." + topic
+}
+
+// Registry resolves tenants of requests.
+type Registry struct {
+	tokens map[string]*Tenant
+	hosts  map[string]*Tenant
+}
+
+// Load reads tenants from a json file and creates their Registry.
+func Load(path string) (*Registry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading tenants file %s, error: %s", path, err.Error())
+	}
+	var tenants []*Tenant
+	if err := json.Unmarshal(b, &tenants); err != nil {
+		return nil, fmt.Errorf("error while decoding tenants file %s, error: %s", path, err.Error())
+	}
+	return NewRegistry(tenants...)
+}
+
+// NewRegistry creates a Registry of tenants, it returns an error when a tenant is not valid or tokens and
+// hosts are shared between tenants.
+func NewRegistry(tenants ...*Tenant) (*Registry, error) {
+	r := &Registry{
+		tokens: make(map[string]*Tenant),
+		hosts:  make(map[string]*Tenant),
+	}
+	names := make(map[string]bool)
+	for _, t := range tenants {
+		if t.Name == "" || strings.ContainsAny(t.Name, ".*> \t\r\n") {
+			return nil, fmt.Errorf("'%s' is not a valid tenant name", t.Name)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("tenant %s is duplicated", t.Name)
+		}
+		names[t.Name] = true
+		for _, token := range t.Tokens {
+			if _, ok := r.tokens[token]; ok || token == "" {
+				return nil, fmt.Errorf("token of tenant %s is empty or duplicated", t.Name)
+			}
+			r.tokens[token] = t
+		}
+		for _, host := range t.Hosts {
+			host = strings.ToLower(host)
+			if _, ok := r.hosts[host]; ok || host == "" {
+				return nil, fmt.Errorf("host '%s' of tenant %s is empty or duplicated", host, t.Name)
+			}
+			r.hosts[host] = t
+		}
+		if t.MaxRate > 0 {
+			burst := t.Burst
+			if burst <= 0 {
+				burst = int(math.Ceil(t.MaxRate))
+			}
+			t.limiter = rate.NewLimiter(rate.Limit(t.MaxRate), burst)
+		}
+	}
+	return r, nil
+}
+
+// Resolve returns the tenant of a request by its token or host, it returns ErrUnknown when the request has
+// no tenant. The nil Registry resolves requests to the nil tenant.
+func (r *Registry) Resolve(req *http.Request) (*Tenant, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if t, ok := r.tokens[Token(req)]; ok {
+		return t, nil
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if t, ok := r.hosts[strings.ToLower(host)]; ok {
+		return t, nil
+	}
+	return nil, ErrUnknown
+}
+
+// Token returns the bearer token or token query parameter of a request, browsers can't set headers of
+// websocket requests.
+func Token(req *http.Request) string {
+	if a := req.Header.Get("Authorization"); strings.HasPrefix(a, "Bearer ") {
+		return strings.TrimPrefix(a, "Bearer ")
+	}
+	return req.URL.Query().Get("token")
+}
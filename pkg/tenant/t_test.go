@@ -0,0 +1,106 @@
+package tenant
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistry_Resolve(t *testing.T) {
+	acme := &Tenant{Name: "acme", Tokens: []string{"acme-token"}, Hosts: []string{"acme.websub.io"}}
+	globex := &Tenant{Name: "globex", Tokens: []string{"globex-token"}}
+	r, err := NewRegistry(acme, globex)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	req := httptest.NewRequest("GET", "http://websub.io/socket/connect?token=globex-token", nil)
+	tn, err := r.Resolve(req)
+	assert.NoError(t, err)
+	assert.Equal(t, globex, tn)
+
+	// Tokens have priority over hosts.
+	req = httptest.NewRequest("GET", "http://ACME.websub.io:8379/socket/connect", nil)
+	req.Header.Set("Authorization", "Bearer globex-token")
+	tn, err = r.Resolve(req)
+	assert.NoError(t, err)
+	assert.Equal(t, globex, tn)
+	req.Header.Set("Authorization", "Bearer invalid")
+	tn, err = r.Resolve(req)
+	assert.NoError(t, err)
+	assert.Equal(t, acme, tn)
+
+	_, err = r.Resolve(httptest.NewRequest("GET", "http://websub.io/socket/connect?token=invalid", nil))
+	assert.Equal(t, ErrUnknown, err)
+
+	var nr *Registry
+	tn, err = nr.Resolve(req)
+	assert.NoError(t, err)
+	assert.Nil(t, tn)
+}
+
+func TestNewRegistry_Invalid(t *testing.T) {
+	cases := map[string][]*Tenant{
+		"empty name":      {{Name: ""}},
+		"invalid name":    {{Name: "a.b"}},
+		"duplicated name": {{Name: "a"}, {Name: "a"}},
+		"shared token":    {{Name: "a", Tokens: []string{"t"}}, {Name: "b", Tokens: []string{"t"}}},
+		"shared host":     {{Name: "a", Hosts: []string{"a.io"}}, {Name: "b", Hosts: []string{"A.io"}}},
+	}
+	for name, tenants := range cases {
+		_, err := NewRegistry(tenants...)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestTenant_Topic(t *testing.T) {
+	tn := &Tenant{Name: "acme"}
+	assert.Equal(t, "acme.orders.*", tn.Topic("orders.*"))
+	assert.Equal(t, []string{"acme.a", "acme.>"}, tn.Topics([]string{"a", ">"}))
+	assert.Equal(t, "orders.1", tn.Strip("acme.orders.1"))
+
+	var nt *Tenant
+	assert.Equal(t, "orders.*", nt.Topic("orders.*"))
+	assert.Equal(t, "acme.orders.1", nt.Strip("acme.orders.1"))
+	assert.Equal(t, "", nt.Label())
+}
+
+func TestTenant_Limits(t *testing.T) {
+	tn := &Tenant{Name: "limited", MaxConnections: 1, MaxRate: 2, MaxMessageSize: 4}
+	_, err := NewRegistry(tn)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, tn.Acquire())
+	assert.Equal(t, ErrConnections, tn.Acquire())
+	tn.Release()
+	assert.NoError(t, tn.Acquire())
+
+	assert.Equal(t, ErrSize, tn.Allow(5))
+	assert.NoError(t, tn.Allow(4))
+	assert.NoError(t, tn.Allow(1))
+	assert.Equal(t, ErrRate, tn.Allow(1))
+
+	var nt *Tenant
+	assert.NoError(t, nt.Acquire())
+	assert.NoError(t, nt.Allow(1<<20))
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`[{"name": "acme", "tokens": ["t"], "max_rate": 10}]`), 0600))
+	r, err := Load(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tn, err := r.Resolve(httptest.NewRequest("GET", "/?token=t", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", tn.Name)
+	assert.NotNil(t, tn.limiter)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
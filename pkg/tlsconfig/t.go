@@ -0,0 +1,182 @@
+// Package tlsconfig creates tls configs of servers and clients from file based configuration.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Configs is generic struct for tls configs.
+type Configs struct {
+	Enabled bool `default:"false"`
+	// CertFile and KeyFile are the certificate of servers or the client certificate of clients.
+	CertFile string `split_words:"true"`
+	KeyFile  string `split_words:"true"`
+	// CAFile verifies client certificates on servers(mTLS) and server certificates on clients,
+	// system roots are used by clients when it's empty.
+	CAFile     string `split_words:"true"`
+	MinVersion string `default:"1.2" split_words:"true"`
+	// ServerName overrides the host name that clients verify.
+	ServerName         string `split_words:"true"`
+	InsecureSkipVerify bool   `default:"false" split_words:"true"`
+	// ReloadInterval is interval of checking cert and key files for changes on servers.
+	ReloadInterval time.Duration `default:"10s" split_words:"true"`
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewServerConfig creates a tls config of a server which loads its certificate from the returned Reloader,
+// client certificates are required and verified when CAFile is set.
+func NewServerConfig(c Configs) (*tls.Config, *Reloader, error) {
+	tc, err := newConfig(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := NewReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tc.GetCertificate = r.GetCertificate
+	if c.CAFile != "" {
+		if tc.ClientCAs, err = loadPool(c.CAFile); err != nil {
+			return nil, nil, err
+		}
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, r, nil
+}
+
+// NewClientConfig creates a tls config of a client, the client certificate is optional.
+func NewClientConfig(c Configs) (*tls.Config, error) {
+	tc, err := newConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	tc.ServerName = c.ServerName
+	tc.InsecureSkipVerify = c.InsecureSkipVerify
+	if c.CAFile != "" {
+		if tc.RootCAs, err = loadPool(c.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading client certificate, error: %s", err.Error())
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+func newConfig(c Configs) (*tls.Config, error) {
+	v, ok := versions[c.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a valid tls version", c.MinVersion)
+	}
+	return &tls.Config{MinVersion: v}, nil
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading ca file %s, error: %s", caFile, err.Error())
+	}
+	p := x509.NewCertPool()
+	if !p.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("ca file %s doesn't contain any certificate", caFile)
+	}
+	return p, nil
+}
+
+// Reloader keeps a certificate and reloads it when its files are changed.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads a certificate and returns a Reloader for it.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, it can be used as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the certificate again if its files are modified since last load and reports whether
+// it's reloaded. The current certificate is kept when loading fails.
+func (r *Reloader) Reload() (bool, error) {
+	mt, err := r.lastModTime()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	changed := r.cert == nil || mt.After(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error while loading certificate, error: %s", err.Error())
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = mt
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch calls Reload on each interval until ctx is done, errors are passed to onError.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if _, err := r.Reload(); err != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Reloader) lastModTime() (time.Time, error) {
+	var mt time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return mt, fmt.Errorf("error while checking file %s, error: %s", f, err.Error())
+		}
+		if info.ModTime().After(mt) {
+			mt = info.ModTime()
+		}
+	}
+	return mt, nil
+}
@@ -0,0 +1,148 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert creates a certificate for 127.0.0.1 that is signed by parent(or self signed when parent is nil)
+// and writes it to dir, it returns paths of cert and key files.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	kb, _ := x509.MarshalECPrivateKey(key)
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	_ = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	return certFile, keyFile, cert, key
+}
+
+func TestNewServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _, ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	certFile, keyFile, _, _ := writeCert(t, dir, "server", ca, caKey)
+	clientCert, clientKey, _, _ := writeCert(t, dir, "client", ca, caKey)
+
+	t.Run("test invalid min version", func(t *testing.T) {
+		_, _, err := NewServerConfig(Configs{CertFile: certFile, KeyFile: keyFile, MinVersion: "2.0"})
+		assert.Error(t, err)
+	})
+
+	t.Run("test mutual tls", func(t *testing.T) {
+		sc, _, err := NewServerConfig(Configs{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, MinVersion: "1.2"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, tls.RequireAndVerifyClientCert, sc.ClientAuth)
+		assert.Equal(t, uint16(tls.VersionTLS12), sc.MinVersion)
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", sc)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer ln.Close()
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				_ = c.(*tls.Conn).Handshake()
+				_ = c.Close()
+			}
+		}()
+
+		cc, err := NewClientConfig(Configs{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey, MinVersion: "1.2"})
+		assert.NoError(t, err)
+		c, err := tls.Dial("tcp", ln.Addr().String(), cc)
+		if assert.NoError(t, err) {
+			assert.NoError(t, c.Handshake())
+			_ = c.Close()
+		}
+
+		// Clients without certificate are rejected.
+		cc, err = NewClientConfig(Configs{CAFile: caFile, MinVersion: "1.2"})
+		assert.NoError(t, err)
+		c, err = tls.Dial("tcp", ln.Addr().String(), cc)
+		if err == nil {
+			_, err = c.Read(make([]byte, 1))
+			_ = c.Close()
+		}
+		assert.Error(t, err)
+	})
+}
+
+func TestNewClientConfig(t *testing.T) {
+	_, err := NewClientConfig(Configs{MinVersion: "1.3", CAFile: "./not_exist"})
+	assert.Error(t, err)
+
+	c, err := NewClientConfig(Configs{MinVersion: "1.3", ServerName: "redis", InsecureSkipVerify: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "redis", c.ServerName)
+		assert.True(t, c.InsecureSkipVerify)
+		assert.Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first, _ := writeCert(t, dir, "server", nil, nil)
+	r, err := NewReloader(certFile, keyFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	c, _ := r.GetCertificate(nil)
+	assert.Equal(t, first.Raw, c.Certificate[0])
+
+	reloaded, err := r.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// Replaces files with a new certificate.
+	_, _, second, _ := writeCert(t, dir, "server", nil, nil)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond, func(err error) { t.Error(err) })
+	assert.Eventually(t, func() bool {
+		c, _ := r.GetCertificate(nil)
+		return string(second.Raw) == string(c.Certificate[0])
+	}, time.Second, 10*time.Millisecond)
+}